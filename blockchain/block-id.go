@@ -1,2 +1,4 @@
 package blockchain
 
+// Hash is the hash of a block
+type Hash []byte
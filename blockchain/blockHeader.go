@@ -0,0 +1,71 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+//区块头，区块去掉交易后的部分，交易由MerkleRoot代表
+//同步时先下载并验证区块头，再下载区块体
+type BlockHeader struct {
+	Timestamp  int64
+	Height     int
+	Hash       []byte
+	PrevHash   []byte
+	MerkleRoot []byte
+	Nonce      int
+}
+
+//方法列表
+//1.func (b *Block) Header() BlockHeader
+//2.func (h *BlockHeader) Validate() error
+//3.func (b *Block) ValidateBody(header *BlockHeader) error
+
+/*取区块的区块头*/
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{
+		Timestamp:  b.Timestamp,
+		Height:     b.Height,
+		Hash:       b.Hash,
+		PrevHash:   b.PrevHash,
+		MerkleRoot: b.HashTransactions(),
+		Nonce:      b.Nonce,
+	}
+}
+
+/*验证区块头的工作量证明，以及区块哈希确实由区块头计算得到*/
+func (h *BlockHeader) Validate() error {
+	var intHash big.Int
+
+	hash := sha256.Sum256(powData(h.PrevHash, h.MerkleRoot, h.Nonce))
+	if bytes.Compare(hash[:], h.Hash) != 0 {
+		return errors.New("block hash does not match header")
+	}
+
+	intHash.SetBytes(hash[:])
+	if intHash.Cmp(NewProof(nil).Target) != -1 {
+		return errors.New("block hash does not meet target")
+	}
+
+	return nil
+}
+
+/*验证区块体与已验证的区块头一致*/
+func (b *Block) ValidateBody(header *BlockHeader) error {
+	if bytes.Compare(b.Hash, header.Hash) != 0 || bytes.Compare(b.PrevHash, header.PrevHash) != 0 || b.Height != header.Height {
+		return errors.New("block does not match header")
+	}
+
+	//没有交易的区块无法计算默克尔根
+	if len(b.Transactions) == 0 {
+		return errors.New("block has no transactions")
+	}
+
+	if bytes.Compare(b.HashTransactions(), header.MerkleRoot) != 0 {
+		return errors.New("transactions do not match merkle root")
+	}
+
+	return nil
+}
@@ -17,16 +17,24 @@ import (
 
 const genesisData = "First Transaction from Genesis"
 
+var (
+	ErrOrphanBlock = errors.New("previous block is not found") //区块的前一区块不在本地链上
+	ErrBadHeight   = errors.New("block height does not follow previous block")
+	ErrBadCoinbase = errors.New("block must start with its only coinbase transaction")
+)
+
 type BlockChain struct {
 	//Blocks []*Block
 	LastHash []byte
 	Db       *badger.DB
+
+	listeners []func(block *Block) //新区块成为链尾时的回调
 }
 
 //方法列表
 //1.func InitBlockChain(address string) *BlockChain
 //2.func ContinueBlockChain(address string) *BlockChain
-//3.func (bc *BlockChain) AddBlock(transactions []*Transaction)
+//3.func (bc *BlockChain) AddBlock(block *Block) error
 //4.func (bc *BlockChain) Iterator() *BCIterator
 //5.func (bc *BlockChain) FindUnspentTransactions(pubKeyHash []byte) []Transaction
 //6.func (bc *BlockChain) FindUTXO(pubKeyHash []byte) []TXOutput
@@ -35,6 +43,10 @@ type BlockChain struct {
 //9.func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey)
 //10.func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool
 //11.func (bc *BlockChain) FindUTXO2() map[string]TXOutputs
+//12.func (bc *BlockChain) OnNewBlock(fn func(block *Block))
+//13.func (bc *BlockChain) MissingInputs(tx *Transaction) [][]byte
+//14.func (bc *BlockChain) GetTotalDifficulty() uint64
+//15.func (bc *BlockChain) ValidateTransactions(block *Block) error
 
 //TODO:参数
 /*创建带有创世区块的区块链，创世区块需指定创世区块coinbase收款人地址*/
@@ -70,6 +82,9 @@ func InitBlockChain(address, nodeId string) *BlockChain {
 		utils.Handle(err)
 		//安排一个键值对用来存储链上最新区块的哈希，在工程代码里常称为lasthash、lh
 		err = txn.Set([]byte("lh"), genesis.Hash)
+		utils.Handle(err)
+		//主链索引
+		err = setMainChain(txn, genesis)
 
 		lastHash = genesis.Hash
 
@@ -79,7 +94,7 @@ func InitBlockChain(address, nodeId string) *BlockChain {
 	utils.Handle(err)
 
 	//创建BlockChain对象并返回
-	blockChain := BlockChain{lastHash, db}
+	blockChain := BlockChain{LastHash: lastHash, Db: db}
 	return &blockChain
 }
 
@@ -114,7 +129,8 @@ func ContinueBlockChain(nodeId string) *BlockChain {
 	utils.Handle(err)
 
 	//创建并返回BlockChain对象
-	blockChain := BlockChain{lastHash, db}
+	blockChain := BlockChain{LastHash: lastHash, Db: db}
+	blockChain.ensureHeightIndex()
 
 	return &blockChain
 }
@@ -155,6 +171,8 @@ func (bc *BlockChain) MineBlock(transactions []*Transaction) *Block {
 		err := txn.Set(newBlock.Hash, newBlock.Serialize())
 		utils.Handle(err)
 		err = txn.Set([]byte("lh"), newBlock.Hash)
+		utils.Handle(err)
+		err = setMainChain(txn, newBlock)
 
 		bc.LastHash = newBlock.Hash
 
@@ -162,24 +180,39 @@ func (bc *BlockChain) MineBlock(transactions []*Transaction) *Block {
 	})
 	utils.Handle(err)
 
+	bc.notify(newBlock)
+
 	return newBlock
 }
 
 /*向区块链中 添加 新区块*/
 //这个主要是用于当从别的节点接收最新区块时，将这些区块加入到本地区块链
-func (bc *BlockChain) AddBlock(block *Block) {
+//前一区块不在本地时返回ErrOrphanBlock，区块不会被存入，调用方应先取得前一区块
+func (bc *BlockChain) AddBlock(block *Block) error {
+	tipChanged := false
+
 	err := bc.Db.Update(func(txn *badger.Txn) error {
 		if _, err := txn.Get(block.Hash); err == nil {
 			return nil
 		}
 
+		//检查前一区块存在且高度相接
+		item, err := txn.Get(block.PrevHash)
+		if err != nil {
+			return ErrOrphanBlock
+		}
+		prevData, _ := item.Value()
+		if Deserialize(prevData).Height+1 != block.Height {
+			return ErrBadHeight
+		}
+
 		//将区块存入
 		blockData := block.Serialize()
-		err := txn.Set(block.Hash, blockData)
+		err = txn.Set(block.Hash, blockData)
 		utils.Handle(err)
 
 		//取出最后区块
-		item, err := txn.Get([]byte("lh"))
+		item, err = txn.Get([]byte("lh"))
 		utils.Handle(err)
 		lastHash, _ := item.Value()
 		item, err = txn.Get(lastHash)
@@ -191,12 +224,36 @@ func (bc *BlockChain) AddBlock(block *Block) {
 		if block.Height > lastBlock.Height {
 			err := txn.Set([]byte("lh"), block.Hash)
 			utils.Handle(err)
+			//新区块可能来自分叉，主链索引要改写到分叉点
+			err = setMainChain(txn, block)
+			utils.Handle(err)
 			bc.LastHash = block.Hash
+			tipChanged = true
 		}
 
 		return nil
 	})
-	utils.Handle(err)
+	if err != nil {
+		return err
+	}
+
+	if tipChanged {
+		bc.notify(block)
+	}
+
+	return nil
+}
+
+/*注册新区块回调，区块被挖出或被添加为新的链尾时调用，用以增量更新钱包账本等*/
+func (bc *BlockChain) OnNewBlock(fn func(block *Block)) {
+	bc.listeners = append(bc.listeners, fn)
+}
+
+/*依次调用新区块回调*/
+func (bc *BlockChain) notify(block *Block) {
+	for _, fn := range bc.listeners {
+		fn(block)
+	}
 }
 
 /*从区块链中查询区块*/
@@ -261,6 +318,12 @@ func (bc *BlockChain) GetBestHeight() int {
 	return lastBlock.Height
 }
 
+/*返回链上所有区块的累计工作量*/
+//每个区块的难度都是Difficulty，工作量为2^Difficulty，累计工作量即(高度+1)*2^Difficulty
+func (bc *BlockChain) GetTotalDifficulty() uint64 {
+	return uint64(bc.GetBestHeight()+1) << Difficulty
+}
+
 /*返回区块链迭代器对象*/
 func (bc *BlockChain) Iterator() *BCIterator {
 	iter := &BCIterator{bc.LastHash, bc.Db}
@@ -502,9 +565,12 @@ func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
 	//获取所有来源交易
 	prevTXs := make(map[string]Transaction)
 
+	//来源交易不在链上或引用的输出不存在时视为无效交易，而不是让进程崩溃（交易可能来自其他节点）
 	for _, in := range tx.TXInputs {
 		prevTX, err := bc.FindTransaction(in.ID)
-		utils.Handle(err)
+		if err != nil || in.Out < 0 || in.Out >= len(prevTX.TXOutputs) {
+			return false
+		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
@@ -512,6 +578,119 @@ func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
 	return tx.Verify(prevTXs)
 }
 
+/*验证区块中的交易：第一笔且只有第一笔是Coinbase交易，其余交易的来源输出在区块所在分支上存在且未被花费，签名有效*/
+//从前一区块回溯到创世区块，分叉上的区块按它自己的分支验证；同一区块内的交易不能互相花费
+func (bc *BlockChain) ValidateTransactions(block *Block) error {
+	txs := block.Transactions
+	if len(txs) == 0 || !txs[0].IsCoinbase() {
+		return ErrBadCoinbase
+	}
+
+	//区块所在分支上的交易，以及已被花费的输出（交易ID:输出序号）
+	branchTxs := make(map[string]*Transaction)
+	spent := make(map[string]bool)
+	err := bc.Db.View(func(txn *badger.Txn) error {
+		for hash := block.PrevHash; len(hash) > 0; {
+			item, err := txn.Get(hash)
+			if err != nil {
+				return ErrOrphanBlock
+			}
+			blockData, _ := item.Value()
+			prev := Deserialize(blockData)
+			for _, tx := range prev.Transactions {
+				branchTxs[hex.EncodeToString(tx.ID)] = tx
+				if tx.IsCoinbase() {
+					continue
+				}
+				for _, in := range tx.TXInputs {
+					spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] = true
+				}
+			}
+			hash = prev.PrevHash
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	reward := 0
+	for _, out := range txs[0].TXOutputs {
+		if out.Value < 0 {
+			return fmt.Errorf("coinbase transaction %x has a negative output", txs[0].ID)
+		}
+		reward += out.Value
+	}
+	if !txs[0].VerifyID() || reward > blockReward {
+		return fmt.Errorf("invalid coinbase transaction %x", txs[0].ID)
+	}
+
+	for _, tx := range txs[1:] {
+		if tx.IsCoinbase() {
+			return ErrBadCoinbase
+		}
+		if !tx.VerifyID() {
+			return fmt.Errorf("transaction %x does not match its ID", tx.ID)
+		}
+
+		prevTXs := make(map[string]Transaction)
+		inputs := 0
+		for _, in := range tx.TXInputs {
+			prevTX, ok := branchTxs[hex.EncodeToString(in.ID)]
+			if !ok || in.Out < 0 || in.Out >= len(prevTX.TXOutputs) {
+				return fmt.Errorf("transaction %x spends a missing output %x:%d", tx.ID, in.ID, in.Out)
+			}
+			outpoint := fmt.Sprintf("%x:%d", in.ID, in.Out)
+			if spent[outpoint] {
+				return fmt.Errorf("transaction %x double spends %s", tx.ID, outpoint)
+			}
+			spent[outpoint] = true
+			inputs += prevTX.TXOutputs[in.Out].Value
+			prevTXs[hex.EncodeToString(in.ID)] = *prevTX
+		}
+
+		outputs := 0
+		for _, out := range tx.TXOutputs {
+			if out.Value < 0 {
+				return fmt.Errorf("transaction %x has a negative output", tx.ID)
+			}
+			outputs += out.Value
+		}
+		if outputs > inputs {
+			return fmt.Errorf("transaction %x spends more than its inputs", tx.ID)
+		}
+
+		if !tx.Verify(prevTXs) {
+			return fmt.Errorf("transaction %x has an invalid signature", tx.ID)
+		}
+	}
+
+	return nil
+}
+
+/*返回交易的来源交易中不在链上的那些交易的ID，Coinbase交易没有来源交易*/
+func (bc *BlockChain) MissingInputs(tx *Transaction) [][]byte {
+	var missing [][]byte
+	if tx.IsCoinbase() {
+		return missing
+	}
+
+	seen := make(map[string]bool)
+	for _, in := range tx.TXInputs {
+		key := string(in.ID)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if _, err := bc.FindTransaction(in.ID); err != nil {
+			missing = append(missing, in.ID)
+		}
+	}
+
+	return missing
+}
+
 /*开启数据库失败时调用*/
 func retry(dir string, originOpts badger.Options) (*badger.DB, error) {
 
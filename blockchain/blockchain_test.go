@@ -0,0 +1,61 @@
+package blockchain
+
+import "testing"
+
+//区块只能以唯一的Coinbase交易开头，其余交易不能重复花费、越权花费或签名无效
+func TestValidateTransactions(t *testing.T) {
+	tc := newTestChain(t)
+	defer tc.close()
+
+	mined := tc.mine(30).Transactions[1]
+	UTXOSet := UTXOSet{tc.chain}
+	spend := func(amount int) *Transaction {
+		return NewTransaction(tc.wallets.WalletsMap[tc.a], tc.b, amount, &UTXOSet)
+	}
+	cb := CoinbaseTx(tc.a, "")
+
+	//用b的密钥花费a的输出
+	stolen := *spend(10)
+	stolen.TXInputs = append([]TXInput(nil), stolen.TXInputs...)
+	for i := range stolen.TXInputs {
+		stolen.TXInputs[i].PubKey = tc.wallets.WalletsMap[tc.b].WPublicKey
+		stolen.TXInputs[i].Signature = nil
+	}
+	stolen.ID = stolen.Hash()
+	tc.chain.SignTransaction(&stolen, tc.wallets.WalletsMap[tc.b].WPrivateKey)
+
+	badSig := *spend(10)
+	badSig.TXInputs = append([]TXInput(nil), badSig.TXInputs...)
+	badSig.TXInputs[0].Signature = append([]byte(nil), badSig.TXInputs[0].Signature...)
+	badSig.TXInputs[0].Signature[0] ^= 0xff
+
+	bigCb := CoinbaseTx(tc.a, "")
+	bigCb.TXOutputs[0].Value = blockReward + 1
+	bigCb.ID = bigCb.Hash()
+
+	cases := map[string]struct {
+		txs   []*Transaction
+		valid bool
+	}{
+		"valid":                {[]*Transaction{cb, spend(10)}, true},
+		"coinbase only":        {[]*Transaction{cb}, true},
+		"empty":                {nil, false},
+		"coinbase not first":   {[]*Transaction{spend(10), cb}, false},
+		"two coinbases":        {[]*Transaction{cb, CoinbaseTx(tc.a, "")}, false},
+		"coinbase too large":   {[]*Transaction{bigCb}, false},
+		"spent on chain":       {[]*Transaction{cb, mined}, false},
+		"spent in block":       {[]*Transaction{cb, spend(10), spend(20)}, false},
+		"invalid signature":    {[]*Transaction{cb, &badSig}, false},
+		"someone else's coins": {[]*Transaction{cb, &stolen}, false},
+	}
+
+	for name, c := range cases {
+		block := &Block{PrevHash: tc.chain.LastHash, Height: tc.chain.GetBestHeight() + 1, Transactions: c.txs}
+		err := tc.chain.ValidateTransactions(block)
+		if c.valid && err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package blockchain
 
-import "os"
+import (
+	"fmt"
+	"os"
+)
 
 const (
 	dbPath = "./tmp/blocks/blocks_%s"
@@ -13,3 +16,8 @@ func DbExists(path string) bool {
 	}
 	return true
 }
+
+/*检查nodeId对应的区块链数据库是否存在*/
+func ChainExists(nodeId string) bool {
+	return DbExists(fmt.Sprintf(dbPath, nodeId))
+}
@@ -0,0 +1,159 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"github.com/dgraph-io/badger"
+)
+
+const reindexBatch = 1000 //重建索引时每个数据库事务写入的条数
+
+var heightPrefix = []byte("height-") //主链索引：高度 -> 主链上该高度的区块哈希
+
+//方法列表
+//1.func (bc *BlockChain) GetBlockHashAtHeight(height int) ([]byte, error)
+//2.func (bc *BlockChain) ReindexHeights()
+
+/*主链索引中高度对应的键，高度按大端序编码，键的顺序即高度的顺序*/
+func heightKey(height int) []byte {
+	key := make([]byte, len(heightPrefix)+8)
+	copy(key, heightPrefix)
+	binary.BigEndian.PutUint64(key[len(heightPrefix):], uint64(height))
+
+	return key
+}
+
+/*返回主链上该高度的区块哈希，高度超出链尾时返回错误*/
+func (bc *BlockChain) GetBlockHashAtHeight(height int) ([]byte, error) {
+	var hash []byte
+
+	err := bc.Db.View(func(txn *badger.Txn) error {
+		var err error
+		hash, err = mainChainHash(txn, height)
+		return err
+	})
+
+	return hash, err
+}
+
+func mainChainHash(txn *badger.Txn, height int) ([]byte, error) {
+	item, err := txn.Get(heightKey(height))
+	if err != nil {
+		return nil, err
+	}
+	hash, err := item.Value()
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), hash...), nil
+}
+
+/*在事务中读取区块*/
+func getBlock(txn *badger.Txn, hash []byte) (*Block, error) {
+	item, err := txn.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	blockData, err := item.Value()
+	if err != nil {
+		return nil, err
+	}
+
+	return Deserialize(blockData), nil
+}
+
+/*在事务中读取链尾区块*/
+func tipBlock(txn *badger.Txn) (*Block, error) {
+	item, err := txn.Get([]byte("lh"))
+	if err != nil {
+		return nil, err
+	}
+	lastHash, err := item.Value()
+	if err != nil {
+		return nil, err
+	}
+
+	return getBlock(txn, lastHash)
+}
+
+/*区块成为链尾后更新主链索引：从该区块向前改写索引，直到遇见已在主链上的区块*/
+//新链尾总是比原链尾高，原主链上高于分叉点的索引都会被新分支覆盖
+func setMainChain(txn *badger.Txn, block *Block) error {
+	hash, height, prevHash := block.Hash, block.Height, block.PrevHash
+
+	for {
+		if old, err := mainChainHash(txn, height); err == nil && bytes.Equal(old, hash) {
+			return nil
+		}
+		if err := txn.Set(heightKey(height), hash); err != nil {
+			return err
+		}
+		if len(prevHash) == 0 {
+			return nil
+		}
+
+		prev, err := getBlock(txn, prevHash)
+		if err != nil {
+			return err
+		}
+		hash, height, prevHash = prev.Hash, prev.Height, prev.PrevHash
+	}
+}
+
+/*索引中链尾高度对应的不是链尾区块时（如旧版本创建的数据库），从链尾向前重建主链索引*/
+func (bc *BlockChain) ensureHeightIndex() {
+	var indexed bool
+	err := bc.Db.View(func(txn *badger.Txn) error {
+		tip, err := tipBlock(txn)
+		if err != nil {
+			return err
+		}
+		hash, err := mainChainHash(txn, tip.Height)
+		indexed = err == nil && bytes.Equal(hash, tip.Hash)
+
+		return nil
+	})
+	utils.Handle(err)
+
+	if !indexed {
+		bc.ReindexHeights()
+	}
+}
+
+/*从链尾向前遍历主链，重写高度索引。链很长时分多个事务写入*/
+func (bc *BlockChain) ReindexHeights() {
+	var hashes [][]byte
+	var heights []int
+
+	flush := func() {
+		err := bc.Db.Update(func(txn *badger.Txn) error {
+			for i := range hashes {
+				if err := txn.Set(heightKey(heights[i]), hashes[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		utils.Handle(err)
+
+		hashes, heights = nil, nil
+	}
+
+	iter := bc.Iterator()
+	for {
+		block := iter.Next()
+
+		hashes = append(hashes, block.Hash)
+		heights = append(heights, block.Height)
+		if len(hashes) == reindexBatch {
+			flush()
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	flush()
+}
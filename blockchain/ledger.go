@@ -0,0 +1,106 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
+)
+
+//方法列表
+//1.func (b *Block) ApplyToLedger(ledger *wallet.Ledger, owned map[string]string)
+//2.func (bc *BlockChain) SyncLedger(ledger *wallet.Ledger, owned map[string]string)
+//3.func (bc *BlockChain) SyncWalletLedger(nodeId string) *wallet.Ledger
+
+/*将区块中与本钱包相关的交易记入账本*/
+//owned为钱包的 公钥哈希(hex) -> 地址 映射
+//每个相关地址按 收到的输出 减去 花费的输出 得到净额，净额为负记为付款，为正记为收款
+//这样找零不会被记为收款，钱包内地址间的转账也会在两边各记一笔
+func (b *Block) ApplyToLedger(ledger *wallet.Ledger, owned map[string]string) {
+	for _, tx := range b.Transactions {
+		var addresses []string
+		amounts := make(map[string]int)
+		add := func(address string, value int) {
+			if _, seen := amounts[address]; !seen {
+				addresses = append(addresses, address)
+			}
+			amounts[address] += value
+		}
+
+		//检查交易输入是否花费了本钱包的输出
+		if tx.IsCoinbase() == false {
+			for _, in := range tx.TXInputs {
+				if out, ok := ledger.SpendOutput(in.ID, in.Out, b.Height); ok {
+					add(out.Address, -out.Value)
+				}
+			}
+		}
+
+		//检查交易输出是否属于本钱包
+		for outIdx, out := range tx.TXOutputs {
+			address, ok := owned[hex.EncodeToString(out.PubKeyHash)]
+			if !ok {
+				continue
+			}
+
+			ledger.AddOutput(tx.ID, outIdx, address, out.Value, b.Height)
+			add(address, out.Value)
+		}
+
+		for _, address := range addresses {
+			amount := amounts[address]
+			switch {
+			case amount < 0:
+				ledger.AddRecord(b.newRecord(tx, address, wallet.CategorySend, -amount))
+			case amount > 0 && tx.IsCoinbase():
+				ledger.AddRecord(b.newRecord(tx, address, wallet.CategoryGenerate, amount))
+			case amount > 0:
+				ledger.AddRecord(b.newRecord(tx, address, wallet.CategoryReceive, amount))
+			}
+		}
+	}
+
+	ledger.TipHash = b.Hash
+	ledger.TipHeight = b.Height
+}
+
+func (b *Block) newRecord(tx *Transaction, address, category string, amount int) *wallet.TxRecord {
+	return &wallet.TxRecord{
+		TxID:      tx.ID,
+		Address:   address,
+		Category:  category,
+		Amount:    amount,
+		Height:    b.Height,
+		BlockHash: b.Hash,
+		Timestamp: b.Timestamp,
+	}
+}
+
+/*将账本从其记录的区块同步到当前链尾*/
+//从链尾向前遍历直到遇见账本记录的区块，再按高度从低到高依次记账
+//若遍历至创世区块仍未遇见，说明链已切换，清空账本后从创世区块重建
+func (bc *BlockChain) SyncLedger(ledger *wallet.Ledger, owned map[string]string) {
+	blocks, found := bc.blocksAfter(ledger.TipHash)
+	if !found {
+		ledger.Reset()
+	}
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		blocks[i].ApplyToLedger(ledger, owned)
+	}
+
+	//同步到链尾即完成了之前被中断的重新扫描
+	ledger.Rescanning = false
+}
+
+/*读取nodeId对应的钱包和账本，同步账本并保存。钱包文件不存在时返回nil*/
+func (bc *BlockChain) SyncWalletLedger(nodeId string) *wallet.Ledger {
+	wallets, err := wallet.CreateWallets(nodeId)
+	if err != nil {
+		return nil
+	}
+
+	ledger := wallet.CreateLedger(nodeId)
+	bc.SyncLedger(ledger, wallets.GetPubKeyHashes())
+	ledger.SaveFile(nodeId)
+
+	return ledger
+}
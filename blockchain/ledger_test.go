@@ -0,0 +1,225 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
+)
+
+//测试用区块链：在临时目录中创建，创世区块奖励属于钱包地址a
+type testChain struct {
+	t       *testing.T
+	chain   *BlockChain
+	wallets *wallet.Wallets
+	a, b    string //钱包中的两个地址
+	dir     string
+	wd      string
+}
+
+/*在临时目录中创建区块链和含两个地址的钱包。测试结束时须调用close*/
+func newTestChain(t *testing.T) *testChain {
+	dir, err := ioutil.TempDir("", "blockchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//区块链保存在当前目录下的tmp中
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("tmp/blocks", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tc := &testChain{t: t, dir: dir, wd: wd}
+	tc.wallets = &wallet.Wallets{WalletsMap: make(map[string]*wallet.Wallet), WatchOnlyMap: make(map[string]*wallet.WatchOnly)}
+	tc.a = tc.wallets.AddWallet()
+	tc.b = tc.wallets.AddWallet()
+
+	tc.chain = InitBlockChain(tc.a, "test")
+	UTXOSet := UTXOSet{tc.chain}
+	UTXOSet.Reindex()
+
+	return tc
+}
+
+/*关闭数据库，删除临时目录*/
+func (tc *testChain) close() {
+	tc.chain.Db.Close()
+	os.Chdir(tc.wd)
+	os.RemoveAll(tc.dir)
+}
+
+/*挖出一个区块，coinbase奖励给a，amount不为0时附带一笔a转给b的交易*/
+func (tc *testChain) mine(amount int) *Block {
+	UTXOSet := UTXOSet{tc.chain}
+	txs := []*Transaction{CoinbaseTx(tc.a, "")}
+	if amount > 0 {
+		txs = append(txs, NewTransaction(tc.wallets.WalletsMap[tc.a], tc.b, amount, &UTXOSet))
+	}
+
+	block := tc.chain.MineBlock(txs)
+	UTXOSet.Update(block)
+
+	return block
+}
+
+/*在prev之后挖出n个只含coinbase的区块并加入区块链，用以制造分叉*/
+func (tc *testChain) fork(prev *Block, n int) *Block {
+	for i := 0; i < n; i++ {
+		block := CreateBlock([]*Transaction{CoinbaseTx(tc.b, "")}, prev.Hash, prev.Height+1)
+		if err := tc.chain.AddBlock(block); err != nil {
+			tc.t.Fatal(err)
+		}
+		prev = block
+	}
+
+	return prev
+}
+
+/*从创世区块同步出的新账本*/
+func (tc *testChain) freshLedger() *wallet.Ledger {
+	ledger := &wallet.Ledger{}
+	ledger.Reset()
+	tc.chain.SyncLedger(ledger, tc.wallets.GetPubKeyHashes())
+
+	return ledger
+}
+
+/*账本的深拷贝，相当于保存后重新加载*/
+func copyLedger(t *testing.T, ledger *wallet.Ledger) *wallet.Ledger {
+	var buff bytes.Buffer
+	if err := gob.NewEncoder(&buff).Encode(ledger); err != nil {
+		t.Fatal(err)
+	}
+
+	var copied wallet.Ledger
+	if err := gob.NewDecoder(&buff).Decode(&copied); err != nil {
+		t.Fatal(err)
+	}
+	if copied.Outputs == nil {
+		copied.Outputs = make(map[string]*wallet.LedgerOutput)
+	}
+
+	return &copied
+}
+
+/*检查两个账本的交易记录、未花费输出和同步位置相同*/
+func checkSameLedger(t *testing.T, got, want *wallet.Ledger) {
+	t.Helper()
+
+	if !reflect.DeepEqual(got.GetRecords(""), want.GetRecords("")) {
+		t.Errorf("records differ: got %d, want %d", len(got.Records), len(want.Records))
+	}
+	if !reflect.DeepEqual(got.GetUnspent(""), want.GetUnspent("")) {
+		t.Errorf("unspent outputs differ: got %d, want %d", len(got.GetUnspent("")), len(want.GetUnspent("")))
+	}
+	if !bytes.Equal(got.TipHash, want.TipHash) || got.TipHeight != want.TipHeight {
+		t.Errorf("tip %x at %d, want %x at %d", got.TipHash, got.TipHeight, want.TipHash, want.TipHeight)
+	}
+}
+
+/*检查账本中每个地址的余额与区块链的未花费输出一致*/
+func checkBalances(t *testing.T, tc *testChain, ledger *wallet.Ledger) {
+	t.Helper()
+
+	for pubKeyHash, address := range tc.wallets.GetPubKeyHashes() {
+		hash, _ := hex.DecodeString(pubKeyHash)
+		want := 0
+		for _, out := range tc.chain.FindUTXO(hash) {
+			want += out.Value
+		}
+
+		if got := ledger.Balance(address); got != want {
+			t.Errorf("balance of %s = %d, want %d", address, got, want)
+		}
+	}
+}
+
+func TestApplyToLedger(t *testing.T) {
+	tc := newTestChain(t)
+	defer tc.close()
+
+	block := tc.mine(30)
+	ledger := tc.freshLedger()
+
+	if ledger.TipHeight != 1 || !bytes.Equal(ledger.TipHash, block.Hash) {
+		t.Fatalf("ledger tip %x at %d, want %x at 1", ledger.TipHash, ledger.TipHeight, block.Hash)
+	}
+	checkBalances(t, tc, ledger)
+
+	//a的找零不记为收款，a只记付款
+	type entry struct {
+		address, category string
+		amount            int
+	}
+	var got []entry
+	for _, record := range ledger.GetRecords("") {
+		if record.Height == 1 {
+			got = append(got, entry{record.Address, record.Category, record.Amount})
+		}
+	}
+	want := []entry{
+		{tc.a, wallet.CategoryGenerate, 100},
+		{tc.a, wallet.CategorySend, 30},
+		{tc.b, wallet.CategoryReceive, 30},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records at height 1 = %v, want %v", got, want)
+	}
+}
+
+func TestSyncLedger(t *testing.T) {
+	tc := newTestChain(t)
+	defer tc.close()
+
+	tc.mine(10)
+	ledger := tc.freshLedger()
+
+	//增量同步的结果与从头同步相同
+	tc.mine(20)
+	tc.mine(0)
+	ledger.Rescanning = true
+	tc.chain.SyncLedger(ledger, tc.wallets.GetPubKeyHashes())
+
+	if ledger.Rescanning {
+		t.Error("sync to the tip should finish the rescan")
+	}
+	checkSameLedger(t, ledger, tc.freshLedger())
+	checkBalances(t, tc, ledger)
+}
+
+func TestSyncLedgerReorg(t *testing.T) {
+	tc := newTestChain(t)
+	defer tc.close()
+
+	base := tc.mine(0)
+	tc.mine(10)
+	tc.mine(20)
+	ledger := tc.freshLedger()
+
+	//更长的分叉成为主链，账本所在区块不在链上，需要重建
+	tip := tc.fork(base, 3)
+	if !bytes.Equal(tc.chain.LastHash, tip.Hash) {
+		t.Fatal("fork did not become the best chain")
+	}
+
+	tc.chain.SyncLedger(ledger, tc.wallets.GetPubKeyHashes())
+
+	checkSameLedger(t, ledger, tc.freshLedger())
+	checkBalances(t, tc, ledger)
+	for _, record := range ledger.GetRecords(tc.b) {
+		if record.Category == wallet.CategoryReceive {
+			t.Errorf("payment %x from the stale branch still recorded", record.TxID)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"github.com/dgraph-io/badger"
+)
+
+//方法列表
+//1.func (bc *BlockChain) HasBlock(blockHash []byte) bool
+//2.func (bc *BlockChain) GetLocator(max int) [][]byte
+//3.func (bc *BlockChain) GetHeadersAfter(locator [][]byte, max int) []BlockHeader
+
+/*检查区块是否已在数据库中*/
+func (bc *BlockChain) HasBlock(blockHash []byte) bool {
+	_, err := bc.GetBlock(blockHash)
+
+	return err == nil
+}
+
+/*生成区块定位器：从链尾开始，间隔按指数增长地取区块哈希，最后一个总是创世区块*/
+//对方据此找到双方共同的最高区块，只需传输至多max个哈希
+func (bc *BlockChain) GetLocator(max int) [][]byte {
+	var locator [][]byte
+
+	err := bc.Db.View(func(txn *badger.Txn) error {
+		tip, err := tipBlock(txn)
+		if err != nil {
+			return err
+		}
+
+		step := 1
+		for height := tip.Height; height > 0 && len(locator) < max-1; height -= step {
+			hash, err := mainChainHash(txn, height)
+			if err != nil {
+				return err
+			}
+			locator = append(locator, hash)
+
+			//前10个区块逐个取，之后间隔翻倍
+			if len(locator) >= 10 {
+				step *= 2
+			}
+		}
+
+		genesis, err := mainChainHash(txn, 0)
+		locator = append(locator, genesis)
+
+		return err
+	})
+	utils.Handle(err)
+
+	return locator
+}
+
+/*找到定位器中位于本地主链上的最高区块，返回其后至多max个主链区块的区块头*/
+//定位器中没有本地主链上的区块时返回nil。借助主链索引只读取定位到的区块和返回的区块
+func (bc *BlockChain) GetHeadersAfter(locator [][]byte, max int) []BlockHeader {
+	var headers []BlockHeader
+
+	err := bc.Db.View(func(txn *badger.Txn) error {
+		found := -1
+		for _, hash := range locator {
+			//只查询区块哈希长度的键，对方无法借定位器读取其他数据
+			if len(hash) != sha256.Size {
+				continue
+			}
+			block, err := getBlock(txn, hash)
+			if err != nil || block.Height <= found {
+				continue
+			}
+			if mainHash, err := mainChainHash(txn, block.Height); err == nil && bytes.Equal(mainHash, hash) {
+				found = block.Height
+			}
+		}
+		if found < 0 {
+			return nil
+		}
+
+		//沿主链索引向后读取，超出链尾即停止
+		for height := found + 1; len(headers) < max; height++ {
+			hash, err := mainChainHash(txn, height)
+			if err == badger.ErrKeyNotFound {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			block, err := getBlock(txn, hash)
+			if err != nil {
+				return err
+			}
+			headers = append(headers, block.Header())
+		}
+
+		return nil
+	})
+	utils.Handle(err)
+
+	return headers
+}
@@ -0,0 +1,79 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+/*检查主链索引与从链尾向前遍历得到的主链一致*/
+func checkHeightIndex(t *testing.T, tc *testChain) {
+	t.Helper()
+
+	hashes := tc.chain.GetBlockHashes()
+	for i, hash := range hashes {
+		height := len(hashes) - 1 - i
+		if indexed, err := tc.chain.GetBlockHashAtHeight(height); err != nil || !bytes.Equal(indexed, hash) {
+			t.Errorf("index at %d = %x (%v), want %x", height, indexed, err, hash)
+		}
+	}
+	if _, err := tc.chain.GetBlockHashAtHeight(len(hashes)); err == nil {
+		t.Errorf("index has an entry above the tip at %d", len(hashes))
+	}
+}
+
+func TestGetHeadersAfter(t *testing.T) {
+	tc := newTestChain(t)
+	defer tc.close()
+
+	base := tc.mine(0)
+	for i := 0; i < 3; i++ {
+		tc.mine(0)
+	}
+	hashes := tc.chain.GetBlockHashes() //高度从高到低
+
+	//对方在base处，返回其后的区块头
+	headers := tc.chain.GetHeadersAfter([][]byte{base.Hash}, 10)
+	if len(headers) != 3 || headers[0].Height != 2 || !bytes.Equal(headers[2].Hash, hashes[0]) {
+		t.Fatalf("headers after height 1: %v", headers)
+	}
+	if headers := tc.chain.GetHeadersAfter([][]byte{base.Hash}, 2); len(headers) != 2 || headers[1].Height != 3 {
+		t.Errorf("headers limited to 2: %v", headers)
+	}
+	if headers := tc.chain.GetHeadersAfter([][]byte{hashes[0]}, 10); len(headers) != 0 {
+		t.Errorf("headers after the tip: %v", headers)
+	}
+
+	//未知的哈希和非区块哈希的键被忽略
+	unknown := bytes.Repeat([]byte{1}, 32)
+	if headers := tc.chain.GetHeadersAfter([][]byte{unknown, []byte("lh")}, 10); headers != nil {
+		t.Errorf("headers for an unknown locator: %v", headers)
+	}
+
+	//分叉成为主链后，原主链上的区块不再能定位，定位器从共同的最高区块继续
+	tip := tc.fork(base, 4)
+	checkHeightIndex(t, tc)
+	headers = tc.chain.GetHeadersAfter([][]byte{hashes[0], hashes[1], base.Hash}, 10)
+	if len(headers) != 4 || !bytes.Equal(headers[3].Hash, tip.Hash) {
+		t.Errorf("headers after the reorg: %v", headers)
+	}
+
+	locator := tc.chain.GetLocator(32)
+	if !bytes.Equal(locator[0], tip.Hash) || !bytes.Equal(locator[len(locator)-1], hashes[len(hashes)-1]) {
+		t.Errorf("locator %x", locator)
+	}
+}
+
+func TestReindexHeights(t *testing.T) {
+	tc := newTestChain(t)
+	defer tc.close()
+
+	base := tc.mine(0)
+	tc.mine(0)
+	tc.fork(base, 2)
+
+	//删除索引，相当于旧版本创建的数据库，重新打开时重建
+	UTXOSet{tc.chain}.DeleteByPrefix(heightPrefix)
+	tc.chain.Db.Close()
+	tc.chain = ContinueBlockChain("test")
+	checkHeightIndex(t, tc)
+}
@@ -45,10 +45,16 @@ func NewProof(b *Block) *ProofOfWork {
 
 /*初始化数据，将区块数据拼接成字节数组*/
 func (pow *ProofOfWork) InitData(nonce int) []byte {
+	//注意此时没有Hash，需要后边计算再赋进来
+	return powData(pow.Block.PrevHash, pow.Block.HashTransactions(), nonce)
+}
+
+/*将参与工作量证明的区块头字段拼接成字节数组，区块头验证时无需区块体也可计算*/
+func powData(prevHash, merkleRoot []byte, nonce int) []byte {
 	data := bytes.Join(
 		[][]byte{
-			pow.Block.PrevHash,
-			pow.Block.HashTransactions(), //注意此时没有Hash，需要后边计算再赋进来
+			prevHash,
+			merkleRoot,
 			utils.ToHex(int64(nonce)),
 			utils.ToHex(int64(Difficulty)),
 		},
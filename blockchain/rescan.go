@@ -0,0 +1,146 @@
+package blockchain
+
+import (
+	"bytes"
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
+)
+
+const rescanCheckpoint = 100 //每扫描这么多个区块保存一次账本，中断后可从保存处继续
+
+//重新扫描的进度回调，height为刚扫描完的区块高度，tipHeight为链尾高度
+type RescanProgress func(height, tipHeight int)
+
+//方法列表
+//1.func (bc *BlockChain) RescanWallet(nodeId string, from int, resume bool, progress RescanProgress) *wallet.Ledger
+//2.func (bc *BlockChain) Rescan(ledger *wallet.Ledger, owned map[string]string, from int, checkpoint func(), progress RescanProgress)
+//3.func (bc *BlockChain) ResumeRescan(ledger *wallet.Ledger, owned map[string]string, checkpoint func(), progress RescanProgress)
+
+/*读取nodeId对应的钱包和账本，从from高度重新扫描区块链并保存账本。钱包文件不存在时返回nil*/
+//resume为true时忽略from，从上次中断的重新扫描保存的位置继续
+func (bc *BlockChain) RescanWallet(nodeId string, from int, resume bool, progress RescanProgress) *wallet.Ledger {
+	wallets, err := wallet.CreateWallets(nodeId)
+	if err != nil {
+		return nil
+	}
+
+	ledger := wallet.CreateLedger(nodeId)
+	checkpoint := func() {
+		ledger.SaveFile(nodeId)
+	}
+
+	if resume {
+		bc.ResumeRescan(ledger, wallets.GetPubKeyHashes(), checkpoint, progress)
+	} else {
+		bc.Rescan(ledger, wallets.GetPubKeyHashes(), from, checkpoint, progress)
+	}
+
+	return ledger
+}
+
+/*撤销账本中高度不低于from的内容，再从from开始依次扫描区块，重建钱包的未花费输出和交易记录*/
+//owned为钱包的 公钥哈希(hex) -> 地址 映射，扫描过程中每隔rescanCheckpoint个区块调用一次checkpoint
+//若账本本身落后于from或不在当前链上，则从账本能够衔接的高度开始扫描
+func (bc *BlockChain) Rescan(ledger *wallet.Ledger, owned map[string]string, from int, checkpoint func(), progress RescanProgress) {
+	//账本所在区块不在当前链上时，只能从创世区块重建
+	_, found := bc.blocksAfter(ledger.TipHash)
+	if !found {
+		from = 0
+	} else if from > ledger.TipHeight+1 {
+		from = ledger.TipHeight + 1
+	}
+
+	var blocks []*Block
+	if from <= 0 {
+		ledger.Reset()
+		blocks = bc.blocksFrom(0)
+	} else {
+		blocks = bc.blocksFrom(from)
+		ledger.Rewind(from)
+		if len(blocks) > 0 {
+			//最低的区块的前一区块即账本回退后所在的区块
+			ledger.TipHash = blocks[len(blocks)-1].PrevHash
+		}
+	}
+
+	ledger.Rescanning = true
+	checkpoint()
+
+	bc.scanBlocks(ledger, owned, blocks, checkpoint, progress)
+}
+
+/*从账本保存的位置继续扫描至链尾。若该位置已不在当前链上，则从创世区块重建*/
+func (bc *BlockChain) ResumeRescan(ledger *wallet.Ledger, owned map[string]string, checkpoint func(), progress RescanProgress) {
+	blocks, found := bc.blocksAfter(ledger.TipHash)
+	if !found {
+		ledger.Reset()
+	}
+
+	ledger.Rescanning = true
+	checkpoint()
+
+	bc.scanBlocks(ledger, owned, blocks, checkpoint, progress)
+}
+
+/*按高度从低到高依次记账，blocks按高度从高到低排列*/
+func (bc *BlockChain) scanBlocks(ledger *wallet.Ledger, owned map[string]string, blocks []*Block, checkpoint func(), progress RescanProgress) {
+	tipHeight := ledger.TipHeight
+	if len(blocks) > 0 {
+		tipHeight = blocks[0].Height
+	}
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		blocks[i].ApplyToLedger(ledger, owned)
+
+		if progress != nil {
+			progress(blocks[i].Height, tipHeight)
+		}
+		if i > 0 && (len(blocks)-i)%rescanCheckpoint == 0 {
+			checkpoint()
+		}
+	}
+
+	ledger.Rescanning = false
+	checkpoint()
+}
+
+/*从链尾向前收集区块直到遇见哈希为tipHash的区块（不含该区块）*/
+//若遍历至创世区块仍未遇见则返回全部区块和false
+func (bc *BlockChain) blocksAfter(tipHash []byte) ([]*Block, bool) {
+	var blocks []*Block
+
+	iter := bc.Iterator()
+	for {
+		block := iter.Next()
+
+		if tipHash != nil && bytes.Compare(block.Hash, tipHash) == 0 {
+			return blocks, true
+		}
+
+		blocks = append(blocks, block)
+
+		if len(block.PrevHash) == 0 {
+			return blocks, false
+		}
+	}
+}
+
+/*从链尾向前收集高度不低于height的区块*/
+func (bc *BlockChain) blocksFrom(height int) []*Block {
+	var blocks []*Block
+
+	iter := bc.Iterator()
+	for {
+		block := iter.Next()
+		if block.Height < height {
+			break
+		}
+
+		blocks = append(blocks, block)
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return blocks
+}
@@ -0,0 +1,133 @@
+package blockchain
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
+)
+
+//模拟重新扫描在某高度被中断
+type errInterrupted int
+
+/*重新扫描，扫描完高度stop的区块后中断，返回中断时最后保存的账本*/
+func interruptedRescan(t *testing.T, tc *testChain, ledger *wallet.Ledger, from, stop int) (saved *wallet.Ledger) {
+	defer func() {
+		if r := recover(); r != errInterrupted(stop) {
+			t.Fatalf("rescan not interrupted at %d: %v", stop, r)
+		}
+	}()
+
+	checkpoint := func() {
+		saved = copyLedger(t, ledger)
+	}
+	progress := func(height, tipHeight int) {
+		if height == stop {
+			panic(errInterrupted(stop))
+		}
+	}
+	tc.chain.Rescan(ledger, tc.wallets.GetPubKeyHashes(), from, checkpoint, progress)
+
+	return saved
+}
+
+/*继续重新扫描，返回扫描的区块高度*/
+func resumeRescan(tc *testChain, ledger *wallet.Ledger) []int {
+	var heights []int
+	progress := func(height, tipHeight int) {
+		heights = append(heights, height)
+	}
+	tc.chain.ResumeRescan(ledger, tc.wallets.GetPubKeyHashes(), func() {}, progress)
+
+	return heights
+}
+
+func TestRescanFromHeight(t *testing.T) {
+	tc := newTestChain(t)
+	defer tc.close()
+
+	tc.mine(10)
+	tc.mine(20)
+	tc.mine(0)
+	tc.mine(5)
+
+	for from := 0; from <= 5; from++ {
+		ledger := tc.freshLedger()
+		checkpoints := 0
+		var heights []int
+		tc.chain.Rescan(ledger, tc.wallets.GetPubKeyHashes(), from, func() { checkpoints++ }, func(height, tipHeight int) {
+			heights = append(heights, height)
+		})
+
+		var want []int
+		for h := from; h <= 4; h++ {
+			want = append(want, h)
+		}
+		if !reflect.DeepEqual(heights, want) {
+			t.Errorf("rescan from %d scanned %v, want %v", from, heights, want)
+		}
+		if checkpoints < 2 || ledger.Rescanning {
+			t.Errorf("rescan from %d: %d checkpoints, rescanning %v", from, checkpoints, ledger.Rescanning)
+		}
+		checkSameLedger(t, ledger, tc.freshLedger())
+	}
+}
+
+func TestRescanResume(t *testing.T) {
+	tc := newTestChain(t)
+	defer tc.close()
+
+	tc.mine(10)
+	tc.mine(20)
+	tc.mine(0)
+	tc.mine(5)
+
+	//中断后从最后保存的账本继续，已撤销的高度都要重新扫描
+	ledger := tc.freshLedger()
+	saved := interruptedRescan(t, tc, ledger, 1, 3)
+	if !saved.Rescanning || saved.TipHeight != 0 {
+		t.Fatalf("saved ledger at %d, rescanning %v, want at 0 and rescanning", saved.TipHeight, saved.Rescanning)
+	}
+	if heights := resumeRescan(tc, saved); !reflect.DeepEqual(heights, []int{1, 2, 3, 4}) {
+		t.Errorf("resume from the checkpoint scanned %v", heights)
+	}
+	if saved.Rescanning {
+		t.Error("resumed rescan did not finish")
+	}
+	checkSameLedger(t, saved, tc.freshLedger())
+	checkBalances(t, tc, saved)
+
+	//中断时账本停在已扫描的最后一个区块，从其后继续
+	if !ledger.Rescanning || ledger.TipHeight != 3 {
+		t.Fatalf("interrupted ledger at %d, rescanning %v", ledger.TipHeight, ledger.Rescanning)
+	}
+	if heights := resumeRescan(tc, ledger); !reflect.DeepEqual(heights, []int{4}) {
+		t.Errorf("resume from the interrupted ledger scanned %v", heights)
+	}
+	checkSameLedger(t, ledger, tc.freshLedger())
+}
+
+func TestRescanReorg(t *testing.T) {
+	tc := newTestChain(t)
+	defer tc.close()
+
+	base := tc.mine(0)
+	tc.mine(10)
+	tc.mine(20)
+
+	//重新扫描中断后链发生了切换，保存的位置不在链上，从创世区块重建
+	ledger := tc.freshLedger()
+	saved := interruptedRescan(t, tc, ledger, 3, 3)
+	tc.fork(base, 3)
+
+	if heights := resumeRescan(tc, saved); !reflect.DeepEqual(heights, []int{0, 1, 2, 3, 4}) {
+		t.Errorf("resume after the reorg scanned %v", heights)
+	}
+	checkSameLedger(t, saved, tc.freshLedger())
+	checkBalances(t, tc, saved)
+
+	//从某高度重新扫描时，账本中被切换掉的区块也要撤销
+	tc.chain.Rescan(ledger, tc.wallets.GetPubKeyHashes(), 3, func() {}, nil)
+	checkSameLedger(t, ledger, tc.freshLedger())
+	checkBalances(t, tc, ledger)
+}
@@ -16,6 +16,8 @@ import (
 	"strings"
 )
 
+const blockReward = 100 //出块奖励
+
 type Transaction struct {
 	ID        []byte //即交易哈西
 	TXInputs  []TXInput
@@ -33,6 +35,8 @@ type Transaction struct {
 //8.func (tx *Transaction) TrimmedCopy() Transaction
 //9.func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool
 //10.func (tx Transaction) String() string
+//11.func NewUnsignedTransaction(pubKey, pubKeyHash []byte, from, to string, amount int, UTXO *UTXOSet) *Transaction
+//12.func (tx *Transaction) VerifyID() bool
 
 func DeserializeTransaction(data []byte) Transaction {
 
@@ -67,7 +71,7 @@ func CoinbaseTx(to, data string) *Transaction {
 
 	//Coinbase来源交易不存在，所以填空字节，其来源交易占来源输出序号也不存在，这里以-1表示
 	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(100, to)
+	txout := NewTXOutput(blockReward, to)
 
 	//Coinbase交易只有一笔输入一笔输出，其交易ID或者说哈希需要进行哈希才能得到
 	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
@@ -79,11 +83,20 @@ func CoinbaseTx(to, data string) *Transaction {
 
 /*产生一笔新交易*/
 func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Transaction {
-	var inputs []TXInput   //当前交易的输入
-	var outputs []TXOutput //当前交易输出
+	from := fmt.Sprintf("%s", w.Address())
 
+	tx := NewUnsignedTransaction(w.WPublicKey, wallet.PublicKeyHash(w.WPublicKey), from, to, amount, UTXO)
+	//对交易进行签名
+	UTXO.UBlockChain.SignTransaction(tx, w.WPrivateKey)
 
-	pubKeyHash := wallet.PublicKeyHash(w.WPublicKey)
+	return tx
+}
+
+/*产生一笔未签名的交易，用于只读地址等本机不持有私钥的情况*/
+//pubKey可以为空（只导入了地址），此时交易输入中不含公钥，需由签名方补上
+func NewUnsignedTransaction(pubKey, pubKeyHash []byte, from, to string, amount int, UTXO *UTXOSet) *Transaction {
+	var inputs []TXInput   //当前交易的输入
+	var outputs []TXOutput //当前交易输出
 
 	//用户进行转账时，需指定转账者A，被转账者B以及转账金额S
 	//主要需要考虑以下几点：
@@ -103,12 +116,11 @@ func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Tra
 		utils.Handle(err)
 
 		for _, out := range outs {
-			input := TXInput{txID, out, nil, w.WPublicKey}
+			input := TXInput{txID, out, nil, pubKey}
 			inputs = append(inputs, input)
 		}
 	}
 
-	from := fmt.Sprintf("%s", w.Address())
 	outputs = append(outputs, *NewTXOutput(amount, to))
 
 	//增加找零的交易输出
@@ -118,8 +130,6 @@ func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Tra
 
 	tx := Transaction{nil, inputs, outputs}
 	tx.ID = tx.Hash()
-	//对交易进行签名
-	UTXO.UBlockChain.SignTransaction(&tx, w.WPrivateKey)
 
 	return &tx
 }
@@ -149,6 +159,18 @@ func (tx *Transaction) Hash() []byte {
 	return hash[:]
 }
 
+/*检查交易ID与交易内容相符。ID在签名前计算，因此去掉签名后再计算哈希*/
+func (tx *Transaction) VerifyID() bool {
+	txCopy := *tx
+	txCopy.TXInputs = make([]TXInput, len(tx.TXInputs))
+	for i, in := range tx.TXInputs {
+		in.Signature = nil
+		txCopy.TXInputs[i] = in
+	}
+
+	return bytes.Equal(txCopy.Hash(), tx.ID)
+}
+
 //TODO:理解
 /*使用私钥对交易的来源交易字典进行签名*/
 func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
@@ -176,7 +198,11 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 
 		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
 		utils.Handle(err)
-		signature := append(r.Bytes(), s.Bytes()...)
+		//r、s各补足32字节，验证时从中间分开
+		signature := make([]byte, 64)
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rBytes):32], rBytes)
+		copy(signature[64-len(sBytes):], sBytes)
 
 		tx.TXInputs[inId].Signature = signature
 
@@ -222,6 +248,10 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 
 	for inId, in := range tx.TXInputs {
 		prevTx := prevTXs[hex.EncodeToString(in.ID)]
+		//输入中的公钥必须是来源输出锁定的公钥，否则任何人都能用自己的密钥花费别人的输出
+		if !in.UsesKey(prevTx.TXOutputs[in.Out].PubKeyHash) {
+			return false
+		}
 		txCopy.TXInputs[inId].Signature = nil
 		txCopy.TXInputs[inId].PubKey = prevTx.TXOutputs[in.Out].PubKeyHash
 		txCopy.ID = txCopy.Hash()
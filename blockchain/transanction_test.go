@@ -0,0 +1,52 @@
+package blockchain
+
+import "testing"
+
+func TestVerifyID(t *testing.T) {
+	tc := newTestChain(t)
+	defer tc.close()
+
+	//签名后的交易和coinbase交易的ID都与内容相符
+	tx := tc.mine(30).Transactions[1]
+	if !tx.VerifyID() || !CoinbaseTx(tc.a, "").VerifyID() {
+		t.Fatal("valid transaction id rejected")
+	}
+
+	forged := *tx
+	forged.TXOutputs = append([]TXOutput(nil), tx.TXOutputs...)
+	forged.TXOutputs[0].Value++
+	if forged.VerifyID() {
+		t.Error("id accepted after the outputs changed")
+	}
+
+	forged = *tx
+	forged.ID = CoinbaseTx(tc.a, "").ID
+	if forged.VerifyID() {
+		t.Error("id of another transaction accepted")
+	}
+}
+
+//r或s有前导零字节时签名仍为64字节，能通过验证
+func TestSignatureLength(t *testing.T) {
+	tc := newTestChain(t)
+	defer tc.close()
+
+	tx := tc.mine(30).Transactions[1]
+	w, err := tc.wallets.GetWallet(tc.a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 300; i++ {
+		signed := *tx
+		signed.TXInputs = append([]TXInput(nil), tx.TXInputs...)
+		tc.chain.SignTransaction(&signed, w.WPrivateKey)
+		for _, in := range signed.TXInputs {
+			if len(in.Signature) != 64 {
+				t.Fatalf("signature of %d bytes", len(in.Signature))
+			}
+		}
+		if !tc.chain.VerifyTransaction(&signed) {
+			t.Fatalf("signature %d rejected", i)
+		}
+	}
+}
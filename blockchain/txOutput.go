@@ -2,7 +2,7 @@ package blockchain
 
 import (
 	"bytes"
-	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
 )
 
 //当前交易的输出，是其下个交易的输入，或者未花费
@@ -33,12 +33,9 @@ type TXOutput struct {
 //本质是转账者将接收者地址转换成公钥哈希放进交易输出；接收者需要去用自己的私钥匹配（对其解锁）
 func (out *TXOutput) Lock(address []byte) {
 
-	//对传入的钱包地址进行解码得到解码前的 公钥哈希、版本号、校验码拼接成的字节串
-	pubKeyHash := utils.Base58Decode(address)
-	//丢掉字节串的最后3个字节（校验码）和最前头1个字节（版本号），
+	//对传入的钱包地址进行解码，去掉网络前缀、版本号和校验码，
 	//得到真正的经过sha256和ripemd160的公钥哈希
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+	out.PubKeyHash = wallet.AddressToPubKeyHash(string(address))
 }
 
 /*检查交易输出是否上锁*/
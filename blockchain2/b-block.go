@@ -5,9 +5,11 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/secp256k1zkp"
 	"github.com/sirupsen/logrus"
 	"github.com/yoss22/bulletproofs"
 	"io"
+	"math/big"
 	"sort"
 )
 
@@ -100,15 +102,9 @@ func (b *Block) Read(r io.Reader) error {
 		return err
 	}
 
-	// 检查inputs/outputs/kernels的数目是否异常
-	if inputs > 1000000 {
-		return errors.New("transaction contains too many inputs")
-	}
-	if outputs > 1000000 {
-		return errors.New("transaction contains too many outputs")
-	}
-	if kernels > 1000000 {
-		return errors.New("transaction contains too many kernels")
+	// 检查inputs/outputs/kernels的数目是否异常：区块的重量不能超过上限
+	if !fitsBlockWeight(inputs, outputs, kernels) {
+		return errors.New("block exceeds the maximum weight")
 	}
 
 	// 根据前边得到的长度读取inputs
@@ -149,18 +145,20 @@ func (b *Block) Hash() Hash {
 	return b.Header.Hash()
 }
 
-// Validate returns nil if block successfully passed BLOCK-SCOPE consensus rules
-func (b *Block) Validate() error {
+// Validate returns nil if block successfully passed BLOCK-SCOPE consensus rules.
+// prev is the header of the previous block, nil for the genesis block.
+func (b *Block) Validate(prev *BlockHeader) error {
 	logrus.Info("block scope validate")
-	/*
-		TODO: implement it:
 
-		verify_weight()
-		verify_sorted()
-		verify_coinbase()
-		verify_kernels()
+	// 区块必须紧接在prev之后
+	if err := b.verifyPrevious(prev); err != nil {
+		return err
+	}
 
-	*/
+	// 区块的重量不能超过上限
+	if err := b.verifyWeight(); err != nil {
+		return err
+	}
 
 	// 验证区块头内容及其中的工作量证明
 	if err := b.Header.Validate(); err != nil {
@@ -186,13 +184,26 @@ func (b *Block) Validate() error {
 		return err
 	}
 
-	if err := b.verifyKernels(); err != nil {
+	if err := b.verifyKernels(prev); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// Weight returns the weight of the block counted against MaxBlockWeight
+func (b *Block) Weight() uint64 {
+	return BlockWeight(uint64(len(b.Inputs)), uint64(len(b.Outputs)), uint64(len(b.Kernels)))
+}
+
+func (b *Block) verifyWeight() error {
+	if weight := b.Weight(); weight > uint64(MaxBlockWeight) {
+		return fmt.Errorf("block weight %d exceeds the maximum %d", weight, MaxBlockWeight)
+	}
+
+	return nil
+}
+
 func (b *Block) verifyCoinbase() error {
 	coinbase := 0
 
@@ -218,10 +229,10 @@ func (b *Block) verifyCoinbase() error {
 	return nil
 }
 
-func (b *Block) verifyKernels() error {
+func (b *Block) verifyKernels(prev *BlockHeader) error {
 	coinbase := 0
 
-	//找到coinbase核并验证
+	//coinbase核的数目不能超过上限
 	for _, kernel := range b.Kernels {
 		if kernel.Features&CoinbaseKernel == CoinbaseKernel {
 			coinbase++
@@ -229,15 +240,39 @@ func (b *Block) verifyKernels() error {
 			if coinbase > MaxBlockCoinbaseKernels {
 				return errors.New("invalid block with few coinbase kernels")
 			}
+		}
+	}
 
-			// Validate kernel
-			if err := kernel.Validate(); err != nil {
-				return err
-			}
+	//验证所有交易核的签名，不只是coinbase核
+	if err := verifyKernelSignatures(b.Kernels); err != nil {
+		return err
+	}
+
+	//区块头中的偏移量与交易核之和都是自创世区块起的累计值，本区块的部分是与前一区块头之差
+	prevOffset := make([]byte, secp256k1zkp.SecretKeySize)
+	if prev != nil {
+		prevOffset = prev.TotalKernelOffset
+	}
+
+	//输出 - 输入 - 奖励*H = 交易核盈余之和 + 本区块偏移量*G，否则凭空产生或销毁了币
+	//（交易费已经包含在coinbase输出中）
+	kernelSum, err := verifyKernelSums(b.Inputs, b.Outputs, b.Kernels, -int64(Reward), b.kernelOffset(prevOffset))
+	if err != nil {
+		return err
+	}
+
+	totalKernelSum := kernelSum
+	if prev != nil {
+		prevSum, err := commitmentPoint(prev.TotalKernelSum)
+		if err != nil {
+			return err
 		}
+		totalKernelSum = sumPoints([]*bulletproofs.Point{prevSum, kernelSum}, nil)
 	}
 
-	// TODO: Verify that the kernel sums are correct.
+	if !bytes.Equal(totalKernelSum.Bytes(), b.Header.TotalKernelSum) {
+		return errors.New("invalid block total kernel sum")
+	}
 
 	// Check the roots
 	// TODO: do that
@@ -245,33 +280,56 @@ func (b *Block) verifyKernels() error {
 	return nil
 }
 
-// 检查inputs, outputs, kernels是否已排好序
-func (b *Block) verifySorted() error {
-	if !sort.IsSorted(b.Inputs) {
-		return errors.New("block inputs are not sorted")
+// kernelOffset returns the kernel offset of the block alone: the header's
+// total offset minus the previous one
+func (b *Block) kernelOffset(prevOffset []byte) []byte {
+	offset := bulletproofs.SubScalars(
+		new(big.Int).SetBytes(b.Header.TotalKernelOffset),
+		new(big.Int).SetBytes(prevOffset))
+	blind := bulletproofs.GetB32(offset)
+
+	return blind[:]
+}
+
+// verifyPrevious checks that the block extends prev by one block. Only the
+// genesis block, at height 0, has no previous header.
+func (b *Block) verifyPrevious(prev *BlockHeader) error {
+	if prev == nil {
+		if b.Header.Height != 0 {
+			return fmt.Errorf("block at height %d without previous header", b.Header.Height)
+		}
+		return nil
 	}
 
-	if !sort.IsSorted(b.Outputs) {
-		return errors.New("block outputs are not sorted")
+	if b.Header.Height != prev.Height+1 {
+		return fmt.Errorf("block at height %d doesn't follow previous header at height %d", b.Header.Height, prev.Height)
 	}
 
-	if !sort.IsSorted(b.Kernels) {
-		return errors.New("block kernels are not sorted")
+	if !bytes.Equal(b.Header.Previous, prev.Hash()) {
+		return errors.New("block doesn't reference the hash of the previous header")
 	}
 
 	return nil
 }
 
-// 检查所有输出是否都符合数值范围要求.
-func (b *Block) verifyRangeProofs() error {
-	// TODO(yoss22): Batch verify these.
-	prover := bulletproofs.NewProver(64)
-	for _, output := range b.Outputs {
-		if !prover.Verify(output.Commit, output.RangeProof) {
-			return fmt.Errorf("proof verification failed for %v %v",
-				output.Commit, output.RangeProof)
-		}
+// 检查inputs, outputs, kernels是否已按哈希严格递增排序（排好序且没有重复）
+func (b *Block) verifySorted() error {
+	if !sortedAndUnique(b.Inputs) {
+		return errors.New("block inputs are not sorted or contain duplicates")
 	}
+
+	if !sortedAndUnique(b.Outputs) {
+		return errors.New("block outputs are not sorted or contain duplicates")
+	}
+
+	if !sortedAndUnique(b.Kernels) {
+		return errors.New("block kernels are not sorted or contain duplicates")
+	}
+
 	return nil
 }
 
+// 检查所有输出是否都符合数值范围要求.
+func (b *Block) verifyRangeProofs() error {
+	return verifyRangeProofs(b.Outputs)
+}
@@ -105,12 +105,23 @@ func (b *BlockHeader) bytesWithoutPOW() []byte {
 		logrus.Fatal(err)
 	}
 
-	//写入
+	//写入 TotalKernelOffset, TotalKernelSum
+	if len(b.TotalKernelOffset) != secp256k1zkp.SecretKeySize {
+		logrus.Fatal(errors.New("invalid total kernel offset len"))
+	}
 
 	if _, err := buff.Write(b.TotalKernelOffset); err != nil {
 		logrus.Fatal(err)
 	}
 
+	if len(b.TotalKernelSum) != secp256k1zkp.PedersenCommitmentSize {
+		logrus.Fatal(errors.New("invalid total kernel sum len"))
+	}
+
+	if _, err := buff.Write(b.TotalKernelSum); err != nil {
+		logrus.Fatal(err)
+	}
+
 	if err := binary.Write(buff, binary.BigEndian, b.OutputMmrSize); err != nil {
 		logrus.Fatal(err)
 	}
@@ -192,6 +203,11 @@ func (b *BlockHeader) Read(r io.Reader) error {
 		return err
 	}
 
+	b.TotalKernelSum = make([]byte, secp256k1zkp.PedersenCommitmentSize)
+	if _, err := io.ReadFull(r, b.TotalKernelSum); err != nil {
+		return err
+	}
+
 	if err := binary.Read(r, binary.BigEndian, &b.OutputMmrSize); err != nil {
 		return err
 	}
@@ -230,7 +246,7 @@ func (b *BlockHeader) Validate() error {
 
 	// Either the size shift must be a valid primary POW (greater than the
 	// minimum size shift) or equal to the secondary POW size shift.
-	if b.POW.EdgeBits < DefaultMinEdgeBits && isPrimaryPow {
+	if b.POW.EdgeBits < MinEdgeBits() && isPrimaryPow {
 		return fmt.Errorf("cuckoo size too small: %d", b.POW.EdgeBits)
 	}
 
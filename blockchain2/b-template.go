@@ -0,0 +1,45 @@
+package blockchain2
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// SelectTransactions returns the pool transactions to include in a block
+// template: by decreasing fee per weight unit, as long as they fit in
+// MaxBlockWeight along with the coinbase output and kernel. Transactions
+// whose fee overflows are left out.
+func SelectTransactions(pool []*Transaction) []*Transaction {
+	type candidate struct {
+		tx          *Transaction
+		fee, weight uint64
+	}
+
+	candidates := make([]candidate, 0, len(pool))
+	for _, tx := range pool {
+		fee, err := tx.Fee()
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, candidate{tx, fee, tx.Weight()})
+	}
+
+	// fee_i/weight_i > fee_j/weight_j, compared as 128 bit products
+	sort.SliceStable(candidates, func(i, j int) bool {
+		hi, lo := bits.Mul64(candidates[i].fee, candidates[j].weight)
+		hj, lj := bits.Mul64(candidates[j].fee, candidates[i].weight)
+		return hi > hj || (hi == hj && lo > lj)
+	})
+
+	weight := BlockWeight(0, 1, 1)
+	var selected []*Transaction
+	for _, c := range candidates {
+		if weight+c.weight > uint64(MaxBlockWeight) {
+			continue
+		}
+		weight += c.weight
+		selected = append(selected, c.tx)
+	}
+
+	return selected
+}
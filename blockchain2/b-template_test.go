@@ -0,0 +1,59 @@
+package blockchain2
+
+import "testing"
+
+// weightedTransaction returns a transaction with the given numbers of
+// outputs and kernels, each kernel paying fee
+func weightedTransaction(outputs, kernels int, fee uint64) *Transaction {
+	tx := &Transaction{Outputs: make(OutputList, outputs), Kernels: make(TxKernelList, kernels)}
+	for i := range tx.Kernels {
+		tx.Kernels[i].Fee = fee
+	}
+	return tx
+}
+
+func TestSelectTransactions(t *testing.T) {
+	// the whole block but the coinbase, at the lowest fee rate
+	full := weightedTransaction(int(MaxBlockWeight/BlockOutputWeight)-2, 1, 1)
+	full.Inputs = make(InputList, uint64(MaxBlockWeight)-full.WeightAsBlock())
+	if err := full.verifyWeight(); err != nil {
+		t.Fatal(err)
+	}
+
+	cheap := weightedTransaction(2, 1, 10)
+	dear := weightedTransaction(2, 1, 100)
+	overflow := weightedTransaction(1, 2, 1<<63)
+
+	selected := SelectTransactions([]*Transaction{full, cheap, overflow, dear})
+	if len(selected) != 2 || selected[0] != dear || selected[1] != cheap {
+		t.Fatalf("selected %d transactions, want the two with the best fee rates", len(selected))
+	}
+
+	selected = SelectTransactions([]*Transaction{full, overflow})
+	if len(selected) != 1 || selected[0] != full {
+		t.Fatalf("selected %d transactions, want the full one", len(selected))
+	}
+}
+
+func TestBlockWeight(t *testing.T) {
+	block := Block{Outputs: make(OutputList, MaxBlockWeight/BlockOutputWeight)}
+	if err := block.verifyWeight(); err != nil {
+		t.Fatal(err)
+	}
+	block.Kernels = make(TxKernelList, 1)
+	if err := block.verifyWeight(); err == nil {
+		t.Fatal("overweight block valid")
+	}
+	if err := block.Validate(nil); err == nil {
+		t.Fatal("overweight block valid")
+	}
+
+	if !fitsBlockWeight(0, uint64(MaxBlockWeight/BlockOutputWeight), 0) {
+		t.Fatal("full block doesn't fit")
+	}
+	for _, counts := range [][3]uint64{{0, uint64(MaxBlockWeight/BlockOutputWeight) + 1, 0}, {1 << 63, 0, 0}, {0, 1 << 62, 0}} {
+		if fitsBlockWeight(counts[0], counts[1], counts[2]) {
+			t.Fatalf("%v fit in a block", counts)
+		}
+	}
+}
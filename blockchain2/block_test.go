@@ -0,0 +1,129 @@
+package blockchain2
+
+import (
+	"bytes"
+	"github.com/azd1997/golang-MimbleWimble-try/secp256k1zkp"
+	"github.com/yoss22/bulletproofs"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testBlock returns a block with the test transaction and a coinbase
+// collecting the reward and its fee, following prev
+func testBlock(t *testing.T, prev *BlockHeader) Block {
+	prover := bulletproofs.NewProver(64)
+	tx := testTransaction(t)
+
+	coinbase, blind := testOutput(t, prover, Reward+2)
+	coinbase.Features = CoinbaseOutput
+	coinbaseKernel := testKernel(blind, 0)
+	coinbaseKernel.Features = CoinbaseKernel
+
+	block := Block{
+		Header:  testHeader(prev.Height+1, prev.Hash()),
+		Inputs:  tx.Inputs,
+		Outputs: append(OutputList{coinbase}, tx.Outputs...),
+		Kernels: append(TxKernelList{coinbaseKernel}, tx.Kernels...),
+	}
+	sort.Sort(block.Inputs)
+	sort.Sort(block.Outputs)
+	sort.Sort(block.Kernels)
+
+	// the header totals add the block offset and kernels to the previous ones
+	offset := bulletproofs.GetB32(bulletproofs.Sum(
+		new(big.Int).SetBytes(prev.TotalKernelOffset),
+		new(big.Int).SetBytes(tx.KernelOffset[:])))
+	block.Header.TotalKernelOffset = offset[:]
+
+	prevSum, err := commitmentPoint(prev.TotalKernelSum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Header.TotalKernelSum = sumPoints([]*bulletproofs.Point{prevSum, &coinbaseKernel.Excess, &tx.Kernels[0].Excess}, nil).Bytes()
+
+	return block
+}
+
+func TestBlockValidate(t *testing.T) {
+	SetChainType(AutomatedTesting)
+	defer SetChainType(Mainnet)
+
+	prev := testHeader(0, bytes.Repeat([]byte{0}, BlockHashSize))
+	block := testBlock(t, &prev)
+	block.Header.Timestamp = time.Now().UTC()
+	if err := block.Header.Mine(12, 4, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var read Block
+	if err := read.Read(bytes.NewReader(block.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if err := read.Validate(&prev); err != nil {
+		t.Fatal(err)
+	}
+
+	// the block must extend prev by one
+	other := testHeader(0, bytes.Repeat([]byte{0}, BlockHashSize))
+	other.POW.Nonces[0] = 1
+	skipped := testHeader(1, prev.Hash())
+	for name, p := range map[string]*BlockHeader{"missing": nil, "other": &other, "lower": &skipped} {
+		if err := read.Validate(p); err == nil {
+			t.Errorf("%s previous header: block valid", name)
+		}
+	}
+}
+
+func TestBlockKernelSums(t *testing.T) {
+	prev := testHeader(0, bytes.Repeat([]byte{0}, BlockHashSize))
+	block := testBlock(t, &prev)
+	if err := block.verifyKernels(&prev); err != nil {
+		t.Fatal(err)
+	}
+	// the totals include the previous ones, so it isn't a valid genesis block
+	if err := block.verifyKernels(nil); err == nil {
+		t.Fatal("block kernels valid without previous header")
+	}
+
+	tests := []struct {
+		name   string
+		modify func(b *Block, prev *BlockHeader)
+	}{
+		{"unsigned kernel", func(b *Block, prev *BlockHeader) {
+			for i := range b.Kernels {
+				if b.Kernels[i].Features == DefaultKernel {
+					b.Kernels[i].Fee++
+				}
+			}
+		}},
+		{"value from nothing", func(b *Block, prev *BlockHeader) {
+			// an output of 1 signed for as if it were a commitment to zero
+			output, blind := testOutput(t, bulletproofs.NewProver(64), 1)
+			kernel := testKernel(blind, 0)
+			b.Outputs = append(b.Outputs, output)
+			b.Kernels = append(b.Kernels, kernel)
+			sum, _ := commitmentPoint(b.Header.TotalKernelSum)
+			b.Header.TotalKernelSum = sumPoints([]*bulletproofs.Point{sum, &kernel.Excess}, nil).Bytes()
+		}},
+		{"offset", func(b *Block, prev *BlockHeader) { b.Header.TotalKernelOffset[31] ^= 1 }},
+		{"previous offset", func(b *Block, prev *BlockHeader) { prev.TotalKernelOffset[31] ^= 1 }},
+		{"total kernel sum", func(b *Block, prev *BlockHeader) { b.Header.TotalKernelSum = secp256k1zkp.H.Bytes() }},
+		{"previous kernel sum", func(b *Block, prev *BlockHeader) {
+			prev.TotalKernelSum = b.Header.TotalKernelSum
+		}},
+	}
+
+	for _, test := range tests {
+		var b Block
+		if err := b.Read(bytes.NewReader(block.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		p := testHeader(0, bytes.Repeat([]byte{0}, BlockHashSize))
+		test.modify(&b, &p)
+		if err := b.verifyKernels(&p); err == nil {
+			t.Errorf("%s: block kernels valid", test.name)
+		}
+	}
+}
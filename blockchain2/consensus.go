@@ -4,7 +4,10 @@
 
 package blockchain2
 
-import "time"
+import (
+	"sort"
+	"time"
+)
 
 // Consensus rule that everything is sorted in lexicographical order on the wire.
 
@@ -56,6 +59,10 @@ const (
 	// validating.
 	DefaultMinEdgeBits uint8 = 30
 
+	// AutomatedTestingMinEdgeBits is the Cuckatoo Cycle size used for mining and
+	// validating under the automated testing chain type.
+	AutomatedTestingMinEdgeBits uint8 = 10
+
 	/// Secondary proof-of-work size, meant to be ASIC resistant.
 	SecondPowEdgeBits uint8 = 29
 
@@ -111,3 +118,59 @@ const (
 	// Minimum size time window used for difficulty adjustments
 	LowerTimeBound time.Duration = BlockTimeWindow * 2
 )
+
+// BlockWeight returns the weight counted against MaxBlockWeight of a block,
+// or transaction, with the given numbers of inputs, outputs and kernels.
+func BlockWeight(inputs, outputs, kernels uint64) uint64 {
+	return inputs*uint64(BlockInputWeight) + outputs*uint64(BlockOutputWeight) + kernels*uint64(BlockKernelWeight)
+}
+
+// fitsBlockWeight returns whether the numbers of inputs, outputs and kernels
+// read from the wire fit in a block, without overflowing the weight.
+func fitsBlockWeight(inputs, outputs, kernels uint64) bool {
+	max := uint64(MaxBlockWeight)
+	if inputs > max || outputs > max || kernels > max {
+		return false
+	}
+
+	return BlockWeight(inputs, outputs, kernels) <= max
+}
+
+// sortedAndUnique returns whether the elements of list are in strictly
+// increasing order, i.e. sorted by hash without duplicates.
+func sortedAndUnique(list sort.Interface) bool {
+	for i := 1; i < list.Len(); i++ {
+		if !list.Less(i-1, i) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ChainType selects the consensus parameters that are relaxed for testing.
+type ChainType uint8
+
+const (
+	// Mainnet uses the consensus parameters above.
+	Mainnet ChainType = iota
+	// AutomatedTesting allows small cuckoo graphs, so tests and regtest
+	// nodes can mine blocks quickly.
+	AutomatedTesting
+)
+
+var chainType = Mainnet
+
+// SetChainType sets the chain type, once at startup before any validation.
+func SetChainType(t ChainType) {
+	chainType = t
+}
+
+// MinEdgeBits is the minimum primary Cuckatoo Cycle size of the chain type.
+func MinEdgeBits() uint8 {
+	if chainType == AutomatedTesting {
+		return AutomatedTestingMinEdgeBits
+	}
+
+	return DefaultMinEdgeBits
+}
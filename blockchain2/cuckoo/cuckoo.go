@@ -0,0 +1,155 @@
+// Package cuckoo verifies cuckoo cycle proofs of work: a cycle of a given
+// length in a graph whose edges are generated by siphash keyed with the
+// block header.
+//
+// Two variants are supported. Cuckaroo (the ASIC resistant secondary proof
+// of work) is a bipartite graph whose edge endpoints are generated in blocks
+// of 64 siphashes. Cuckatoo (the primary proof of work) generates each
+// endpoint with its own siphash, and links the nodes u and u^1.
+package cuckoo
+
+import (
+	"errors"
+)
+
+// Variant of the cuckoo cycle graph
+type Variant uint8
+
+const (
+	Cuckaroo Variant = iota
+	Cuckatoo
+)
+
+// Reasons for a proof to be rejected
+var (
+	ErrEdgeBits       = errors.New("invalid edge bits")
+	ErrProofSize      = errors.New("invalid proof size")
+	ErrEdgeTooBig     = errors.New("edge too big")
+	ErrNotAscending   = errors.New("edges not ascending")
+	ErrNonMatching    = errors.New("endpoints don't match up")
+	ErrBranchInCycle  = errors.New("branch in cycle")
+	ErrDeadEnd        = errors.New("cycle dead ends")
+	ErrShortCycle     = errors.New("cycle too short")
+	errUnknownVariant = errors.New("unknown cuckoo variant")
+)
+
+// Cuckoo is the graph of a block header
+type Cuckoo struct {
+	Variant Variant
+	Keys    [4]uint64
+}
+
+// NewCuckaroo returns the cuckaroo graph of the header bytes
+func NewCuckaroo(header []byte) *Cuckoo {
+	return &Cuckoo{Cuckaroo, SipHashKeys(header)}
+}
+
+// NewCuckatoo returns the cuckatoo graph of the header bytes
+func NewCuckatoo(header []byte) *Cuckoo {
+	return &Cuckoo{Cuckatoo, SipHashKeys(header)}
+}
+
+// Verify returns whether nonces is a cycle in the graph with 2^edgeBits edges
+func (c *Cuckoo) Verify(nonces []uint32, edgeBits uint8) bool {
+	return c.Validate(nonces, edgeBits) == nil
+}
+
+// Validate returns nil if nonces, in ascending order, are the edges of a
+// cycle of length len(nonces) in the graph with 2^edgeBits edges, or the
+// reason they are not
+func (c *Cuckoo) Validate(nonces []uint32, edgeBits uint8) error {
+	if edgeBits == 0 || edgeBits > 32 {
+		return ErrEdgeBits
+	}
+	if len(nonces) == 0 || len(nonces)%2 != 0 {
+		return ErrProofSize
+	}
+
+	edgeMask := uint64(1)<<edgeBits - 1
+	for n, nonce := range nonces {
+		if uint64(nonce) > edgeMask {
+			return ErrEdgeTooBig
+		}
+		if n > 0 && nonce <= nonces[n-1] {
+			return ErrNotAscending
+		}
+	}
+
+	// endpoints of edge n are uvs[2n] and uvs[2n+1]
+	uvs := make([]uint64, 2*len(nonces))
+	var xor0, xor1 uint64
+	switch c.Variant {
+	case Cuckaroo:
+		var buf [edgeBlockSize]uint64
+		for n, nonce := range nonces {
+			edge := sipBlock(&c.Keys, uint64(nonce), &buf)
+			uvs[2*n] = edge & edgeMask
+			uvs[2*n+1] = (edge >> 32) & edgeMask
+			xor0 ^= uvs[2*n]
+			xor1 ^= uvs[2*n+1]
+		}
+	case Cuckatoo:
+		// every node is linked to its partner node^1, which adds one to the
+		// xor of each side for each pair of edges
+		xor0 = uint64(len(nonces)/2) & 1
+		xor1 = xor0
+		for n, nonce := range nonces {
+			uvs[2*n] = c.sipNode(uint64(nonce), 0, edgeMask)
+			uvs[2*n+1] = c.sipNode(uint64(nonce), 1, edgeMask)
+			xor0 ^= uvs[2*n]
+			xor1 ^= uvs[2*n+1]
+		}
+	default:
+		return errUnknownVariant
+	}
+
+	// each endpoint appears an even number of times in a cycle
+	if xor0|xor1 != 0 {
+		return ErrNonMatching
+	}
+
+	// follow the cycle from the first edge, there must be exactly one other
+	// edge sharing each endpoint
+	n, i := 0, 0
+	for {
+		j := i
+		for k := (i + 2) % len(uvs); k != i; k = (k + 2) % len(uvs) {
+			if c.linked(uvs[k], uvs[i]) {
+				if j != i {
+					return ErrBranchInCycle
+				}
+				j = k
+			}
+		}
+		if j == i || (c.Variant == Cuckatoo && uvs[j] == uvs[i]) {
+			return ErrDeadEnd
+		}
+
+		// continue from the other endpoint of the edge we arrived at
+		i = j ^ 1
+		n++
+		if i == 0 {
+			break
+		}
+	}
+
+	if n != len(nonces) {
+		return ErrShortCycle
+	}
+
+	return nil
+}
+
+// sipNode returns the endpoint of edge on side uorv of the cuckatoo graph
+func (c *Cuckoo) sipNode(edge, uorv, edgeMask uint64) uint64 {
+	return siphash24(&c.Keys, 2*edge+uorv) & edgeMask
+}
+
+// linked returns whether the cycle may go from endpoint a to endpoint b
+func (c *Cuckoo) linked(a, b uint64) bool {
+	if c.Variant == Cuckatoo {
+		return a>>1 == b>>1
+	}
+
+	return a == b
+}
@@ -0,0 +1,185 @@
+package cuckoo
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/dchest/siphash"
+)
+
+// Test vectors: 42-cycles in graphs with 2^12 edges, found by exhaustive
+// search, keyed with an 80 byte header starting with "cuckoo test vector"
+// and ending with a big endian nonce.
+var vectors = []struct {
+	variant Variant
+	nonce   uint32
+	keys    [4]uint64
+	sol     []uint32
+}{
+	{
+		Cuckaroo, 66,
+		[4]uint64{0x277820fc1c5bd238, 0x8785831ad2a24612, 0x29548eef1072240f, 0xfda257280e725e68},
+		[]uint32{
+			0x75, 0xf6, 0x12a, 0x17b, 0x1b7, 0x22d, 0x23f, 0x277, 0x2d6, 0x430, 0x464, 0x528, 0x53f, 0x57a,
+			0x61c, 0x62b, 0x65b, 0x677, 0x703, 0x768, 0x7d7, 0x827, 0x831, 0x835, 0x8a8, 0x8e4, 0x91a, 0x947,
+			0x9b0, 0xa2f, 0xaa2, 0xb18, 0xbed, 0xc84, 0xd1f, 0xdf7, 0xe42, 0xe6a, 0xe7b, 0xecb, 0xf67, 0xf94,
+		},
+	},
+	{
+		Cuckatoo, 39,
+		[4]uint64{0x642b005f14bb245e, 0x65a7cc13087e6a9b, 0x20bb62ce11bbc0aa, 0x5fc562a639105938},
+		[]uint32{
+			0xae, 0xfa, 0x1ad, 0x221, 0x273, 0x2dc, 0x2de, 0x308, 0x30e, 0x368, 0x3a4, 0x43a, 0x523, 0x52f,
+			0x538, 0x57a, 0x58f, 0x5d8, 0x772, 0x7a9, 0x7c5, 0x875, 0x8cf, 0x99d, 0x99f, 0x9c8, 0xa75, 0xa89,
+			0xa99, 0xb48, 0xd7b, 0xda9, 0xdbd, 0xe33, 0xe79, 0xe8a, 0xea6, 0xed9, 0xf5d, 0xf83, 0xfca, 0xfe0,
+		},
+	},
+}
+
+const vectorEdgeBits = 12
+
+func vectorHeader(nonce uint32) []byte {
+	header := make([]byte, 80)
+	copy(header, "cuckoo test vector")
+	binary.BigEndian.PutUint32(header[76:], nonce)
+
+	return header
+}
+
+// The siphash rounds give the standard siphash-2-4 when used with the
+// standard initialization and finalization
+func TestSipRound(t *testing.T) {
+	k0, k1 := uint64(0x0706050403020100), uint64(0x0f0e0d0c0b0a0908)
+	for _, m := range []uint64{0, 1, 0x1122334455667788, ^uint64(0)} {
+		s := sipState{k0 ^ 0x736f6d6570736575, k1 ^ 0x646f72616e646f6d, k0 ^ 0x6c7967656e657261, k1 ^ 0x7465646279746573}
+		s.v3 ^= m
+		s.round()
+		s.round()
+		s.v0 ^= m
+		s.hash24(8 << 56)
+
+		var msg [8]byte
+		binary.LittleEndian.PutUint64(msg[:], m)
+		if got, want := s.digest(), siphash.Hash(k0, k1, msg[:]); got != want {
+			t.Fatalf("siphash of %x: got %x, want %x", m, got, want)
+		}
+	}
+}
+
+func TestVectors(t *testing.T) {
+	for _, v := range vectors {
+		c := NewCuckaroo(vectorHeader(v.nonce))
+		if v.variant == Cuckatoo {
+			c = NewCuckatoo(vectorHeader(v.nonce))
+		}
+
+		if c.Keys != v.keys {
+			t.Fatalf("variant %d: keys %#x, want %#x", v.variant, c.Keys, v.keys)
+		}
+		if err := c.Validate(v.sol, vectorEdgeBits); err != nil {
+			t.Fatalf("variant %d: %v", v.variant, err)
+		}
+
+		// the cycle is only valid in the graph it was found in
+		other := &Cuckoo{1 - v.variant, v.keys}
+		if other.Verify(v.sol, vectorEdgeBits) {
+			t.Fatalf("variant %d: cycle valid in the other variant", v.variant)
+		}
+		if NewCuckaroo(vectorHeader(v.nonce+1)).Verify(v.sol, vectorEdgeBits) {
+			t.Fatalf("variant %d: cycle valid with another header", v.variant)
+		}
+	}
+}
+
+func TestInvalidProofs(t *testing.T) {
+	for _, v := range vectors {
+		c := &Cuckoo{v.variant, v.keys}
+		modified := func(f func(sol []uint32) []uint32) []uint32 {
+			sol := append([]uint32(nil), v.sol...)
+			return f(sol)
+		}
+
+		tests := []struct {
+			name     string
+			sol      []uint32
+			edgeBits uint8
+			err      error
+		}{
+			{"zero edge bits", v.sol, 0, ErrEdgeBits},
+			{"too many edge bits", v.sol, 33, ErrEdgeBits},
+			{"odd size", v.sol[1:], vectorEdgeBits, ErrProofSize},
+			{"empty", nil, vectorEdgeBits, ErrProofSize},
+			{"edge too big", v.sol, vectorEdgeBits - 1, ErrEdgeTooBig},
+			{"not ascending", modified(func(sol []uint32) []uint32 {
+				sol[3], sol[4] = sol[4], sol[3]
+				return sol
+			}), vectorEdgeBits, ErrNotAscending},
+			{"duplicate edge", modified(func(sol []uint32) []uint32 {
+				sol[4] = sol[3]
+				return sol
+			}), vectorEdgeBits, ErrNotAscending},
+			{"wrong edge", modified(func(sol []uint32) []uint32 {
+				sol[0]++
+				return sol
+			}), vectorEdgeBits, ErrNonMatching},
+		}
+
+		for _, test := range tests {
+			if err := c.Validate(test.sol, test.edgeBits); err != test.err {
+				t.Errorf("variant %d, %s: got %v, want %v", v.variant, test.name, err, test.err)
+			}
+		}
+	}
+}
+
+func TestSolve(t *testing.T) {
+	for _, v := range vectors {
+		c := &Cuckoo{v.variant, v.keys}
+		sol, err := c.Solve(vectorEdgeBits, len(v.sol), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(sol, v.sol) {
+			t.Fatalf("variant %d: solved %#x, want %#x", v.variant, sol, v.sol)
+		}
+	}
+}
+
+// Every cycle found is valid, and short cycles are common enough in small
+// graphs to find some
+func TestSolveShortCycles(t *testing.T) {
+	for _, variant := range []Variant{Cuckaroo, Cuckatoo} {
+		found := 0
+		for nonce := uint32(0); nonce < 20; nonce++ {
+			c := &Cuckoo{variant, SipHashKeys(vectorHeader(nonce))}
+			sol, err := c.Solve(10, 4, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sol == nil {
+				continue
+			}
+			if err := c.Validate(sol, 10); err != nil {
+				t.Fatalf("variant %d, nonce %d: solved %#x: %v", variant, nonce, sol, err)
+			}
+			found++
+		}
+		if found == 0 {
+			t.Fatalf("variant %d: no 4-cycles found", variant)
+		}
+	}
+}
+
+func TestSolveCancelled(t *testing.T) {
+	quit := make(chan struct{})
+	close(quit)
+
+	c := NewCuckatoo(vectorHeader(0))
+	if _, err := c.Solve(MaxSolveEdgeBits, 42, quit); err != ErrCancelled {
+		t.Fatalf("got %v, want %v", err, ErrCancelled)
+	}
+	if _, err := c.Solve(MaxSolveEdgeBits+1, 42, nil); err != ErrEdgeBits {
+		t.Fatalf("got %v, want %v", err, ErrEdgeBits)
+	}
+}
@@ -0,0 +1,102 @@
+package cuckoo
+
+import (
+	"encoding/binary"
+	"golang.org/x/crypto/blake2b"
+	"math/bits"
+)
+
+const (
+	// edgeBlockBits is the log2 of the number of edges generated together
+	// by cuckaroo
+	edgeBlockBits = 6
+	edgeBlockSize = 1 << edgeBlockBits
+	edgeBlockMask = edgeBlockSize - 1
+)
+
+// SipHashKeys derives the four siphash keys of the graph from the header
+// bytes the proof of work is computed on: the blake2b-256 hash of the header
+// read as four little endian integers.
+func SipHashKeys(header []byte) [4]uint64 {
+	hash := blake2b.Sum256(header)
+
+	var keys [4]uint64
+	for i := range keys {
+		keys[i] = binary.LittleEndian.Uint64(hash[i*8:])
+	}
+
+	return keys
+}
+
+// sipState is the siphash-2-4 state, initialized with the keys directly as in
+// the cuckoo cycle reference miners
+type sipState struct {
+	v0, v1, v2, v3 uint64
+}
+
+func newSipState(keys *[4]uint64) sipState {
+	return sipState{keys[0], keys[1], keys[2], keys[3]}
+}
+
+func (s *sipState) round() {
+	s.v0 += s.v1
+	s.v2 += s.v3
+	s.v1 = bits.RotateLeft64(s.v1, 13)
+	s.v3 = bits.RotateLeft64(s.v3, 16)
+	s.v1 ^= s.v0
+	s.v3 ^= s.v2
+	s.v0 = bits.RotateLeft64(s.v0, 32)
+	s.v2 += s.v1
+	s.v0 += s.v3
+	s.v1 = bits.RotateLeft64(s.v1, 17)
+	s.v3 = bits.RotateLeft64(s.v3, 21)
+	s.v1 ^= s.v2
+	s.v3 ^= s.v0
+	s.v2 = bits.RotateLeft64(s.v2, 32)
+}
+
+// hash24 mixes nonce into the state with 2 compression and 4 finalization
+// rounds
+func (s *sipState) hash24(nonce uint64) {
+	s.v3 ^= nonce
+	s.round()
+	s.round()
+	s.v0 ^= nonce
+	s.v2 ^= 0xff
+	s.round()
+	s.round()
+	s.round()
+	s.round()
+}
+
+func (s *sipState) digest() uint64 {
+	return (s.v0 ^ s.v1) ^ (s.v2 ^ s.v3)
+}
+
+// siphash24 hashes a single nonce
+func siphash24(keys *[4]uint64, nonce uint64) uint64 {
+	s := newSipState(keys)
+	s.hash24(nonce)
+
+	return s.digest()
+}
+
+// sipBlock hashes the block of edgeBlockSize edges containing edge, keeping
+// the state between edges, and returns the hash of edge xored with the hash
+// of the last edge of the block (cuckaroo edge generation). The hashes of
+// the whole block are written to buf.
+func sipBlock(keys *[4]uint64, edge uint64, buf *[edgeBlockSize]uint64) uint64 {
+	s := newSipState(keys)
+	edge0 := edge &^ edgeBlockMask
+	for i := range buf {
+		s.hash24(edge0 + uint64(i))
+		buf[i] = s.digest()
+	}
+
+	last := buf[edgeBlockMask]
+	for i := 0; i < edgeBlockMask; i++ {
+		buf[i] ^= last
+	}
+
+	return buf[edge&edgeBlockMask]
+}
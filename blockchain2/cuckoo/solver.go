@@ -0,0 +1,260 @@
+package cuckoo
+
+import (
+	"errors"
+	"sort"
+)
+
+// MaxSolveEdgeBits is the largest graph Solve searches. The solver keeps the
+// whole graph in memory, it is meant for test sized graphs.
+const MaxSolveEdgeBits = 24
+
+// ErrCancelled is returned by Solve when the search is cancelled
+var ErrCancelled = errors.New("cuckoo search cancelled")
+
+// how often the search checks for cancellation
+const checkQuitMask = 1<<16 - 1
+
+// solver finds cycles in a graph. Endpoints are identified by keys: the
+// endpoint x of side s is key s*2^edgeBits + x. A cycle arriving at key k
+// leaves by another edge at key partner(k): the same key in cuckaroo, the
+// key's partner node in cuckatoo.
+type solver struct {
+	c         *Cuckoo
+	edgeBits  uint8
+	proofSize int
+	quit      <-chan struct{}
+	steps     int
+
+	// keys of the endpoints of edge e at 2e and 2e+1
+	keys []uint32
+	live []bool
+	// number of live edges at each key
+	count []int32
+	// live edges at each key, once trimmed
+	adj map[uint32][]uint32
+
+	// search state
+	start   uint32
+	path    []uint32
+	visited map[uint32]bool
+}
+
+// Solve searches the graph with 2^edgeBits edges for a cycle of length
+// proofSize and returns its edges in ascending order, or nil if there is
+// none. It returns ErrCancelled if quit is closed before the search ends.
+func (c *Cuckoo) Solve(edgeBits uint8, proofSize int, quit <-chan struct{}) ([]uint32, error) {
+	if edgeBits == 0 || edgeBits > MaxSolveEdgeBits {
+		return nil, ErrEdgeBits
+	}
+	if proofSize <= 0 || proofSize%2 != 0 {
+		return nil, ErrProofSize
+	}
+	if c.Variant != Cuckaroo && c.Variant != Cuckatoo {
+		return nil, errUnknownVariant
+	}
+
+	s := &solver{
+		c:         c,
+		edgeBits:  edgeBits,
+		proofSize: proofSize,
+		quit:      quit,
+	}
+
+	if err := s.generate(); err != nil {
+		return nil, err
+	}
+	if err := s.trim(); err != nil {
+		return nil, err
+	}
+
+	return s.search()
+}
+
+func (s *solver) cancelled() bool {
+	s.steps++
+	if s.steps&checkQuitMask != 0 {
+		return false
+	}
+
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// generate computes the endpoints of every edge
+func (s *solver) generate() error {
+	edges := uint64(1) << s.edgeBits
+	edgeMask := edges - 1
+
+	s.keys = make([]uint32, 2*edges)
+	s.live = make([]bool, edges)
+	s.count = make([]int32, 2*edges)
+
+	var buf [edgeBlockSize]uint64
+	for e := uint64(0); e < edges; e++ {
+		if s.cancelled() {
+			return ErrCancelled
+		}
+
+		var u, v uint64
+		switch s.c.Variant {
+		case Cuckaroo:
+			if e&edgeBlockMask == 0 {
+				sipBlock(&s.c.Keys, e, &buf)
+			}
+			u = buf[e&edgeBlockMask] & edgeMask
+			v = (buf[e&edgeBlockMask] >> 32) & edgeMask
+		case Cuckatoo:
+			u = s.c.sipNode(e, 0, edgeMask)
+			v = s.c.sipNode(e, 1, edgeMask)
+		}
+
+		s.keys[2*e] = uint32(u)
+		s.keys[2*e+1] = uint32(edges + v)
+		s.live[e] = true
+		s.count[s.keys[2*e]]++
+		s.count[s.keys[2*e+1]]++
+	}
+
+	return nil
+}
+
+func (s *solver) partner(key uint32) uint32 {
+	if s.c.Variant == Cuckatoo {
+		return key ^ 1
+	}
+
+	return key
+}
+
+// vertex returns the node of the cycle a key belongs to
+func (s *solver) vertex(key uint32) uint32 {
+	if s.c.Variant == Cuckatoo {
+		return key >> 1
+	}
+
+	return key
+}
+
+// continues returns whether a cycle arriving at key can leave it
+func (s *solver) continues(key uint32) bool {
+	other := s.count[s.partner(key)]
+	if s.partner(key) == key {
+		other--
+	}
+
+	return other > 0
+}
+
+// trim removes the edges that can't be part of a cycle, until every live edge
+// can be continued at both its endpoints
+func (s *solver) trim() error {
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for e := range s.live {
+			if s.cancelled() {
+				return ErrCancelled
+			}
+			if !s.live[e] {
+				continue
+			}
+
+			u, v := s.keys[2*e], s.keys[2*e+1]
+			if !s.continues(u) || !s.continues(v) {
+				s.live[e] = false
+				s.count[u]--
+				s.count[v]--
+				trimmed = true
+			}
+		}
+	}
+
+	s.adj = make(map[uint32][]uint32)
+	for e, live := range s.live {
+		if live {
+			s.adj[s.keys[2*e]] = append(s.adj[s.keys[2*e]], uint32(e))
+			s.adj[s.keys[2*e+1]] = append(s.adj[s.keys[2*e+1]], uint32(e))
+		}
+	}
+
+	return nil
+}
+
+// search looks for a cycle from each live edge in turn, only through larger
+// edges so each cycle is found once, from its smallest edge
+func (s *solver) search() ([]uint32, error) {
+	s.visited = make(map[uint32]bool)
+
+	for e, live := range s.live {
+		if !live {
+			continue
+		}
+
+		s.start = uint32(e)
+		s.path = append(s.path[:0], s.start)
+		u, v := s.keys[2*e], s.keys[2*e+1]
+		s.visited[s.vertex(u)] = true
+		s.visited[s.vertex(v)] = true
+
+		found, err := s.walk(s.start, v)
+		if err != nil || found {
+			if found {
+				cycle := append([]uint32(nil), s.path...)
+				sort.Slice(cycle, func(i, j int) bool { return cycle[i] < cycle[j] })
+				return cycle, nil
+			}
+			return nil, err
+		}
+
+		delete(s.visited, s.vertex(u))
+		delete(s.visited, s.vertex(v))
+	}
+
+	return nil, nil
+}
+
+// walk extends the path, which arrived at key by edge, until it closes a
+// cycle of proofSize edges at the first endpoint of the start edge
+func (s *solver) walk(edge, key uint32) (bool, error) {
+	if s.cancelled() {
+		return false, ErrCancelled
+	}
+
+	first := s.keys[2*s.start]
+	for _, next := range s.adj[s.partner(key)] {
+		if next <= s.start || next == edge {
+			continue
+		}
+
+		// the other endpoint of next
+		other := s.keys[2*next]
+		if other == s.partner(key) {
+			other = s.keys[2*next+1]
+		}
+
+		if s.vertex(other) == s.vertex(first) {
+			if other == s.partner(first) && len(s.path)+1 == s.proofSize {
+				s.path = append(s.path, next)
+				return true, nil
+			}
+			continue
+		}
+		if s.visited[s.vertex(other)] || len(s.path)+1 >= s.proofSize {
+			continue
+		}
+
+		s.path = append(s.path, next)
+		s.visited[s.vertex(other)] = true
+		if found, err := s.walk(next, other); found || err != nil {
+			return found, err
+		}
+		delete(s.visited, s.vertex(other))
+		s.path = s.path[:len(s.path)-1]
+	}
+
+	return false, nil
+}
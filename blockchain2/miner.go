@@ -0,0 +1,72 @@
+package blockchain2
+
+import (
+	"errors"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2/cuckoo"
+)
+
+var errMiningCancelled = errors.New("mining cancelled")
+
+// Mine searches for a proof of work of size edgeBits for the header, trying
+// successive nonces from the header's on threads goroutines, and sets the
+// header's Nonce and POW to the first one found. The solver only handles
+// test sized graphs (see cuckoo.MaxSolveEdgeBits), meant to be validated
+// under the AutomatedTesting chain type. Mining stops with an error when quit
+// is closed.
+func (b *BlockHeader) Mine(edgeBits uint8, threads int, quit <-chan struct{}) error {
+	if edgeBits > cuckoo.MaxSolveEdgeBits {
+		return fmt.Errorf("can't mine cuckoo size %d", edgeBits)
+	}
+	if threads < 1 {
+		threads = 1
+	}
+
+	stop := make(chan struct{})
+	found := make(chan BlockHeader, threads)
+	failed := make(chan error, threads)
+
+	for i := 0; i < threads; i++ {
+		header := *b
+		header.Nonce += uint64(i)
+
+		go func() {
+			for ; ; header.Nonce += uint64(threads) {
+				nonces, err := powGraph(&header, edgeBits).Solve(edgeBits, ProofSize, stop)
+				if err != nil {
+					failed <- err
+					return
+				}
+				if nonces != nil {
+					header.POW = Proof{EdgeBits: edgeBits, Nonces: nonces}
+					found <- header
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+	running := threads
+	select {
+	case header := <-found:
+		b.Nonce = header.Nonce
+		b.POW = header.POW
+		running--
+	case err = <-failed:
+		running--
+	case <-quit:
+		err = errMiningCancelled
+	}
+
+	// wait for the other threads to stop
+	close(stop)
+	for ; running > 0; running-- {
+		select {
+		case <-found:
+		case <-failed:
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,48 @@
+package blockchain2
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMine(t *testing.T) {
+	SetChainType(AutomatedTesting)
+	defer SetChainType(Mainnet)
+
+	header := testHeader(1, bytes.Repeat([]byte{0}, BlockHashSize))
+	header.Timestamp = time.Now().UTC()
+	if err := header.Mine(12, 4, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(header.POW.Nonces) != ProofSize || header.POW.EdgeBits != 12 {
+		t.Fatalf("mined %+v", header.POW)
+	}
+	if err := header.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the proof is only valid for the header it was mined for
+	header.Height++
+	if err := header.Validate(); err == nil {
+		t.Fatal("pow valid for another header")
+	}
+	header.Height--
+
+	SetChainType(Mainnet)
+	if err := header.Validate(); err == nil {
+		t.Fatal("test sized pow valid on mainnet")
+	}
+}
+
+func TestMineCancelled(t *testing.T) {
+	quit := make(chan struct{})
+	time.AfterFunc(10*time.Millisecond, func() { close(quit) })
+
+	// the search could succeed before it is cancelled, but it is
+	// unlikely to with the largest graphs
+	header := testHeader(1, bytes.Repeat([]byte{0}, BlockHashSize))
+	if err := header.Mine(20, 2, quit); err != errMiningCancelled {
+		t.Fatalf("got %v, want %v", err, errMiningCancelled)
+	}
+}
@@ -60,6 +60,22 @@ const (
 	CapFullNode     = CapFullHist | CapUtxoHist | CapPeerList
 )
 
+// ReasonForBan tells a peer why it was banned, sent in a MsgTypeBanReason message
+type ReasonForBan uint32
+
+const (
+	ReasonNone ReasonForBan = iota
+	ReasonBadBlock
+	ReasonBadCompactBlock
+	ReasonBadBlockHeader
+	ReasonBadTxHashSet
+	ReasonManualBan
+	ReasonFraudHeight
+	ReasonBadHandshake
+	// Too many malformed or unsolicited messages
+	ReasonBadMessage
+)
+
 // Network error codes
 const (
 	NetUnsupportedVersion int = 100
@@ -0,0 +1,890 @@
+package blockchain2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/secp256k1zkp"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+)
+
+// Wire format of a p2p message: MagicCode (2 bytes), message type (1 byte),
+// body length (8 bytes, big endian) and the body produced by Message.Bytes.
+// All integers in the bodies are big endian.
+
+const (
+	// maxStringLen is the longest user agent or error message we read
+	maxStringLen = 1024
+
+	// addrIPv4 and addrIPv6 prefix an encoded peer address
+	addrIPv4 uint8 = 0
+	addrIPv6 uint8 = 1
+
+	// encoded sizes of the parts of the messages, used to bound their bodies
+	addrLen       = 1 + net.IPv6len + 2
+	hashLen       = BlockHashSize
+	maxHeaderLen  = uint64(8 + 8 + 5*BlockHashSize + secp256k1zkp.SecretKeySize + secp256k1zkp.PedersenCommitmentSize + 3*8 + 1 + ProofSize*64/8)
+	maxOutputLen  = uint64(1 + secp256k1zkp.PedersenCommitmentSize + 8 + secp256k1zkp.MaxProofSize)
+	maxKernelLen  = uint64(1 + 8 + 8 + secp256k1zkp.PedersenCommitmentSize + secp256k1zkp.AggSignatureSize)
+	maxKernelIDs  = uint64(MaxBlockWeight / BlockKernelWeight)
+	maxHandLen    = 4 + 4 + 8 + 8 + 2*addrLen + 8 + maxStringLen
+	maxShakeLen   = 4 + 4 + 8 + 8 + maxStringLen
+	maxCompactLen = maxHeaderLen + 8 + 3*8 + uint64(MaxBlockCoinbaseOutputs)*maxOutputLen + uint64(MaxBlockCoinbaseKernels)*maxKernelLen + maxKernelIDs*ShortIDSize
+)
+
+// maxBodyLens bounds the body of each message type by the largest body its
+// Read method accepts. Blocks, transactions and txhashset archives are only
+// bounded by MaxMsgLen. The length is checked as soon as the header is read,
+// so a peer can't make us allocate more than the limit of the announced type.
+var maxBodyLens = map[uint8]uint64{
+	MsgTypeError:             4 + 8 + maxStringLen,
+	MsgTypeHand:              maxHandLen,
+	MsgTypeShake:             maxShakeLen,
+	MsgTypePing:              16,
+	MsgTypePong:              16,
+	MsgTypeGetPeerAddrs:      4,
+	MsgTypePeerAddrs:         4 + uint64(MaxPeerAddrs)*addrLen,
+	MsgTypeGetHeaders:        1 + uint64(MaxLocators)*hashLen,
+	MsgTypeHeader:            maxHeaderLen,
+	MsgTypeHeaders:           2 + MaxBlockHeaders*maxHeaderLen,
+	MsgTypeGetBlock:          hashLen,
+	MsgTypeGetCompactBlock:   hashLen,
+	MsgTypeCompactBlock:      maxCompactLen,
+	MsgTypeTxHashSetRequest:  hashLen + 8,
+	MsgTypeBanReason:         4,
+	MsgTypeGetTransaction:    hashLen,
+	MsgTypeTransactionKernel: hashLen,
+}
+
+var errWrongMagic = errors.New("wrong magic code in message header")
+
+// WriteMessage writes msg to w, framed with the message header
+func WriteMessage(w io.Writer, msg Message) error {
+	return writeFrame(w, msg.Type(), msg.Bytes())
+}
+
+// MaxBodyLen returns the largest body accepted for a message of type msgType
+func MaxBodyLen(msgType uint8) uint64 {
+	if max, ok := maxBodyLens[msgType]; ok {
+		return max
+	}
+
+	return MaxMsgLen
+}
+
+// writeFrame writes a message of type msgType with the given body
+func writeFrame(w io.Writer, msgType uint8, body []byte) error {
+	if max := MaxBodyLen(msgType); uint64(len(body)) > max {
+		return fmt.Errorf("message of type %d too long: %d bytes, limit %d", msgType, len(body), max)
+	}
+
+	buff := new(bytes.Buffer)
+	buff.Write(MagicCode[:])
+	buff.WriteByte(msgType)
+	binary.Write(buff, binary.BigEndian, uint64(len(body)))
+	buff.Write(body)
+
+	_, err := w.Write(buff.Bytes())
+	return err
+}
+
+// ReadMessage reads the next message header and body from r. The body is
+// decoded by the caller according to the message type. A body longer than
+// MaxBodyLen of its type is an error and isn't read.
+func ReadMessage(r io.Reader) (uint8, []byte, error) {
+	header := make([]byte, HeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	if header[0] != MagicCode[0] || header[1] != MagicCode[1] {
+		return 0, nil, errWrongMagic
+	}
+
+	msgType := header[2]
+	length := binary.BigEndian.Uint64(header[3:])
+	if max := MaxBodyLen(msgType); length > max {
+		return 0, nil, fmt.Errorf("message of type %d too long: %d bytes, limit %d", msgType, length, max)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return msgType, body, nil
+}
+
+// writeString writes a length prefixed string
+func writeString(buff *bytes.Buffer, s string) {
+	if err := binary.Write(buff, binary.BigEndian, uint64(len(s))); err != nil {
+		logrus.Fatal(err)
+	}
+	buff.WriteString(s)
+}
+
+// readString reads a length prefixed string of at most maxStringLen bytes
+func readString(r io.Reader) (string, error) {
+	var length uint64
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+
+	if length > maxStringLen {
+		return "", fmt.Errorf("string too long: %d bytes", length)
+	}
+
+	s := make([]byte, length)
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", err
+	}
+
+	return string(s), nil
+}
+
+// writeAddr writes a TCP address as its IP family, IP and port
+func writeAddr(buff *bytes.Buffer, addr *net.TCPAddr) {
+	if ip := addr.IP.To4(); ip != nil {
+		buff.WriteByte(addrIPv4)
+		buff.Write(ip)
+	} else {
+		buff.WriteByte(addrIPv6)
+		buff.Write(addr.IP.To16())
+	}
+
+	if err := binary.Write(buff, binary.BigEndian, uint16(addr.Port)); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+// readAddr reads a TCP address written by writeAddr
+func readAddr(r io.Reader) (*net.TCPAddr, error) {
+	var family uint8
+	if err := binary.Read(r, binary.BigEndian, &family); err != nil {
+		return nil, err
+	}
+
+	var ip net.IP
+	switch family {
+	case addrIPv4:
+		ip = make(net.IP, net.IPv4len)
+	case addrIPv6:
+		ip = make(net.IP, net.IPv6len)
+	default:
+		return nil, fmt.Errorf("invalid address family: %d", family)
+	}
+
+	if _, err := io.ReadFull(r, ip); err != nil {
+		return nil, err
+	}
+
+	var port uint16
+	if err := binary.Read(r, binary.BigEndian, &port); err != nil {
+		return nil, err
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readHash reads a block hash
+func readHash(r io.Reader) (Hash, error) {
+	hash := make(Hash, BlockHashSize)
+	if _, err := io.ReadFull(r, hash); err != nil {
+		return nil, err
+	}
+
+	return hash, nil
+}
+
+// writeHash writes a block hash, which must be BlockHashSize bytes long
+func writeHash(buff *bytes.Buffer, hash Hash) {
+	if len(hash) != BlockHashSize {
+		logrus.Fatal(errors.New("invalid hash len"))
+	}
+	buff.Write(hash)
+}
+
+// Hand is the first part of a handshake, sent by the node opening the connection
+type Hand struct {
+	// Protocol version of the sender
+	Version uint32
+	// Capabilities of the sender
+	Capabilities Capabilities
+	// Randomly generated for each handshake, helps detect self connections
+	Nonce uint64
+	// Total difficulty accumulated by the sender, used to check whether sync
+	// may be needed
+	TotalDifficulty Difficulty
+	// Network address of the sender
+	SenderAddr *net.TCPAddr
+	// Network address of the receiver
+	ReceiverAddr *net.TCPAddr
+	// Name and version of the software
+	UserAgent string
+}
+
+// Bytes implements p2p Message interface
+func (h *Hand) Bytes() []byte {
+	buff := new(bytes.Buffer)
+
+	if err := binary.Write(buff, binary.BigEndian, h.Version); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := binary.Write(buff, binary.BigEndian, uint32(h.Capabilities)); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := binary.Write(buff, binary.BigEndian, h.Nonce); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := binary.Write(buff, binary.BigEndian, uint64(h.TotalDifficulty)); err != nil {
+		logrus.Fatal(err)
+	}
+
+	writeAddr(buff, h.SenderAddr)
+	writeAddr(buff, h.ReceiverAddr)
+	writeString(buff, h.UserAgent)
+
+	return buff.Bytes()
+}
+
+// Type implements p2p Message interface
+func (h *Hand) Type() uint8 {
+	return MsgTypeHand
+}
+
+// Read implements p2p Message interface
+func (h *Hand) Read(r io.Reader) error {
+	if err := binary.Read(r, binary.BigEndian, &h.Version); err != nil {
+		return err
+	}
+
+	if err := binary.Read(r, binary.BigEndian, (*uint32)(&h.Capabilities)); err != nil {
+		return err
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &h.Nonce); err != nil {
+		return err
+	}
+
+	if err := binary.Read(r, binary.BigEndian, (*uint64)(&h.TotalDifficulty)); err != nil {
+		return err
+	}
+
+	var err error
+	if h.SenderAddr, err = readAddr(r); err != nil {
+		return err
+	}
+
+	if h.ReceiverAddr, err = readAddr(r); err != nil {
+		return err
+	}
+
+	h.UserAgent, err = readString(r)
+	return err
+}
+
+// Shake is the second part of a handshake, the answer to Hand
+type Shake struct {
+	// Protocol version of the sender
+	Version uint32
+	// Capabilities of the sender
+	Capabilities Capabilities
+	// Total difficulty accumulated by the sender
+	TotalDifficulty Difficulty
+	// Name and version of the software
+	UserAgent string
+}
+
+// Bytes implements p2p Message interface
+func (h *Shake) Bytes() []byte {
+	buff := new(bytes.Buffer)
+
+	if err := binary.Write(buff, binary.BigEndian, h.Version); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := binary.Write(buff, binary.BigEndian, uint32(h.Capabilities)); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := binary.Write(buff, binary.BigEndian, uint64(h.TotalDifficulty)); err != nil {
+		logrus.Fatal(err)
+	}
+
+	writeString(buff, h.UserAgent)
+
+	return buff.Bytes()
+}
+
+// Type implements p2p Message interface
+func (h *Shake) Type() uint8 {
+	return MsgTypeShake
+}
+
+// Read implements p2p Message interface
+func (h *Shake) Read(r io.Reader) error {
+	if err := binary.Read(r, binary.BigEndian, &h.Version); err != nil {
+		return err
+	}
+
+	if err := binary.Read(r, binary.BigEndian, (*uint32)(&h.Capabilities)); err != nil {
+		return err
+	}
+
+	if err := binary.Read(r, binary.BigEndian, (*uint64)(&h.TotalDifficulty)); err != nil {
+		return err
+	}
+
+	var err error
+	h.UserAgent, err = readString(r)
+	return err
+}
+
+// Ping carries the chain state of the sender, the answer is a Pong
+type Ping struct {
+	// Total difficulty accumulated by the sender
+	TotalDifficulty Difficulty
+	// Height of the sender's best chain
+	Height uint64
+}
+
+// Bytes implements p2p Message interface
+func (p *Ping) Bytes() []byte {
+	buff := new(bytes.Buffer)
+
+	if err := binary.Write(buff, binary.BigEndian, uint64(p.TotalDifficulty)); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := binary.Write(buff, binary.BigEndian, p.Height); err != nil {
+		logrus.Fatal(err)
+	}
+
+	return buff.Bytes()
+}
+
+// Type implements p2p Message interface
+func (p *Ping) Type() uint8 {
+	return MsgTypePing
+}
+
+// Read implements p2p Message interface
+func (p *Ping) Read(r io.Reader) error {
+	if err := binary.Read(r, binary.BigEndian, (*uint64)(&p.TotalDifficulty)); err != nil {
+		return err
+	}
+
+	return binary.Read(r, binary.BigEndian, &p.Height)
+}
+
+// Pong is the answer to a Ping, with the chain state of the sender
+type Pong struct {
+	Ping
+}
+
+// Type implements p2p Message interface
+func (p *Pong) Type() uint8 {
+	return MsgTypePong
+}
+
+// GetPeerAddrs asks for other peers addresses, required for network discovery
+type GetPeerAddrs struct {
+	// Filters on the capabilities we'd like the peers to have
+	Capabilities Capabilities
+}
+
+// Bytes implements p2p Message interface
+func (p *GetPeerAddrs) Bytes() []byte {
+	buff := new(bytes.Buffer)
+
+	if err := binary.Write(buff, binary.BigEndian, uint32(p.Capabilities)); err != nil {
+		logrus.Fatal(err)
+	}
+
+	return buff.Bytes()
+}
+
+// Type implements p2p Message interface
+func (p *GetPeerAddrs) Type() uint8 {
+	return MsgTypeGetPeerAddrs
+}
+
+// Read implements p2p Message interface
+func (p *GetPeerAddrs) Read(r io.Reader) error {
+	return binary.Read(r, binary.BigEndian, (*uint32)(&p.Capabilities))
+}
+
+// PeerAddrs is the answer to GetPeerAddrs, at most MaxPeerAddrs addresses
+type PeerAddrs struct {
+	Peers []*net.TCPAddr
+}
+
+// Bytes implements p2p Message interface
+func (p *PeerAddrs) Bytes() []byte {
+	buff := new(bytes.Buffer)
+
+	if err := binary.Write(buff, binary.BigEndian, uint32(len(p.Peers))); err != nil {
+		logrus.Fatal(err)
+	}
+
+	for _, addr := range p.Peers {
+		writeAddr(buff, addr)
+	}
+
+	return buff.Bytes()
+}
+
+// Type implements p2p Message interface
+func (p *PeerAddrs) Type() uint8 {
+	return MsgTypePeerAddrs
+}
+
+// Read implements p2p Message interface
+func (p *PeerAddrs) Read(r io.Reader) error {
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return err
+	}
+
+	if count > uint32(MaxPeerAddrs) {
+		return fmt.Errorf("too many peer addresses: %d", count)
+	}
+
+	p.Peers = make([]*net.TCPAddr, count)
+	for i := range p.Peers {
+		addr, err := readAddr(r)
+		if err != nil {
+			return err
+		}
+		p.Peers[i] = addr
+	}
+
+	return nil
+}
+
+// PeerError is sent to a peer before closing the connection because of an error
+type PeerError struct {
+	// Error code
+	Code uint32
+	// Human readable description of the error
+	Message string
+}
+
+// Bytes implements p2p Message interface
+func (p *PeerError) Bytes() []byte {
+	buff := new(bytes.Buffer)
+
+	if err := binary.Write(buff, binary.BigEndian, p.Code); err != nil {
+		logrus.Fatal(err)
+	}
+
+	writeString(buff, p.Message)
+
+	return buff.Bytes()
+}
+
+// Type implements p2p Message interface
+func (p *PeerError) Type() uint8 {
+	return MsgTypeError
+}
+
+// Read implements p2p Message interface
+func (p *PeerError) Read(r io.Reader) error {
+	if err := binary.Read(r, binary.BigEndian, &p.Code); err != nil {
+		return err
+	}
+
+	var err error
+	p.Message, err = readString(r)
+	return err
+}
+
+// Error implements error interface
+func (p *PeerError) Error() string {
+	return fmt.Sprintf("peer error %d: %s", p.Code, p.Message)
+}
+
+// BanReason tells a peer why it is being banned
+type BanReason struct {
+	Reason ReasonForBan
+}
+
+// Bytes implements p2p Message interface
+func (b *BanReason) Bytes() []byte {
+	buff := new(bytes.Buffer)
+
+	if err := binary.Write(buff, binary.BigEndian, uint32(b.Reason)); err != nil {
+		logrus.Fatal(err)
+	}
+
+	return buff.Bytes()
+}
+
+// Type implements p2p Message interface
+func (b *BanReason) Type() uint8 {
+	return MsgTypeBanReason
+}
+
+// Read implements p2p Message interface
+func (b *BanReason) Read(r io.Reader) error {
+	return binary.Read(r, binary.BigEndian, (*uint32)(&b.Reason))
+}
+
+// Locator is a request for the block headers following the first of its
+// hashes found on the receiver's chain. Hashes go from the most recent block
+// back to the genesis block, at most MaxLocators of them.
+type Locator struct {
+	Hashes []Hash
+}
+
+// Bytes implements p2p Message interface
+func (l *Locator) Bytes() []byte {
+	buff := new(bytes.Buffer)
+
+	if err := binary.Write(buff, binary.BigEndian, uint8(len(l.Hashes))); err != nil {
+		logrus.Fatal(err)
+	}
+
+	for _, hash := range l.Hashes {
+		writeHash(buff, hash)
+	}
+
+	return buff.Bytes()
+}
+
+// Type implements p2p Message interface
+func (l *Locator) Type() uint8 {
+	return MsgTypeGetHeaders
+}
+
+// Read implements p2p Message interface
+func (l *Locator) Read(r io.Reader) error {
+	var count uint8
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return err
+	}
+
+	if int(count) > MaxLocators {
+		return fmt.Errorf("too many locator hashes: %d", count)
+	}
+
+	l.Hashes = make([]Hash, count)
+	for i := range l.Hashes {
+		hash, err := readHash(r)
+		if err != nil {
+			return err
+		}
+		l.Hashes[i] = hash
+	}
+
+	return nil
+}
+
+// Type implements p2p Message interface, a single header is sent to announce
+// a new block
+func (b *BlockHeader) Type() uint8 {
+	return MsgTypeHeader
+}
+
+// Headers is the answer to a Locator, at most MaxBlockHeaders headers
+type Headers struct {
+	Headers []BlockHeader
+}
+
+// Bytes implements p2p Message interface
+func (h *Headers) Bytes() []byte {
+	buff := new(bytes.Buffer)
+
+	if err := binary.Write(buff, binary.BigEndian, uint16(len(h.Headers))); err != nil {
+		logrus.Fatal(err)
+	}
+
+	for i := range h.Headers {
+		buff.Write(h.Headers[i].Bytes())
+	}
+
+	return buff.Bytes()
+}
+
+// Type implements p2p Message interface
+func (h *Headers) Type() uint8 {
+	return MsgTypeHeaders
+}
+
+// Read implements p2p Message interface
+func (h *Headers) Read(r io.Reader) error {
+	var count uint16
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return err
+	}
+
+	if int(count) > MaxBlockHeaders {
+		return fmt.Errorf("too many headers: %d", count)
+	}
+
+	h.Headers = make([]BlockHeader, count)
+	for i := range h.Headers {
+		if err := h.Headers[i].Read(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hashMessage is the body of the messages carrying a single hash
+type hashMessage struct {
+	Hash Hash
+}
+
+// Bytes implements p2p Message interface
+func (h *hashMessage) Bytes() []byte {
+	buff := new(bytes.Buffer)
+	writeHash(buff, h.Hash)
+
+	return buff.Bytes()
+}
+
+// Read implements p2p Message interface
+func (h *hashMessage) Read(r io.Reader) error {
+	var err error
+	h.Hash, err = readHash(r)
+	return err
+}
+
+// GetBlockHash requests the block with the given hash
+type GetBlockHash struct {
+	hashMessage
+}
+
+// Type implements p2p Message interface
+func (h *GetBlockHash) Type() uint8 {
+	return MsgTypeGetBlock
+}
+
+// GetCompactBlockHash requests the compact block with the given hash
+type GetCompactBlockHash struct {
+	hashMessage
+}
+
+// Type implements p2p Message interface
+func (h *GetCompactBlockHash) Type() uint8 {
+	return MsgTypeGetCompactBlock
+}
+
+// GetTransactionHash requests the transaction with the given kernel hash
+type GetTransactionHash struct {
+	hashMessage
+}
+
+// Type implements p2p Message interface
+func (h *GetTransactionHash) Type() uint8 {
+	return MsgTypeGetTransaction
+}
+
+// TransactionKernelHash announces a transaction by the hash of its kernel,
+// the receiver asks for it with GetTransactionHash if it doesn't have it
+type TransactionKernelHash struct {
+	hashMessage
+}
+
+// Type implements p2p Message interface
+func (h *TransactionKernelHash) Type() uint8 {
+	return MsgTypeTransactionKernel
+}
+
+// CompactBlock is a block with its non coinbase kernels replaced by short
+// ids. The receiver rebuilds the block from the transactions in its pool.
+type CompactBlock struct {
+	Header BlockHeader
+	// Nonce used to compute the short ids
+	Nonce uint64
+	// Outputs and kernels sent in full (coinbase)
+	FullOutputs OutputList
+	FullKernels TxKernelList
+	// Short ids of the other kernels
+	KernelIDs ShortIDList
+}
+
+// Bytes implements p2p Message interface
+func (c *CompactBlock) Bytes() []byte {
+	buff := new(bytes.Buffer)
+	buff.Write(c.Header.Bytes())
+
+	if err := binary.Write(buff, binary.BigEndian, c.Nonce); err != nil {
+		logrus.Fatal(err)
+	}
+
+	for _, n := range []int{len(c.FullOutputs), len(c.FullKernels), len(c.KernelIDs)} {
+		if err := binary.Write(buff, binary.BigEndian, uint64(n)); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	for _, output := range c.FullOutputs {
+		buff.Write(output.Bytes())
+	}
+
+	for _, kernel := range c.FullKernels {
+		buff.Write(kernel.Bytes())
+	}
+
+	for _, id := range c.KernelIDs {
+		if len(id) != ShortIDSize {
+			logrus.Fatal(errors.New("invalid short id len"))
+		}
+		buff.Write(id)
+	}
+
+	return buff.Bytes()
+}
+
+// Type implements p2p Message interface
+func (c *CompactBlock) Type() uint8 {
+	return MsgTypeCompactBlock
+}
+
+// Read implements p2p Message interface
+func (c *CompactBlock) Read(r io.Reader) error {
+	if err := c.Header.Read(r); err != nil {
+		return err
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &c.Nonce); err != nil {
+		return err
+	}
+
+	var outputs, kernels, ids uint64
+	for _, n := range []*uint64{&outputs, &kernels, &ids} {
+		if err := binary.Read(r, binary.BigEndian, n); err != nil {
+			return err
+		}
+	}
+
+	if outputs > uint64(MaxBlockCoinbaseOutputs) || kernels > uint64(MaxBlockCoinbaseKernels) {
+		return errors.New("compact block contains too many full outputs or kernels")
+	}
+	if ids > 1000000 {
+		return errors.New("compact block contains too many kernel ids")
+	}
+
+	c.FullOutputs = make(OutputList, outputs)
+	for i := range c.FullOutputs {
+		if err := c.FullOutputs[i].Read(r); err != nil {
+			return err
+		}
+	}
+
+	c.FullKernels = make(TxKernelList, kernels)
+	for i := range c.FullKernels {
+		if err := c.FullKernels[i].Read(r); err != nil {
+			return err
+		}
+	}
+
+	c.KernelIDs = make(ShortIDList, ids)
+	for i := range c.KernelIDs {
+		id := make(ShortID, ShortIDSize)
+		if _, err := io.ReadFull(r, id); err != nil {
+			return err
+		}
+		c.KernelIDs[i] = id
+	}
+
+	return nil
+}
+
+// TxHashSetRequest asks for the txhashset archive at the given block, used
+// by fast sync nodes
+type TxHashSetRequest struct {
+	Hash   Hash
+	Height uint64
+}
+
+// Bytes implements p2p Message interface
+func (t *TxHashSetRequest) Bytes() []byte {
+	buff := new(bytes.Buffer)
+	writeHash(buff, t.Hash)
+
+	if err := binary.Write(buff, binary.BigEndian, t.Height); err != nil {
+		logrus.Fatal(err)
+	}
+
+	return buff.Bytes()
+}
+
+// Type implements p2p Message interface
+func (t *TxHashSetRequest) Type() uint8 {
+	return MsgTypeTxHashSetRequest
+}
+
+// Read implements p2p Message interface
+func (t *TxHashSetRequest) Read(r io.Reader) error {
+	var err error
+	if t.Hash, err = readHash(r); err != nil {
+		return err
+	}
+
+	return binary.Read(r, binary.BigEndian, &t.Height)
+}
+
+// TxHashSetArchive is the answer to TxHashSetRequest, the archive data
+// follows the block it was taken at
+type TxHashSetArchive struct {
+	Hash   Hash
+	Height uint64
+	Data   []byte
+}
+
+// Bytes implements p2p Message interface
+func (t *TxHashSetArchive) Bytes() []byte {
+	buff := new(bytes.Buffer)
+	writeHash(buff, t.Hash)
+
+	if err := binary.Write(buff, binary.BigEndian, t.Height); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := binary.Write(buff, binary.BigEndian, uint64(len(t.Data))); err != nil {
+		logrus.Fatal(err)
+	}
+	buff.Write(t.Data)
+
+	return buff.Bytes()
+}
+
+// Type implements p2p Message interface
+func (t *TxHashSetArchive) Type() uint8 {
+	return MsgTypeTxHashSetArchive
+}
+
+// Read implements p2p Message interface
+func (t *TxHashSetArchive) Read(r io.Reader) error {
+	var err error
+	if t.Hash, err = readHash(r); err != nil {
+		return err
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &t.Height); err != nil {
+		return err
+	}
+
+	var length uint64
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return err
+	}
+
+	if length > MaxMsgLen {
+		return fmt.Errorf("txhashset archive too long: %d bytes", length)
+	}
+
+	t.Data = make([]byte, length)
+	_, err = io.ReadFull(r, t.Data)
+	return err
+}
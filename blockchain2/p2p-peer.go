@@ -0,0 +1,615 @@
+package blockchain2
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// handshakeTimeout is how long we wait for the remote side of a handshake
+	handshakeTimeout = 10 * time.Second
+
+	// writeTimeout is how long a single message may take to be written
+	writeTimeout = 30 * time.Second
+)
+
+var errPeerClosed = errors.New("peer connection closed")
+
+// Adapter connects peers to the chain and the peer list of a node. Methods
+// returning data are called to answer requests, nil meaning we don't have it.
+// Methods receiving data may return an error, the connection to a peer that
+// sent invalid data is closed.
+type Adapter interface {
+	// ChainState returns the total difficulty and height of our best chain
+	ChainState() (Difficulty, uint64)
+
+	// PeerAddrs returns addresses of peers having the capabilities
+	PeerAddrs(capabilities Capabilities) []*net.TCPAddr
+
+	// Headers returns the headers following the first locator hash found on
+	// our chain
+	Headers(locator Locator) []BlockHeader
+
+	// Block returns the block with the given hash
+	Block(hash Hash) *Block
+
+	// CompactBlock returns the compact block with the given hash
+	CompactBlock(hash Hash) *CompactBlock
+
+	// TxHashSet returns the txhashset archive at the given block
+	TxHashSet(hash Hash, height uint64) *TxHashSetArchive
+
+	// Transaction returns the pool transaction with the given kernel hash
+	Transaction(kernelHash Hash) *Transaction
+
+	PeerAddrsReceived(p *Peer, addrs []*net.TCPAddr)
+	HeaderReceived(p *Peer, header *BlockHeader) error
+	HeadersReceived(p *Peer, headers []BlockHeader) error
+	BlockReceived(p *Peer, block *Block) error
+	CompactBlockReceived(p *Peer, block *CompactBlock) error
+	TransactionKernelReceived(p *Peer, hash Hash)
+	// TransactionReceived is called with a transaction to add to the pool,
+	// stem is true for a transaction in the stem phase of dandelion
+	TransactionReceived(p *Peer, tx *Transaction, stem bool) error
+	TxHashSetReceived(p *Peer, archive *TxHashSetArchive) error
+
+	// BanReceived is called when the peer tells us it banned us, the
+	// connection is closed afterwards
+	BanReceived(p *Peer, reason ReasonForBan)
+}
+
+// Handshake opens and accepts connections for a node. It remembers the
+// nonces of the Hand messages it sent, so that a connection to ourselves is
+// detected when our own Hand comes back.
+type Handshake struct {
+	// Capabilities of our node
+	Capabilities Capabilities
+	// Name and version of our software
+	UserAgent string
+	// Address we are listening on, sent to the peers in Hand
+	Addr *net.TCPAddr
+	// Adapter used by the peers
+	Adapter Adapter
+
+	mu     sync.Mutex
+	nonces map[uint64]bool
+}
+
+// NewHandshake returns a Handshake for a node listening on addr
+func NewHandshake(capabilities Capabilities, userAgent string, addr *net.TCPAddr, adapter Adapter) *Handshake {
+	return &Handshake{
+		Capabilities: capabilities,
+		UserAgent:    userAgent,
+		Addr:         addr,
+		Adapter:      adapter,
+		nonces:       make(map[uint64]bool),
+	}
+}
+
+// Connect opens a connection to addr and performs the handshake
+func (h *Handshake) Connect(addr string) (*Peer, error) {
+	conn, err := net.DialTimeout("tcp", addr, handshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := h.initiate(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Accept performs the handshake on a connection opened by a peer
+func (h *Handshake) Accept(conn net.Conn) (*Peer, error) {
+	p, err := h.respond(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// initiate sends our Hand and waits for the Shake
+func (h *Handshake) initiate(conn net.Conn) (*Peer, error) {
+	nonce := randomNonce()
+	h.mu.Lock()
+	h.nonces[nonce] = true
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.nonces, nonce)
+		h.mu.Unlock()
+	}()
+
+	totalDifficulty, _ := h.Adapter.ChainState()
+	hand := &Hand{
+		Version:         ProtocolVersion,
+		Capabilities:    h.Capabilities,
+		Nonce:           nonce,
+		TotalDifficulty: totalDifficulty,
+		SenderAddr:      h.Addr,
+		ReceiverAddr:    tcpAddr(conn.RemoteAddr()),
+		UserAgent:       h.UserAgent,
+	}
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := WriteMessage(conn, hand); err != nil {
+		return nil, err
+	}
+
+	var shake Shake
+	if err := readExpected(conn, &shake); err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	if shake.Version != ProtocolVersion {
+		return nil, fmt.Errorf("incompatible protocol version %d", shake.Version)
+	}
+
+	p := newPeer(conn, h.Adapter)
+	p.Addr = tcpAddr(conn.RemoteAddr())
+	p.Version = shake.Version
+	p.Capabilities = shake.Capabilities
+	p.UserAgent = shake.UserAgent
+	p.totalDifficulty = shake.TotalDifficulty
+	p.start()
+
+	return p, nil
+}
+
+// respond waits for the Hand of the peer and answers with our Shake
+func (h *Handshake) respond(conn net.Conn) (*Peer, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	var hand Hand
+	if err := readExpected(conn, &hand); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	self := h.nonces[hand.Nonce]
+	h.mu.Unlock()
+	if self {
+		return nil, errors.New("connected to ourselves")
+	}
+
+	if hand.Version != ProtocolVersion {
+		WriteMessage(conn, &PeerError{uint32(NetUnsupportedVersion), fmt.Sprintf("unsupported protocol version %d", hand.Version)})
+		return nil, fmt.Errorf("incompatible protocol version %d", hand.Version)
+	}
+
+	totalDifficulty, _ := h.Adapter.ChainState()
+	shake := &Shake{
+		Version:         ProtocolVersion,
+		Capabilities:    h.Capabilities,
+		TotalDifficulty: totalDifficulty,
+		UserAgent:       h.UserAgent,
+	}
+	if err := WriteMessage(conn, shake); err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	p := newPeer(conn, h.Adapter)
+	p.Inbound = true
+	p.Addr = hand.SenderAddr
+	p.Version = hand.Version
+	p.Capabilities = hand.Capabilities
+	p.UserAgent = hand.UserAgent
+	p.totalDifficulty = hand.TotalDifficulty
+	p.start()
+
+	return p, nil
+}
+
+// readExpected reads the next message into msg, failing if it has another type.
+// A PeerError is returned as error.
+func readExpected(r io.Reader, msg Message) error {
+	msgType, body, err := ReadMessage(r)
+	if err != nil {
+		return err
+	}
+
+	if msgType == MsgTypeError && msg.Type() != MsgTypeError {
+		var peerError PeerError
+		if err := peerError.Read(bytes.NewReader(body)); err != nil {
+			return err
+		}
+		return &peerError
+	}
+
+	if msgType != msg.Type() {
+		return fmt.Errorf("unexpected message type %d, expected %d", msgType, msg.Type())
+	}
+
+	return msg.Read(bytes.NewReader(body))
+}
+
+func randomNonce() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint64(time.Now().UnixNano())
+	}
+
+	return binary.BigEndian.Uint64(b[:])
+}
+
+func tcpAddr(addr net.Addr) *net.TCPAddr {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp
+	}
+
+	return &net.TCPAddr{IP: net.IPv4zero}
+}
+
+// Peer is a connection to a remote node that completed the handshake. It
+// implements Protocol, messages it reads are dispatched to the Adapter.
+type Peer struct {
+	// Address the peer is listening on for inbound connections, the remote
+	// address for outbound ones
+	Addr *net.TCPAddr
+	// Whether the peer opened the connection
+	Inbound bool
+
+	// Information exchanged during the handshake
+	Version      uint32
+	Capabilities Capabilities
+	UserAgent    string
+
+	conn    net.Conn
+	adapter Adapter
+	writeMu sync.Mutex
+
+	// chain state last reported by the peer
+	mu              sync.Mutex
+	totalDifficulty Difficulty
+	height          uint64
+
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+var _ Protocol = (*Peer)(nil)
+
+func newPeer(conn net.Conn, adapter Adapter) *Peer {
+	return &Peer{
+		conn:    conn,
+		adapter: adapter,
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// start starts reading messages from the peer
+func (p *Peer) start() {
+	go func() {
+		defer close(p.done)
+		p.err = p.readLoop()
+		p.Close()
+	}()
+}
+
+// String implements String() interface
+func (p *Peer) String() string {
+	return p.conn.RemoteAddr().String()
+}
+
+// ChainState returns the total difficulty and height last reported by the peer
+func (p *Peer) ChainState() (Difficulty, uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.totalDifficulty, p.height
+}
+
+// Wait waits for the connection to be closed and returns why it was closed,
+// nil if Close was called
+func (p *Peer) Wait() error {
+	<-p.done
+
+	return p.err
+}
+
+// Close the connection to the remote peer
+func (p *Peer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+		p.conn.Close()
+	})
+}
+
+// SendMessage writes a message to the peer. The connection is closed if the
+// message can't be written.
+func (p *Peer) SendMessage(msg Message) error {
+	return p.sendFrame(msg.Type(), msg.Bytes())
+}
+
+func (p *Peer) sendFrame(msgType uint8, body []byte) error {
+	select {
+	case <-p.quit:
+		return errPeerClosed
+	default:
+	}
+
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := writeFrame(p.conn, msgType, body); err != nil {
+		logrus.Infof("failed to write to %s: %v", p, err)
+		p.Close()
+		return err
+	}
+
+	return nil
+}
+
+// SendPing sends a Ping message with our chain state to the remote peer
+func (p *Peer) SendPing() {
+	totalDifficulty, height := p.adapter.ChainState()
+	p.SendMessage(&Ping{totalDifficulty, height})
+}
+
+// SendBlock sends a block to our remote peer
+func (p *Peer) SendBlock(block *Block) {
+	p.SendMessage(block)
+}
+
+// SendCompactBlock sends a compact block to our remote peer
+func (p *Peer) SendCompactBlock(block *CompactBlock) {
+	p.SendMessage(block)
+}
+
+// SendHeader announces a new block by its header
+func (p *Peer) SendHeader(header *BlockHeader) {
+	p.SendMessage(header)
+}
+
+// SendTransaction relays a transaction to the remote peer
+func (p *Peer) SendTransaction(tx Transaction) {
+	p.SendMessage(&tx)
+}
+
+// SendStemTransaction relays a transaction in the stem phase of dandelion
+func (p *Peer) SendStemTransaction(tx Transaction) {
+	p.sendFrame(MsgTypeStemTransaction, tx.Bytes())
+}
+
+// SendTransactionKernel announces a transaction by the hash of its kernel
+func (p *Peer) SendTransactionKernel(hash Hash) {
+	p.SendMessage(&TransactionKernelHash{hashMessage{hash}})
+}
+
+// SendTransactionRequest sends a request for a transaction from its kernel hash
+func (p *Peer) SendTransactionRequest(hash Hash) {
+	p.SendMessage(&GetTransactionHash{hashMessage{hash}})
+}
+
+// SendHeaderRequest sends a request for block headers based on the provided block locator
+func (p *Peer) SendHeaderRequest(locator Locator) {
+	p.SendMessage(&locator)
+}
+
+// SendBlockRequest sends a request for a block from its hash
+func (p *Peer) SendBlockRequest(hash Hash) {
+	p.SendMessage(&GetBlockHash{hashMessage{hash}})
+}
+
+// SendCompactBlockRequest sends a request for a compact block from its hash
+func (p *Peer) SendCompactBlockRequest(hash Hash) {
+	p.SendMessage(&GetCompactBlockHash{hashMessage{hash}})
+}
+
+// SendPeerRequest sends a request for some peer addresses
+func (p *Peer) SendPeerRequest(capabilities Capabilities) {
+	p.SendMessage(&GetPeerAddrs{capabilities})
+}
+
+// SendBanReason tells the peer why it is banned and closes the connection
+func (p *Peer) SendBanReason(reason ReasonForBan) {
+	p.SendMessage(&BanReason{reason})
+	p.Close()
+}
+
+// readLoop reads messages until the connection is closed or the peer sends
+// an invalid message
+func (p *Peer) readLoop() error {
+	for {
+		msgType, body, err := ReadMessage(p.conn)
+		if err != nil {
+			select {
+			case <-p.quit:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		if err := p.handle(msgType, bytes.NewReader(body)); err != nil {
+			logrus.Infof("bad message %d from %s: %v", msgType, p, err)
+			return err
+		}
+	}
+}
+
+// handle dispatches a message from the peer
+func (p *Peer) handle(msgType uint8, r io.Reader) error {
+	switch msgType {
+	case MsgTypeError:
+		var msg PeerError
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		return &msg
+
+	case MsgTypeHand, MsgTypeShake:
+		return errors.New("unexpected handshake message")
+
+	case MsgTypePing:
+		var msg Ping
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		p.setChainState(msg.TotalDifficulty, msg.Height)
+
+		totalDifficulty, height := p.adapter.ChainState()
+		p.SendMessage(&Pong{Ping{totalDifficulty, height}})
+
+	case MsgTypePong:
+		var msg Pong
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		p.setChainState(msg.TotalDifficulty, msg.Height)
+
+	case MsgTypeGetPeerAddrs:
+		var msg GetPeerAddrs
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		addrs := p.adapter.PeerAddrs(msg.Capabilities)
+		if len(addrs) > MaxPeerAddrs {
+			addrs = addrs[:MaxPeerAddrs]
+		}
+		p.SendMessage(&PeerAddrs{addrs})
+
+	case MsgTypePeerAddrs:
+		var msg PeerAddrs
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		p.adapter.PeerAddrsReceived(p, msg.Peers)
+
+	case MsgTypeGetHeaders:
+		var msg Locator
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		headers := p.adapter.Headers(msg)
+		if len(headers) > MaxBlockHeaders {
+			headers = headers[:MaxBlockHeaders]
+		}
+		p.SendMessage(&Headers{headers})
+
+	case MsgTypeHeader:
+		var msg BlockHeader
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		return p.adapter.HeaderReceived(p, &msg)
+
+	case MsgTypeHeaders:
+		var msg Headers
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		return p.adapter.HeadersReceived(p, msg.Headers)
+
+	case MsgTypeGetBlock:
+		var msg GetBlockHash
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		if block := p.adapter.Block(msg.Hash); block != nil {
+			p.SendBlock(block)
+		}
+
+	case MsgTypeBlock:
+		var msg Block
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		return p.adapter.BlockReceived(p, &msg)
+
+	case MsgTypeGetCompactBlock:
+		var msg GetCompactBlockHash
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		if block := p.adapter.CompactBlock(msg.Hash); block != nil {
+			p.SendCompactBlock(block)
+		}
+
+	case MsgTypeCompactBlock:
+		var msg CompactBlock
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		return p.adapter.CompactBlockReceived(p, &msg)
+
+	case MsgTypeStemTransaction, MsgTypeTransaction:
+		var msg Transaction
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		return p.adapter.TransactionReceived(p, &msg, msgType == MsgTypeStemTransaction)
+
+	case MsgTypeGetTransaction:
+		var msg GetTransactionHash
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		if tx := p.adapter.Transaction(msg.Hash); tx != nil {
+			p.SendTransaction(*tx)
+		}
+
+	case MsgTypeTransactionKernel:
+		var msg TransactionKernelHash
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		p.adapter.TransactionKernelReceived(p, msg.Hash)
+
+	case MsgTypeTxHashSetRequest:
+		var msg TxHashSetRequest
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		if archive := p.adapter.TxHashSet(msg.Hash, msg.Height); archive != nil {
+			p.SendMessage(archive)
+		}
+
+	case MsgTypeTxHashSetArchive:
+		var msg TxHashSetArchive
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		return p.adapter.TxHashSetReceived(p, &msg)
+
+	case MsgTypeBanReason:
+		var msg BanReason
+		if err := msg.Read(r); err != nil {
+			return err
+		}
+		p.adapter.BanReceived(p, msg.Reason)
+		return fmt.Errorf("banned by peer, reason %d", msg.Reason)
+
+	default:
+		logrus.Infof("ignoring unknown message type %d from %s", msgType, p)
+	}
+
+	return nil
+}
+
+func (p *Peer) setChainState(totalDifficulty Difficulty, height uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.totalDifficulty = totalDifficulty
+	p.height = height
+}
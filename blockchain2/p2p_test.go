@@ -0,0 +1,292 @@
+package blockchain2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/azd1997/golang-MimbleWimble-try/secp256k1zkp"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testAdapter serves a fixed chain of headers and records what it receives
+type testAdapter struct {
+	height  uint64
+	headers []BlockHeader
+	pool    []Transaction
+
+	mu       sync.Mutex
+	received []BlockHeader
+	addrs    []*net.TCPAddr
+	txs      []*Transaction
+	stem     []bool
+}
+
+func (a *testAdapter) ChainState() (Difficulty, uint64) {
+	return Difficulty(a.height * 10), a.height
+}
+
+func (a *testAdapter) PeerAddrs(capabilities Capabilities) []*net.TCPAddr {
+	return []*net.TCPAddr{{IP: net.IPv4(10, 0, 0, 1), Port: 3414}, {IP: net.ParseIP("::1"), Port: 13414}}
+}
+
+func (a *testAdapter) Headers(locator Locator) []BlockHeader {
+	for i, header := range a.headers {
+		if len(locator.Hashes) > 0 && bytes.Equal(header.Previous, locator.Hashes[0]) {
+			return a.headers[i:]
+		}
+	}
+	return nil
+}
+
+func (a *testAdapter) Block(hash Hash) *Block                                  { return nil }
+func (a *testAdapter) CompactBlock(hash Hash) *CompactBlock                    { return nil }
+func (a *testAdapter) TxHashSet(hash Hash, height uint64) *TxHashSetArchive    { return nil }
+func (a *testAdapter) HeaderReceived(p *Peer, header *BlockHeader) error       { return nil }
+func (a *testAdapter) BlockReceived(p *Peer, block *Block) error               { return nil }
+func (a *testAdapter) CompactBlockReceived(p *Peer, block *CompactBlock) error { return nil }
+func (a *testAdapter) TransactionKernelReceived(p *Peer, hash Hash)            {}
+func (a *testAdapter) TxHashSetReceived(p *Peer, archive *TxHashSetArchive) error {
+	return nil
+}
+func (a *testAdapter) BanReceived(p *Peer, reason ReasonForBan) {}
+
+func (a *testAdapter) Transaction(kernelHash Hash) *Transaction {
+	for i := range a.pool {
+		if bytes.Equal(a.pool[i].Kernels[0].Hash(), kernelHash) {
+			return &a.pool[i]
+		}
+	}
+	return nil
+}
+
+func (a *testAdapter) TransactionReceived(p *Peer, tx *Transaction, stem bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.txs = append(a.txs, tx)
+	a.stem = append(a.stem, stem)
+	return nil
+}
+
+func (a *testAdapter) PeerAddrsReceived(p *Peer, addrs []*net.TCPAddr) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.addrs = addrs
+}
+
+func (a *testAdapter) HeadersReceived(p *Peer, headers []BlockHeader) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.received = headers
+	return nil
+}
+
+func testHeader(height uint64, previous Hash) BlockHeader {
+	hash := func(b byte) Hash { return bytes.Repeat([]byte{b}, BlockHashSize) }
+
+	return BlockHeader{
+		Height:            height,
+		Previous:          previous,
+		PreviousRoot:      hash(1),
+		Timestamp:         time.Unix(1500000000+int64(height)*60, 0).UTC(),
+		UTXORoot:          hash(2),
+		RangeProofRoot:    hash(3),
+		KernelRoot:        hash(4),
+		Nonce:             height,
+		TotalKernelOffset: hash(5),
+		TotalKernelSum:    secp256k1zkp.H.Bytes(),
+		POW:               Proof{EdgeBits: 29, Nonces: make([]uint32, ProofSize)},
+	}
+}
+
+// connect starts a listener for server and connects client to it
+func connect(t *testing.T, client, server Adapter) (*Peer, *Peer) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan *Peer, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			accepted <- nil
+			return
+		}
+		p, err := NewHandshake(CapFullNode, "server", tcpAddr(ln.Addr()), server).Accept(conn)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- p
+	}()
+
+	p, err := NewHandshake(CapPeerList, "client", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3414}, client).Connect(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := <-accepted
+	if q == nil {
+		t.FailNow()
+	}
+
+	return p, q
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandshakeAndPing(t *testing.T) {
+	client := &testAdapter{height: 3}
+	server := &testAdapter{height: 7}
+	p, q := connect(t, client, server)
+	defer p.Close()
+	defer q.Close()
+
+	if p.UserAgent != "server" || p.Capabilities != CapFullNode || q.UserAgent != "client" || !q.Inbound {
+		t.Fatalf("unexpected handshake result: %+v %+v", p, q)
+	}
+	if q.Addr.Port != 3414 {
+		t.Fatalf("inbound peer address %v, want the advertised one", q.Addr)
+	}
+	if td, _ := p.ChainState(); td != 70 {
+		t.Fatalf("total difficulty %d from shake, want 70", td)
+	}
+
+	p.SendPing()
+	waitFor(t, "pong", func() bool {
+		_, height := p.ChainState()
+		return height == 7
+	})
+	if _, height := q.ChainState(); height != 3 {
+		t.Fatalf("server got height %d from ping, want 3", height)
+	}
+}
+
+func TestPeerAndHeaderRequests(t *testing.T) {
+	genesis := testHeader(0, bytes.Repeat([]byte{0}, BlockHashSize))
+	first := testHeader(1, genesis.Hash())
+	second := testHeader(2, first.Hash())
+
+	client := &testAdapter{}
+	server := &testAdapter{headers: []BlockHeader{genesis, first, second}}
+	p, q := connect(t, client, server)
+	defer p.Close()
+	defer q.Close()
+
+	p.SendPeerRequest(CapFullNode)
+	p.SendHeaderRequest(Locator{[]Hash{genesis.Hash()}})
+
+	waitFor(t, "headers and peer addresses", func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.received) == 2 && len(client.addrs) == 2
+	})
+
+	if !bytes.Equal(client.received[1].Hash(), second.Hash()) || client.received[1].Height != 2 {
+		t.Fatalf("received header %v, want %v", client.received[1], second)
+	}
+	if client.addrs[1].String() != "[::1]:13414" {
+		t.Fatalf("received address %v", client.addrs[1])
+	}
+}
+
+func TestSelfConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	h := NewHandshake(CapFullNode, "self", tcpAddr(ln.Addr()), &testAdapter{})
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			_, err = h.Accept(conn)
+		}
+		accepted <- err
+	}()
+
+	if _, err := h.Connect(ln.Addr().String()); err == nil {
+		t.Fatal("connected to ourselves")
+	}
+	if err := <-accepted; err == nil {
+		t.Fatal("accepted a connection to ourselves")
+	}
+}
+
+func TestTransactionRelay(t *testing.T) {
+	tx := testTransaction(t)
+	client := &testAdapter{}
+	server := &testAdapter{pool: []Transaction{tx}}
+	p, q := connect(t, client, server)
+	defer p.Close()
+	defer q.Close()
+
+	// the client asks for an announced transaction, and stems another
+	p.SendTransactionRequest(tx.Kernels[0].Hash())
+	p.SendTransactionRequest(bytes.Repeat([]byte{1}, BlockHashSize))
+	q.SendStemTransaction(tx)
+
+	waitFor(t, "transactions", func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.txs) == 2
+	})
+
+	for i, received := range client.txs {
+		if !bytes.Equal(received.Bytes(), tx.Bytes()) {
+			t.Fatalf("received transaction %d differs", i)
+		}
+		if err := received.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client.stem[0] == client.stem[1] {
+		t.Fatalf("stem flags %v, want one stem transaction", client.stem)
+	}
+}
+
+func TestMessageSizeLimits(t *testing.T) {
+	// the largest messages of the bounded types are still accepted
+	headers := &Headers{Headers: make([]BlockHeader, MaxBlockHeaders)}
+	for i := range headers.Headers {
+		headers.Headers[i] = testHeader(uint64(i), bytes.Repeat([]byte{6}, BlockHashSize))
+		headers.Headers[i].POW.EdgeBits = 64
+	}
+	addr := &net.TCPAddr{IP: net.ParseIP("::1"), Port: 3414}
+	hand := &Hand{SenderAddr: addr, ReceiverAddr: addr, UserAgent: string(make([]byte, maxStringLen))}
+	for _, msg := range []Message{headers, hand, &headers.Headers[0]} {
+		buff := new(bytes.Buffer)
+		if err := WriteMessage(buff, msg); err != nil {
+			t.Fatal(err)
+		}
+		if msgType, _, err := ReadMessage(buff); err != nil || msgType != msg.Type() {
+			t.Fatalf("message of type %d: %v", msg.Type(), err)
+		}
+	}
+
+	// a body over the limit of its type is rejected from the header alone
+	for _, msgType := range []uint8{MsgTypePing, MsgTypeGetBlock, MsgTypeHeaders, MsgTypeCompactBlock, MsgTypeBlock} {
+		header := make([]byte, HeaderLen)
+		copy(header, MagicCode[:])
+		header[2] = msgType
+		binary.BigEndian.PutUint64(header[3:], MaxBodyLen(msgType)+1)
+
+		if _, _, err := ReadMessage(bytes.NewReader(header)); err == nil || err == io.ErrUnexpectedEOF {
+			t.Errorf("message of type %d: error %v, want a length error", msgType, err)
+		}
+	}
+}
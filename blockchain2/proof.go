@@ -9,7 +9,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"github.com/dblokhin/gringo/cuckoo"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2/cuckoo"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/blake2b"
 	"io"
@@ -28,16 +28,30 @@ var (
 	errInvalidPow = errors.New("invalid pow verify")
 )
 
-// Validate validates the pow
+// Validate validates the pow: the nonces must be a cycle of ProofSize edges
+// in the cuckoo graph of size cuckooSize keyed by the header without its pow.
 func (p *Proof) Validate(header *BlockHeader, cuckooSize uint8) error {
 	logrus.Infof("block POW validate for size %d", cuckooSize)
 
-	cuckoo := cuckoo.NewCuckaroo(header.bytesWithoutPOW())
-	if cuckoo.Verify(header.POW.Nonces, header.POW.EdgeBits) {
-		return nil
+	if len(p.Nonces) != ProofSize {
+		return errInvalidPow
 	}
 
-	return errInvalidPow
+	if err := powGraph(header, cuckooSize).Validate(p.Nonces, cuckooSize); err != nil {
+		return fmt.Errorf("%v: %v", errInvalidPow, err)
+	}
+
+	return nil
+}
+
+// powGraph returns the cuckoo graph of the header for the size: the secondary
+// pow size is a cuckaroo graph, any other a cuckatoo graph.
+func powGraph(header *BlockHeader, cuckooSize uint8) *cuckoo.Cuckoo {
+	if cuckooSize == SecondPowEdgeBits {
+		return cuckoo.NewCuckaroo(header.bytesWithoutPOW())
+	}
+
+	return cuckoo.NewCuckatoo(header.bytesWithoutPOW())
 }
 
 // ToDifficulty converts the proof to a proof-of-work Target so they can be compared.
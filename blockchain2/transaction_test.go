@@ -0,0 +1,158 @@
+package blockchain2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/azd1997/golang-MimbleWimble-try/secp256k1zkp"
+	"github.com/yoss22/bulletproofs"
+	"math/big"
+	"sort"
+	"testing"
+)
+
+// testOutput returns a plain output of value v and its blinding factor
+func testOutput(t *testing.T, prover *bulletproofs.Prover, v uint64) (Output, *big.Int) {
+	blind := secp256k1zkp.RandomInt()
+	commit := secp256k1zkp.CommitValue(blind, new(big.Int).SetUint64(v))
+
+	proof, err := prover.CreateRangeProof(commit, new(big.Int).SetUint64(v), blind, secp256k1zkp.RandomBytes(), [16]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Output{Features: DefaultOutput, Commit: commit, RangeProof: proof}, blind
+}
+
+// testKernel returns a kernel signed with the excess blinding factor
+func testKernel(excess *big.Int, fee uint64) TxKernel {
+	public := bulletproofs.ScalarMulPoint(&secp256k1zkp.G, excess)
+	sig := secp256k1zkp.SignMessage(*public, *excess, secp256k1zkp.ComputeMessage(fee, 0))
+
+	return TxKernel{Features: DefaultKernel, Fee: fee, Excess: *public, ExcessSig: sig.Bytes()}
+}
+
+// testTransaction spends an input of 100 to outputs of 60 and 38 with a fee
+// of 2
+func testTransaction(t *testing.T) Transaction {
+	prover := bulletproofs.NewProver(64)
+
+	inBlind := secp256k1zkp.RandomInt()
+	input := Input{
+		Features: DefaultOutput,
+		Commit:   secp256k1zkp.CommitValue(inBlind, big.NewInt(100)).Bytes(),
+	}
+
+	out1, blind1 := testOutput(t, prover, 60)
+	out2, blind2 := testOutput(t, prover, 38)
+
+	// excess + offset = outputs blinding factors - inputs blinding factors
+	offset := secp256k1zkp.RandomInt()
+	excess := bulletproofs.Sum(blind1, blind2, bulletproofs.Neg(inBlind), bulletproofs.Neg(offset))
+
+	tx := Transaction{
+		KernelOffset: bulletproofs.GetB32(offset),
+		Inputs:       InputList{input},
+		Outputs:      OutputList{out1, out2},
+		Kernels:      TxKernelList{testKernel(excess, 2)},
+	}
+	sort.Sort(tx.Outputs)
+
+	return tx
+}
+
+func TestTransactionValidate(t *testing.T) {
+	tx := testTransaction(t)
+	if err := tx.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a transaction round trips and stays valid
+	var read Transaction
+	if err := read.Read(bytes.NewReader(tx.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read.Bytes(), tx.Bytes()) {
+		t.Fatal("transaction changed by serialization")
+	}
+	if err := read.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(tx *Transaction)
+	}{
+		{"higher fee", func(tx *Transaction) {
+			// re-signed, so only the sums are wrong
+			tx.Kernels[0] = testKernel(secp256k1zkp.RandomInt(), 3)
+		}},
+		{"fee not signed", func(tx *Transaction) { tx.Kernels[0].Fee = 1 }},
+		{"offset", func(tx *Transaction) { tx.KernelOffset[31] ^= 1 }},
+		{"no kernel", func(tx *Transaction) { tx.Kernels = nil }},
+		{"dropped output", func(tx *Transaction) { tx.Outputs = tx.Outputs[:1] }},
+		{"unsorted", func(tx *Transaction) { tx.Outputs[0], tx.Outputs[1] = tx.Outputs[1], tx.Outputs[0] }},
+		{"coinbase", func(tx *Transaction) { tx.Kernels[0].Features = CoinbaseKernel }},
+		{"range proof", func(tx *Transaction) { tx.Outputs[0].RangeProof = tx.Outputs[1].RangeProof }},
+		{"cut through", func(tx *Transaction) {
+			tx.Inputs = append(tx.Inputs, Input{DefaultOutput, tx.Outputs[0].Commit.Bytes()})
+			sort.Sort(tx.Inputs)
+		}},
+	}
+
+	for _, test := range tests {
+		var tx Transaction
+		if err := tx.Read(bytes.NewReader(read.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		test.modify(&tx)
+		if err := tx.Validate(); err == nil {
+			t.Errorf("%s: transaction valid", test.name)
+		}
+	}
+}
+
+func TestVerifySorted(t *testing.T) {
+	tx := testTransaction(t)
+	block := Block{Inputs: tx.Inputs, Outputs: tx.Outputs, Kernels: tx.Kernels}
+	if err := tx.verifySorted(); err != nil {
+		t.Fatal(err)
+	}
+	if err := block.verifySorted(); err != nil {
+		t.Fatal(err)
+	}
+
+	// sorted lists with a repeated element are rejected, as in Grin
+	tx.Outputs = OutputList{tx.Outputs[0], tx.Outputs[0], tx.Outputs[1]}
+	if err := tx.verifySorted(); err == nil {
+		t.Error("transaction with a duplicate output is sorted")
+	}
+	block.Kernels = TxKernelList{block.Kernels[0], block.Kernels[0]}
+	if err := block.verifySorted(); err == nil {
+		t.Error("block with a duplicate kernel is sorted")
+	}
+}
+
+func TestTransactionRead(t *testing.T) {
+	tx := testTransaction(t)
+	body := tx.Bytes()
+
+	// unsorted outputs are rejected
+	tx.Outputs[0], tx.Outputs[1] = tx.Outputs[1], tx.Outputs[0]
+	unsorted := append([]byte(nil), body...)
+	offset := 32 + 3*8 + len(tx.Inputs[0].Bytes())
+	first, second := tx.Outputs[1].Bytes(), tx.Outputs[0].Bytes()
+	copy(unsorted[offset:], second)
+	copy(unsorted[offset+len(second):], first)
+
+	var read Transaction
+	if err := read.Read(bytes.NewReader(unsorted)); err == nil {
+		t.Fatal("read unsorted transaction")
+	}
+
+	// as are transactions that can't fit in a block, before reading them
+	tooMany := append([]byte(nil), body[:56]...)
+	binary.BigEndian.PutUint64(tooMany[32:], uint64(MaxBlockWeight))
+	if err := read.Read(bytes.NewReader(tooMany)); err == nil || err.Error() != "transaction exceeds the maximum weight" {
+		t.Fatalf("read transaction with too many inputs: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package blockchain2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/secp256k1zkp"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/yoss22/bulletproofs"
+	"math/big"
+)
+
+// 承诺的求和：交易和区块都要验证 输出承诺 - 输入承诺 与 交易核盈余 + 偏移量 是否相等
+// 佩德森承诺 C = r*G + v*H，盲因子部分由交易核签名证明，数值部分则必须抵消
+
+// infinity is the identity of the curve group, the sum of no points
+func infinity() *bulletproofs.Point {
+	return &bulletproofs.Point{X: new(big.Int), Y: new(big.Int)}
+}
+
+// negPoint returns -p
+func negPoint(p *bulletproofs.Point) *bulletproofs.Point {
+	if p.Y.Sign() == 0 {
+		return &bulletproofs.Point{X: new(big.Int).Set(p.X), Y: new(big.Int)}
+	}
+
+	return &bulletproofs.Point{X: new(big.Int).Set(p.X), Y: new(big.Int).Sub(btcec.S256().P, p.Y)}
+}
+
+// sumPoints returns the sum of the positive points minus the sum of the
+// negative points
+func sumPoints(positive, negative []*bulletproofs.Point) *bulletproofs.Point {
+	points := []*bulletproofs.Point{infinity()}
+	points = append(points, positive...)
+	for _, p := range negative {
+		points = append(points, negPoint(p))
+	}
+
+	return bulletproofs.SumPoints(points...)
+}
+
+// commitValue returns v*H, the commitment to v without blinding factor
+func commitValue(v uint64) *bulletproofs.Point {
+	return bulletproofs.ScalarMulPoint(&secp256k1zkp.H, new(big.Int).SetUint64(v))
+}
+
+// commitBlind returns blind*G, the commitment to zero with the blinding
+// factor blind
+func commitBlind(blind []byte) *bulletproofs.Point {
+	return bulletproofs.ScalarMulPoint(&secp256k1zkp.G, new(big.Int).SetBytes(blind))
+}
+
+// commitmentPoint decodes a serialized commitment
+func commitmentPoint(commit secp256k1zkp.Commitment) (*bulletproofs.Point, error) {
+	p := new(bulletproofs.Point)
+	if err := p.Read(bytes.NewReader(commit)); err != nil {
+		return nil, fmt.Errorf("invalid commitment %v: %v", commit, err)
+	}
+
+	return p, nil
+}
+
+// CommitPoint decodes the input commitment
+func (input *Input) CommitPoint() (*bulletproofs.Point, error) {
+	return commitmentPoint(input.Commit)
+}
+
+// verifyKernelSums checks that the outputs minus the inputs, plus the value
+// overage leaving them (fees, or minus the reward of a block), equal the
+// kernel excesses plus offset*G: no value is created or destroyed, only
+// blinding factors known to the kernel signers remain. It returns the sum of
+// the kernel excesses.
+func verifyKernelSums(inputs InputList, outputs OutputList, kernels TxKernelList, overage int64, offset []byte) (*bulletproofs.Point, error) {
+	var positive, negative []*bulletproofs.Point
+
+	for _, output := range outputs {
+		positive = append(positive, output.Commit)
+	}
+
+	for i := range inputs {
+		p, err := inputs[i].CommitPoint()
+		if err != nil {
+			return nil, err
+		}
+		negative = append(negative, p)
+	}
+
+	if overage > 0 {
+		positive = append(positive, commitValue(uint64(overage)))
+	} else if overage < 0 {
+		negative = append(negative, commitValue(uint64(-overage)))
+	}
+
+	utxoSum := sumPoints(positive, negative)
+
+	var excesses []*bulletproofs.Point
+	for i := range kernels {
+		excesses = append(excesses, &kernels[i].Excess)
+	}
+	kernelSum := sumPoints(excesses, nil)
+
+	if !utxoSum.Equals(sumPoints([]*bulletproofs.Point{kernelSum, commitBlind(offset)}, nil)) {
+		return nil, errors.New("kernel sums don't match the commitment sums")
+	}
+
+	return kernelSum, nil
+}
+
+// verifyKernelSignatures checks the signature of every kernel
+func verifyKernelSignatures(kernels TxKernelList) error {
+	for i := range kernels {
+		if err := kernels[i].Validate(); err != nil {
+			return fmt.Errorf("kernel %x: %v", kernels[i].Hash(), err)
+		}
+	}
+
+	return nil
+}
+
+// verifyRangeProofs checks that all output values are within the correct range
+func verifyRangeProofs(outputs OutputList) error {
+	// TODO(yoss22): Batch verify these.
+	prover := bulletproofs.NewProver(64)
+	for _, output := range outputs {
+		if !prover.Verify(output.Commit, output.RangeProof) {
+			return fmt.Errorf("proof verification failed for %v %v",
+				output.Commit, output.RangeProof)
+		}
+	}
+	return nil
+}
@@ -1,5 +1,16 @@
 package blockchain2
 
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"sort"
+)
+
 // Transaction an grin transaction
 type Transaction struct {
 	// The "k2" kernel offset.
@@ -10,4 +21,236 @@ type Transaction struct {
 	Outputs OutputList
 	// The kernels for this transaction
 	Kernels TxKernelList
-}
\ No newline at end of file
+}
+
+// Bytes implements p2p Message interface
+// 与区块体相同的格式：偏移量，inputs/outputs/kernels的数目，再按序写入它们
+func (tx *Transaction) Bytes() []byte {
+	buff := new(bytes.Buffer)
+	if _, err := buff.Write(tx.KernelOffset[:]); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := binary.Write(buff, binary.BigEndian, uint64(len(tx.Inputs))); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := binary.Write(buff, binary.BigEndian, uint64(len(tx.Outputs))); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := binary.Write(buff, binary.BigEndian, uint64(len(tx.Kernels))); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// 与区块一样按哈希排好序
+	sort.Sort(tx.Inputs)
+	sort.Sort(tx.Outputs)
+	sort.Sort(tx.Kernels)
+
+	for _, input := range tx.Inputs {
+		if _, err := buff.Write(input.Bytes()); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	for _, output := range tx.Outputs {
+		if _, err := buff.Write(output.Bytes()); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	for _, kernel := range tx.Kernels {
+		if _, err := buff.Write(kernel.Bytes()); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	return buff.Bytes()
+}
+
+// Type implements p2p Message interface
+func (tx *Transaction) Type() uint8 {
+	return MsgTypeTransaction
+}
+
+// Read implements p2p Message interface
+// 读取时拒绝过大或未排序的交易
+func (tx *Transaction) Read(r io.Reader) error {
+	if _, err := io.ReadFull(r, tx.KernelOffset[:]); err != nil {
+		return err
+	}
+
+	var inputs, outputs, kernels uint64
+	if err := binary.Read(r, binary.BigEndian, &inputs); err != nil {
+		return err
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &outputs); err != nil {
+		return err
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &kernels); err != nil {
+		return err
+	}
+
+	// 交易必须能与coinbase输出和交易核一起放进一个区块
+	if !fitsBlockWeight(inputs, outputs+1, kernels+1) {
+		return errors.New("transaction exceeds the maximum weight")
+	}
+
+	tx.Inputs = make([]Input, inputs)
+	for i := uint64(0); i < inputs; i++ {
+		if err := tx.Inputs[i].Read(r); err != nil {
+			return err
+		}
+	}
+
+	tx.Outputs = make([]Output, outputs)
+	for i := uint64(0); i < outputs; i++ {
+		if err := tx.Outputs[i].Read(r); err != nil {
+			return err
+		}
+	}
+
+	tx.Kernels = make([]TxKernel, kernels)
+	for i := uint64(0); i < kernels; i++ {
+		if err := tx.Kernels[i].Read(r); err != nil {
+			return err
+		}
+	}
+
+	return tx.verifySorted()
+}
+
+// String implements String() interface
+func (tx Transaction) String() string {
+	return fmt.Sprintf("%#v", tx)
+}
+
+// Fee returns the total fee of the transaction kernels
+func (tx *Transaction) Fee() (uint64, error) {
+	var fee uint64
+	for _, kernel := range tx.Kernels {
+		if kernel.Fee > math.MaxInt64-fee {
+			return 0, errors.New("transaction fee overflow")
+		}
+		fee += kernel.Fee
+	}
+
+	return fee, nil
+}
+
+// Validate returns nil if the transaction successfully passed consensus rules:
+// it only spends and creates plain outputs, every output value is in range,
+// every kernel is signed and the commitments balance, i.e.
+// sum(outputs) - sum(inputs) + fee*H = sum(kernel excesses) + offset*G
+func (tx *Transaction) Validate() error {
+	logrus.Info("transaction validate")
+
+	if len(tx.Kernels) == 0 {
+		return errors.New("transaction without kernel")
+	}
+
+	if err := tx.verifyWeight(); err != nil {
+		return err
+	}
+
+	if err := tx.verifySorted(); err != nil {
+		return err
+	}
+
+	if err := tx.verifyFeatures(); err != nil {
+		return err
+	}
+
+	if err := tx.verifyCutThrough(); err != nil {
+		return err
+	}
+
+	fee, err := tx.Fee()
+	if err != nil {
+		return err
+	}
+
+	if err := verifyRangeProofs(tx.Outputs); err != nil {
+		return err
+	}
+
+	if err := verifyKernelSignatures(tx.Kernels); err != nil {
+		return err
+	}
+
+	_, err = verifyKernelSums(tx.Inputs, tx.Outputs, tx.Kernels, int64(fee), tx.KernelOffset[:])
+	return err
+}
+
+// Weight returns the weight of the transaction counted against MaxBlockWeight
+func (tx *Transaction) Weight() uint64 {
+	return BlockWeight(uint64(len(tx.Inputs)), uint64(len(tx.Outputs)), uint64(len(tx.Kernels)))
+}
+
+// WeightAsBlock returns the weight of a block with the transaction alone,
+// including the coinbase output and kernel
+func (tx *Transaction) WeightAsBlock() uint64 {
+	return tx.Weight() + BlockWeight(0, 1, 1)
+}
+
+// 交易必须能与coinbase输出和交易核一起放进一个区块
+func (tx *Transaction) verifyWeight() error {
+	if weight := tx.WeightAsBlock(); weight > uint64(MaxBlockWeight) {
+		return fmt.Errorf("transaction weight %d exceeds the maximum %d", weight, MaxBlockWeight)
+	}
+
+	return nil
+}
+
+// 检查inputs, outputs, kernels是否已按哈希严格递增排序（排好序且没有重复）
+func (tx *Transaction) verifySorted() error {
+	if !sortedAndUnique(tx.Inputs) {
+		return errors.New("transaction inputs are not sorted or contain duplicates")
+	}
+
+	if !sortedAndUnique(tx.Outputs) {
+		return errors.New("transaction outputs are not sorted or contain duplicates")
+	}
+
+	if !sortedAndUnique(tx.Kernels) {
+		return errors.New("transaction kernels are not sorted or contain duplicates")
+	}
+
+	return nil
+}
+
+// 交易不能产生coinbase输出或交易核，只有区块可以
+func (tx *Transaction) verifyFeatures() error {
+	for _, output := range tx.Outputs {
+		if output.Features&CoinbaseOutput == CoinbaseOutput {
+			return errors.New("transaction with coinbase output")
+		}
+	}
+
+	for _, kernel := range tx.Kernels {
+		if kernel.Features&CoinbaseKernel == CoinbaseKernel {
+			return errors.New("transaction with coinbase kernel")
+		}
+	}
+
+	return nil
+}
+
+// 交易不能花费自己的输出，这样的输入输出应当直接抵消(cut-through)
+func (tx *Transaction) verifyCutThrough() error {
+	outputs := make(map[string]bool)
+	for _, output := range tx.Outputs {
+		outputs[string(output.Commit.Bytes())] = true
+	}
+
+	for _, input := range tx.Inputs {
+		if outputs[string(input.Commit)] {
+			return fmt.Errorf("transaction spends its own output %v", input.Commit)
+		}
+	}
+
+	return nil
+}
@@ -4,15 +4,11 @@ import (
 	"fmt"
 	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
 	"github.com/azd1997/golang-MimbleWimble-try/utils"
-	"github.com/azd1997/golang-MimbleWimble-try/wallet"
-	"log"
 )
 
 /*创建区块链，其创世区块coinbase交易地址给定*/
 func (cli *CommandLine) createBlockChain(address, nodeID string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not valid")
-	}
+	cli.validateAddress(address)
 
 	chain := blockchain.InitBlockChain(address, nodeID)
 	//defer chain.Db.Close()
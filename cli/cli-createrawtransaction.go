@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
+	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
+	"log"
+)
+
+/*构造一笔未签名交易并以hex输出，from可以是只读地址*/
+func (cli *CommandLine) createRawTransaction(from, to, nodeID string, amount int) {
+	cli.validateAddress(to)
+
+	cli.validateAddress(from)
+
+	wallets, err := wallet.CreateWallets(nodeID)
+	utils.Handle(err)
+
+	//取转账者公钥，只导入了地址的只读地址没有公钥
+	var pubKey []byte
+	if watchOnly, err := wallets.GetWatchOnly(from); err == nil {
+		pubKey = watchOnly.PublicKey
+	} else if w, err := wallets.GetWallet(from); err == nil {
+		pubKey = w.WPublicKey
+	} else {
+		log.Panic("Address is not in wallet")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	UTXOSet := blockchain.UTXOSet{chain}
+	defer chain.Db.Close()
+
+	tx := blockchain.NewUnsignedTransaction(pubKey, wallet.AddressToPubKeyHash(from), from, to, amount, &UTXOSet)
+
+	fmt.Printf("%x\n", tx.Serialize())
+}
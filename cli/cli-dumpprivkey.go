@@ -0,0 +1,23 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
+	"log"
+)
+
+/*导出地址对应的私钥*/
+func (cli *CommandLine) dumpPrivKey(address, nodeID string) {
+	cli.validateAddress(address)
+
+	wallets, err := wallet.CreateWallets(nodeID)
+	utils.Handle(err)
+
+	w, err := wallets.GetWallet(address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(w.ExportPrivateKey())
+}
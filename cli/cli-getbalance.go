@@ -3,17 +3,13 @@ package cli
 import (
 	"fmt"
 	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
-	"github.com/azd1997/golang-MimbleWimble-try/utils"
 	"github.com/azd1997/golang-MimbleWimble-try/wallet"
-	"log"
 )
 
 /*获取账户余额*/
 func (cli *CommandLine) getBalance(address, nodeID string) {
 
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not valid")
-	}
+	cli.validateAddress(address)
 
 	chain := blockchain.ContinueBlockChain(nodeID)
 
@@ -21,8 +17,7 @@ func (cli *CommandLine) getBalance(address, nodeID string) {
 	defer chain.Db.Close()
 
 	balance := 0
-	pubKeyHash := utils.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+	pubKeyHash := wallet.AddressToPubKeyHash(address)
 
 	UTXOs := UTXOSet.FindUnspentTransactions(pubKeyHash)
 
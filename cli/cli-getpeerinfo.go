@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/network"
+	"time"
+)
+
+/*打印运行中的节点所连接的节点的状态与统计*/
+//节点每隔几秒将信息快照写入文件，这里读取最近一次的快照
+func (cli *CommandLine) getPeerInfo(nodeID string) {
+	snapshot, err := network.LoadPeerInfo(nodeID)
+	if err != nil {
+		fmt.Printf("No peer info for node %s, is the node running? (%v)\n", nodeID, err)
+		return
+	}
+
+	fmt.Printf("%d peers, updated %s\n", len(snapshot.Peers), snapshot.Updated.Format("2006-01-02 15:04:05"))
+
+	for _, info := range snapshot.Peers {
+		direction := "outbound"
+		if info.Inbound {
+			direction = "inbound"
+		}
+		pingTime := "-"
+		if info.PingTime > 0 {
+			pingTime = info.PingTime.Round(time.Millisecond).String()
+		}
+
+		fmt.Printf("\n%s (%s)\n", info.Addr, direction)
+		fmt.Printf("  Agent:       %s, version %d\n", info.UserAgent, info.Version)
+		if info.NodeKey != nil {
+			fmt.Printf("  Encrypted:   key %x\n", info.NodeKey)
+		}
+		fmt.Printf("  Height:      %d (total difficulty %d)\n", info.BestHeight, info.TotalDifficulty)
+		fmt.Printf("  Ping:        %s", pingTime)
+		if info.PingWait > 0 {
+			fmt.Printf(" (waiting %s)", info.PingWait.Round(time.Millisecond))
+		}
+		fmt.Println()
+		fmt.Printf("  Connected:   %s\n", info.ConnectedAt.Format("2006-01-02 15:04:05"))
+		fmt.Printf("  Last recv:   %s\n", info.LastRecv.Format("2006-01-02 15:04:05"))
+		fmt.Printf("  Bytes:       %d sent, %d received\n", info.BytesSent, info.BytesRecv)
+		fmt.Printf("  Ban score:   %d\n", info.BanScore)
+	}
+}
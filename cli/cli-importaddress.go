@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
+	"log"
+)
+
+/*导入只读地址*/
+func (cli *CommandLine) importAddress(address, label, nodeID string, rescan bool) {
+	wallets, _ := wallet.CreateWallets(nodeID)
+
+	address, err := wallets.ImportAddress(address)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveFile(nodeID)
+
+	fmt.Printf("Watching address: %s\n", address)
+	cli.importedAddress(address, label, nodeID, rescan)
+}
+
+/*导入公钥（hex）作为只读地址*/
+func (cli *CommandLine) importPubKey(pubKeyHex, label, nodeID string, rescan bool) {
+	pubKey, err := hex.DecodeString(pubKeyHex)
+	if err != nil {
+		log.Panic("Public key is not valid hex")
+	}
+
+	wallets, _ := wallet.CreateWallets(nodeID)
+
+	address, err := wallets.ImportPubKey(pubKey)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveFile(nodeID)
+
+	fmt.Printf("Watching address: %s\n", address)
+	cli.importedAddress(address, label, nodeID, rescan)
+}
+
+/*为新导入的地址设置标签，并重新扫描以补上该地址的余额和历史记录*/
+func (cli *CommandLine) importedAddress(address, label, nodeID string, rescan bool) {
+	ledger := wallet.CreateLedger(nodeID)
+	ledger.SetLabel(address, label)
+	ledger.SaveFile(nodeID)
+
+	if !rescan || !blockchain.ChainExists(nodeID) {
+		return
+	}
+
+	//新地址可能在任意高度收到过款项，需从创世区块重新扫描
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Db.Close()
+	ledger = chain.RescanWallet(nodeID, 0, false, printRescanProgress)
+
+	fmt.Printf("Rescan done. Balance of %s: %d\n", address, ledger.Balance(address))
+}
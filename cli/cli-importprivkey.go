@@ -0,0 +1,22 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
+	"log"
+)
+
+/*导入私钥，并重新扫描UTXO集*/
+func (cli *CommandLine) importPrivKey(privKey, label, nodeID string, rescan bool) {
+	w, err := wallet.ImportPrivateKey(privKey)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets, _ := wallet.CreateWallets(nodeID)
+	address := wallets.ImportWallet(w)
+	wallets.SaveFile(nodeID)
+
+	fmt.Printf("Imported address: %s\n", address)
+	cli.importedAddress(address, label, nodeID, rescan)
+}
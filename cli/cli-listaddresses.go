@@ -14,4 +14,8 @@ func (cli *CommandLine) listAddresses(nodeID string) {
 		fmt.Println(address)
 	}
 
+	for _, address := range wallets.GetWatchOnlyAddresses() {
+		fmt.Printf("%s (watch-only)\n", address)
+	}
+
 }
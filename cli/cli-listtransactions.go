@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
+	"time"
+)
+
+/*列出钱包的交易记录（对账单）*/
+//address为空则列出钱包所有地址的记录；count>0时只列出最近count条
+func (cli *CommandLine) listTransactions(address, nodeID string, count int) {
+	if address != "" {
+		cli.validateAddress(address)
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Db.Close()
+
+	//先把账本同步到当前链尾
+	ledger := chain.SyncWalletLedger(nodeID)
+	if ledger == nil {
+		fmt.Println("No wallet found, create one!")
+		return
+	}
+	bestHeight := chain.GetBestHeight()
+
+	records := ledger.GetRecords(address)
+
+	fmt.Printf("%-8s %-19s %-9s %-34s %8s %8s %6s  %-64s %s\n",
+		"Height", "Time", "Category", "Address", "Amount", "Balance", "Conf", "TxID", "Label")
+
+	//余额按时间顺序累计，截取最近count条时也保持正确
+	balance := 0
+	for i, record := range records {
+		amount := record.Amount
+		if record.Category == wallet.CategorySend {
+			amount = -amount
+		}
+		balance += amount
+
+		if count > 0 && i < len(records)-count {
+			continue
+		}
+
+		fmt.Printf("%-8d %-19s %-9s %-34s %8d %8d %6d  %-64x %s\n",
+			record.Height,
+			time.Unix(record.Timestamp, 0).Format("2006-01-02 15:04:05"),
+			record.Category,
+			record.Address,
+			amount,
+			balance,
+			bestHeight-record.Height+1,
+			record.TxID,
+			ledger.Label(record))
+	}
+}
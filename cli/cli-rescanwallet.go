@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
+	"log"
+)
+
+/*从指定高度重新扫描区块链，重建钱包的未花费输出和交易记录*/
+func (cli *CommandLine) rescanWallet(from int, resume bool, nodeID string) {
+	if from < 0 {
+		log.Panic("Height is not valid")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Db.Close()
+
+	ledger := chain.RescanWallet(nodeID, from, resume, printRescanProgress)
+	if ledger == nil {
+		log.Panic("Wallet file does not exist")
+	}
+
+	fmt.Printf("Rescan done at height %d. Wallet balance: %d\n", ledger.TipHeight, ledger.Balance(""))
+}
+
+/*打印重新扫描的进度，每100个区块及最后一个区块打印一次*/
+func printRescanProgress(height, tipHeight int) {
+	if height%100 == 0 || height == tipHeight {
+		fmt.Printf("Rescanned block %d of %d\n", height, tipHeight)
+	}
+}
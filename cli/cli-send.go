@@ -12,13 +12,9 @@ import (
 /*转账*/
 func (cli *CommandLine) send(from, to, nodeID string, amount int, mineNow bool) {
 
-	if !wallet.ValidateAddress(to) {
-		log.Panic("Address is not valid")
-	}
+	cli.validateAddress(to)
 
-	if !wallet.ValidateAddress(from) {
-		log.Panic("Address is not valid")
-	}
+	cli.validateAddress(from)
 
 	chain := blockchain.ContinueBlockChain(nodeID)
 	UTXOSet := blockchain.UTXOSet{chain}
@@ -27,7 +23,13 @@ func (cli *CommandLine) send(from, to, nodeID string, amount int, mineNow bool)
 	//从钱包文件读取内容，创建钱包对象
 	wallets, err := wallet.CreateWallets(nodeID)
 	utils.Handle(err)
-	fromWallet := wallets.GetWallet(from)
+	if wallets.IsWatchOnly(from) {
+		log.Panic("Address is watch-only, use createrawtransaction instead")
+	}
+	fromWallet, err := wallets.GetWallet(from)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	//创建新交易
 	tx := blockchain.NewTransaction(&fromWallet, to, amount, &UTXOSet)
@@ -40,7 +42,17 @@ func (cli *CommandLine) send(from, to, nodeID string, amount int, mineNow bool)
 		UTXOSet.Update(block)
 	} else { // mineNow == false
 		//向本地节点发送，用以调试
-		network.SendTx(network.KnownNodes[0], tx)
+		//命令行工具只发起连接，不启动节点
+		node := network.NewNode(network.Config{NodeID: nodeID}, chain, nil)
+		peer, err := node.Connect(network.DefaultSeedNodes[0])
+		if err != nil {
+			log.Panic(err)
+		}
+		//交易经Dandelion++的stem阶段转发，不直接广播，以隐藏交易来源
+		network.SendStemTx(peer, tx)
+		//关闭连接前会先写出发送队列中的交易
+		peer.Close()
+		peer.Wait()
 		fmt.Println("Send tx")
 	}
 
@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
+	"runtime"
+)
+
+/*为钱包地址或交易设置标签，标签为空表示删除*/
+func (cli *CommandLine) setLabel(address, txID, label, nodeID string) {
+	if address != "" {
+		cli.validateAddress(address)
+	}
+
+	ledger := wallet.CreateLedger(nodeID)
+
+	if address != "" {
+		ledger.SetLabel(address, label)
+	}
+	if txID != "" {
+		if err := ledger.SetTxLabel(txID, label); err != nil {
+			fmt.Printf("Invalid txid %s: %v\n", txID, err)
+			runtime.Goexit()
+		}
+	}
+
+	ledger.SaveFile(nodeID)
+
+	fmt.Println("Success!")
+}
@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
+	"log"
+)
+
+/*用地址对应的私钥对消息签名，证明对该地址的所有权*/
+func (cli *CommandLine) signMessage(address, message, nodeID string) {
+	cli.validateAddress(address)
+
+	wallets, err := wallet.CreateWallets(nodeID)
+	utils.Handle(err)
+
+	w, err := wallets.GetWallet(address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	signature, err := w.SignMessage(message)
+	utils.Handle(err)
+
+	fmt.Println(signature)
+}
+
+/*验证消息签名是否由地址的持有者所签*/
+func (cli *CommandLine) verifyMessage(address, signature, message string) {
+	cli.validateAddress(address)
+
+	if err := wallet.VerifyMessage(address, signature, message); err != nil {
+		fmt.Printf("Signature is not valid: %v\n", err)
+		return
+	}
+
+	fmt.Println("Signature is valid")
+}
@@ -1,21 +1,78 @@
 package cli
 
 import (
+	"context"
 	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
 	"github.com/azd1997/golang-MimbleWimble-try/network"
+	"github.com/azd1997/golang-MimbleWimble-try/utils"
 	"github.com/azd1997/golang-MimbleWimble-try/wallet"
+	DEATH "github.com/vrecan/death"
 	"log"
+	"os"
+	SYS "syscall"
+	"time"
 )
 
-func (cli *CommandLine) StartNode(nodeID, minerAddress string) {
+const stopTimeout = 10 * time.Second //收到中断信号后等待节点关闭连接的时间
+
+/*启动节点，直到收到中断信号后停止节点并关闭数据库*/
+//listenAddr为空时监听localhost:nodeID；加密连接使用与钱包文件放在一起的节点身份密钥
+func (cli *CommandLine) StartNode(nodeID, minerAddress, listenAddr, advertiseAddr string, encryption network.EncryptionMode) {
 	fmt.Printf("Starting Node %s\n", nodeID)
 
 	if len(minerAddress) > 0 {
-		if wallet.ValidateAddress(minerAddress) {
+		if err := wallet.ValidateAddress(minerAddress); err == nil {
 			fmt.Println("Mining is on. Address to receive reward: ", minerAddress)
 		} else {
-			log.Panic("Wrong miner address!")
+			log.Panicf("Wrong miner address: %v", err)
 		}
 	}
-	network.StartServer(nodeID, minerAddress)
+
+	if listenAddr == "" {
+		listenAddr = fmt.Sprintf("localhost:%s", nodeID)
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Db.Close()
+
+	//没有钱包文件时不维护账本
+	wallets, err := wallet.CreateWallets(nodeID)
+	if err != nil {
+		wallets = nil
+	}
+
+	cfg := network.Config{
+		NodeID:        nodeID,
+		ListenAddr:    listenAddr,
+		AdvertiseAddr: advertiseAddr,
+		MinerAddress:  minerAddress,
+		SeedNodes:     network.DefaultSeedNodes,
+		Encryption:    encryption,
+	}
+	if encryption != network.EncryptionOff {
+		cfg.NodeKey, err = wallet.LoadNodeKey(nodeID)
+		utils.Handle(err)
+	}
+	node := network.NewNode(cfg, chain, wallets)
+
+	if err := node.Start(context.Background()); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Listening on %s, advertising %s\n", listenAddr, node.Addr())
+	if key := node.PublicKey(); key != nil {
+		fmt.Printf("Node key: %x\n", key)
+	}
+
+	//SIGINT标识中断信号；SIGTERM标识进程终结
+	//收到信号后先停止节点，再由defer关闭数据库
+	d := DEATH.NewDeath(SYS.SIGINT, SYS.SIGTERM, os.Interrupt)
+	d.WaitForDeathWithFunc(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+		defer cancel()
+
+		if err := node.Stop(ctx); err != nil {
+			fmt.Printf("Stopping node: %v\n", err)
+		}
+	})
 }
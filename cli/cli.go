@@ -3,7 +3,9 @@ package cli
 import (
 	"flag"
 	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/network"
 	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
 	"os"
 	"runtime"
 )
@@ -22,7 +24,19 @@ func (cli *CommandLine) printUsage() {
 	fmt.Println(" createwallet - Create a new Wallet")
 	fmt.Println(" listaddresses - Lists the addresses in wallet file")
 	fmt.Println(" reindexutxo - Rebuild the UTXO set")
-	fmt.Println(" startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var -miner enables mining")
+	fmt.Println(" listtransactions -address ADDRESS -count N - List wallet transactions (all addresses if ADDRESS is empty, last N if N > 0)")
+	fmt.Println(" setlabel -address ADDRESS -txid TXID -label LABEL - Label an address or a transaction, empty LABEL removes it")
+	fmt.Println(" importaddress -address ADDRESS -label LABEL -rescan - Watch ADDRESS without holding its private key")
+	fmt.Println(" importpubkey -pubkey PUBKEY -label LABEL -rescan - Watch the address of PUBKEY, the hex encoded 32-byte X and Y coordinates")
+	fmt.Println(" rescanwallet -from HEIGHT -resume - Rebuild wallet outputs and history from block HEIGHT, or resume an interrupted rescan")
+	fmt.Println(" dumpprivkey -address ADDRESS - Print the private key of ADDRESS")
+	fmt.Println(" importprivkey -privkey PRIVKEY -label LABEL -rescan - Import a private key printed by dumpprivkey")
+	fmt.Println(" signmessage -address ADDRESS -message MESSAGE - Sign MESSAGE with the private key of ADDRESS")
+	fmt.Println(" verifymessage -address ADDRESS -signature SIGNATURE -message MESSAGE - Verify that MESSAGE was signed by ADDRESS")
+	fmt.Println(" createrawtransaction -from FROM -to TO -amount AMOUNT - Print an unsigned transaction, FROM may be watch-only")
+	fmt.Println(" getpeerinfo - Print the peers of the running node with ID specified in NODE_ID env. var")
+	fmt.Println(" startnode -miner ADDRESS -listen ADDR -advertise ADDR -encrypt MODE - Start a node with ID specified in NODE_ID env. var -miner enables mining, MODE is off, prefer or require")
+	fmt.Println("Set NETWORK env. var to testnet for testnet addresses")
 
 }
 
@@ -35,6 +49,14 @@ func (cli *CommandLine) validateArgs() {
 	}
 }
 
+/*检查地址是否合法，不合法时打印原因并退出*/
+func (cli *CommandLine) validateAddress(address string) {
+	if err := wallet.ValidateAddress(address); err != nil {
+		fmt.Printf("Invalid address %s: %v\n", address, err)
+		runtime.Goexit()
+	}
+}
+
 /*运行命令行程序*/
 func (cli *CommandLine) Run() {
 	cli.validateArgs()
@@ -45,6 +67,12 @@ func (cli *CommandLine) Run() {
 		runtime.Goexit()
 	}
 
+	//NETWORK环境变量选择地址所属的网络（mainnet/testnet），默认主网
+	if err := wallet.SetNetwork(os.Getenv("NETWORK")); err != nil {
+		fmt.Println(err)
+		runtime.Goexit()
+	}
+
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
@@ -53,6 +81,17 @@ func (cli *CommandLine) Run() {
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	listTransactionsCmd := flag.NewFlagSet("listtransactions", flag.ExitOnError)
+	setLabelCmd := flag.NewFlagSet("setlabel", flag.ExitOnError)
+	importAddressCmd := flag.NewFlagSet("importaddress", flag.ExitOnError)
+	importPubKeyCmd := flag.NewFlagSet("importpubkey", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createrawtransaction", flag.ExitOnError)
+	rescanWalletCmd := flag.NewFlagSet("rescanwallet", flag.ExitOnError)
+	dumpPrivKeyCmd := flag.NewFlagSet("dumpprivkey", flag.ExitOnError)
+	signMessageCmd := flag.NewFlagSet("signmessage", flag.ExitOnError)
+	verifyMessageCmd := flag.NewFlagSet("verifymessage", flag.ExitOnError)
+	importPrivKeyCmd := flag.NewFlagSet("importprivkey", flag.ExitOnError)
+	getPeerInfoCmd := flag.NewFlagSet("getpeerinfo", flag.ExitOnError)
 
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for.")
@@ -62,6 +101,34 @@ func (cli *CommandLine) Run() {
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and sending reward to ADDRESS")
+	startNodeListen := startNodeCmd.String("listen", "", "Address to listen on, localhost:NODE_ID by default")
+	startNodeAdvertise := startNodeCmd.String("advertise", "", "Address announced to other nodes, the listen address by default")
+	startNodeEncrypt := startNodeCmd.String("encrypt", "prefer", "Peer connection encryption: off, prefer or require")
+	listTransactionsAddress := listTransactionsCmd.String("address", "", "Only list transactions of ADDRESS")
+	listTransactionsCount := listTransactionsCmd.Int("count", 0, "Only list the last N transactions")
+	setLabelAddress := setLabelCmd.String("address", "", "The address to label")
+	setLabelTxID := setLabelCmd.String("txid", "", "The transaction to label")
+	setLabelLabel := setLabelCmd.String("label", "", "The label, empty to remove")
+	importAddressAddress := importAddressCmd.String("address", "", "The address to watch")
+	importAddressLabel := importAddressCmd.String("label", "", "The label of the address")
+	importAddressRescan := importAddressCmd.Bool("rescan", true, "Rescan the chain for the address")
+	importPubKeyPubKey := importPubKeyCmd.String("pubkey", "", "The hex encoded public key to watch, X and Y coordinates of 32 bytes each")
+	importPubKeyLabel := importPubKeyCmd.String("label", "", "The label of the address")
+	importPubKeyRescan := importPubKeyCmd.Bool("rescan", true, "Rescan the chain for the address")
+	createRawTxFrom := createRawTxCmd.String("from", "", "Source wallet address")
+	createRawTxTo := createRawTxCmd.String("to", "", "Destination wallet address")
+	createRawTxAmount := createRawTxCmd.Int("amount", 0, "Amount to send")
+	rescanWalletFrom := rescanWalletCmd.Int("from", 0, "The block height to rescan from")
+	rescanWalletResume := rescanWalletCmd.Bool("resume", false, "Resume an interrupted rescan")
+	dumpPrivKeyAddress := dumpPrivKeyCmd.String("address", "", "The address to dump the private key of")
+	importPrivKeyPrivKey := importPrivKeyCmd.String("privkey", "", "The private key printed by dumpprivkey")
+	importPrivKeyLabel := importPrivKeyCmd.String("label", "", "The label of the address")
+	importPrivKeyRescan := importPrivKeyCmd.Bool("rescan", true, "Rescan the chain for the address")
+	signMessageAddress := signMessageCmd.String("address", "", "The address to sign with")
+	signMessageMessage := signMessageCmd.String("message", "", "The message to sign")
+	verifyMessageAddress := verifyMessageCmd.String("address", "", "The address that signed the message")
+	verifyMessageSignature := verifyMessageCmd.String("signature", "", "The signature printed by signmessage")
+	verifyMessageMessage := verifyMessageCmd.String("message", "", "The message that was signed")
 
 
 	switch os.Args[1] {
@@ -89,6 +156,39 @@ func (cli *CommandLine) Run() {
 	case "startnode":
 		err := startNodeCmd.Parse(os.Args[2:])
 		utils.Handle(err)
+	case "listtransactions":
+		err := listTransactionsCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "setlabel":
+		err := setLabelCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "importaddress":
+		err := importAddressCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "importpubkey":
+		err := importPubKeyCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "createrawtransaction":
+		err := createRawTxCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "rescanwallet":
+		err := rescanWalletCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "signmessage":
+		err := signMessageCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "verifymessage":
+		err := verifyMessageCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "dumpprivkey":
+		err := dumpPrivKeyCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "importprivkey":
+		err := importPrivKeyCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "getpeerinfo":
+		err := getPeerInfoCmd.Parse(os.Args[2:])
+		utils.Handle(err)
 	default:
 		cli.printUsage()
 		runtime.Goexit()
@@ -140,7 +240,89 @@ func (cli *CommandLine) Run() {
 			startNodeCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.StartNode(nodeID, *startNodeMiner)
+		encryption, err := network.ParseEncryptionMode(*startNodeEncrypt)
+		if err != nil {
+			fmt.Println(err)
+			startNodeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.StartNode(nodeID, *startNodeMiner, *startNodeListen, *startNodeAdvertise, encryption)
+	}
+
+	if listTransactionsCmd.Parsed() {
+		cli.listTransactions(*listTransactionsAddress, nodeID, *listTransactionsCount)
+	}
+
+	if setLabelCmd.Parsed() {
+		if *setLabelAddress == "" && *setLabelTxID == "" {
+			setLabelCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.setLabel(*setLabelAddress, *setLabelTxID, *setLabelLabel, nodeID)
+	}
+
+	if importAddressCmd.Parsed() {
+		if *importAddressAddress == "" {
+			importAddressCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.importAddress(*importAddressAddress, *importAddressLabel, nodeID, *importAddressRescan)
+	}
+
+	if importPubKeyCmd.Parsed() {
+		if *importPubKeyPubKey == "" {
+			importPubKeyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.importPubKey(*importPubKeyPubKey, *importPubKeyLabel, nodeID, *importPubKeyRescan)
+	}
+
+	if createRawTxCmd.Parsed() {
+		if *createRawTxFrom == "" || *createRawTxTo == "" || *createRawTxAmount <= 0 {
+			createRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.createRawTransaction(*createRawTxFrom, *createRawTxTo, nodeID, *createRawTxAmount)
+	}
+
+	if rescanWalletCmd.Parsed() {
+		cli.rescanWallet(*rescanWalletFrom, *rescanWalletResume, nodeID)
+	}
+
+	if signMessageCmd.Parsed() {
+		if *signMessageAddress == "" {
+			signMessageCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.signMessage(*signMessageAddress, *signMessageMessage, nodeID)
+	}
+
+	if verifyMessageCmd.Parsed() {
+		if *verifyMessageAddress == "" || *verifyMessageSignature == "" {
+			verifyMessageCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.verifyMessage(*verifyMessageAddress, *verifyMessageSignature, *verifyMessageMessage)
+	}
+
+	if dumpPrivKeyCmd.Parsed() {
+		if *dumpPrivKeyAddress == "" {
+			dumpPrivKeyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.dumpPrivKey(*dumpPrivKeyAddress, nodeID)
+	}
+
+	if importPrivKeyCmd.Parsed() {
+		if *importPrivKeyPrivKey == "" {
+			importPrivKeyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.importPrivKey(*importPrivKeyPrivKey, *importPrivKeyLabel, nodeID, *importPrivKeyRescan)
+	}
+
+	if getPeerInfoCmd.Parsed() {
+		cli.getPeerInfo(nodeID)
 	}
 
 }
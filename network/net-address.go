@@ -1,42 +1,65 @@
 package network
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
-	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
 )
 
-//维护本地存储的网络节点集合
-type Addr struct {
-	AddrList []string
+//请求对方地址簿中具有某些功能的节点地址
+type GetPeerAddrs struct {
+	Capabilities blockchain2.Capabilities
 }
 
-/*处理请求节点信息地址*/
-//刚上线的节点A向某一节点B请求周遭所有已知节点信息，
-// 随后B返回他指知道的节点，A调用这个方法更新本地维护的已知节点集合
-//随后向已知节点请求区块信息
-func HandleAddr(request []byte) {
-	//获取request内容
-	var buff bytes.Buffer
-	var payload Addr
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	utils.Handle(err)
-
-	//更新已知节点集和，并向已知节点集合的节点请求区块信息
-	KnownNodes = append(KnownNodes, payload.AddrList...)
-	fmt.Printf("there are %d known nodes\n", len(KnownNodes))
-	RequestBlocks()
+//对GetPeerAddrs的应答，地址数不超过blockchain2.MaxPeerAddrs
+type PeerAddrs struct {
+	Addrs []string
 }
 
-func SendAddr(address string) {
-	nodes := Addr{KnownNodes}
-	nodes.AddrList = append(nodes.AddrList, nodeAddress)
-	payload := GobEncode(nodes)
-	request := append(CmdToBytes("addr"), payload...)
+/*处理地址请求，从地址簿中挑选健康的节点返回给对方*/
+func HandleGetPeerAddrs(p *Peer, payload []byte) error {
+	var getPeerAddrs GetPeerAddrs
+	if err := GobDecode(payload, &getPeerAddrs); err != nil {
+		return err
+	}
 
-	SendData(address, request)
+	var addrs []string
+	if m := p.node.peerManager; m != nil {
+		addrs = m.PeerAddrs(getPeerAddrs.Capabilities, blockchain2.MaxPeerAddrs)
+	}
+
+	SendPeerAddrs(p, addrs)
+
+	return nil
+}
+
+/*处理对方发来的节点地址，加入地址簿*/
+//刚上线的节点A向某一节点B请求其知道的节点，随后A从地址簿中挑选节点建立连接
+func HandlePeerAddrs(p *Peer, payload []byte) error {
+	var peerAddrs PeerAddrs
+	if err := GobDecode(payload, &peerAddrs); err != nil {
+		return err
+	}
+
+	if len(peerAddrs.Addrs) > blockchain2.MaxPeerAddrs {
+		return fmt.Errorf("too many peer addresses: %d", len(peerAddrs.Addrs))
+	}
+
+	fmt.Printf("Received %d peer addresses from %s\n", len(peerAddrs.Addrs), p)
+	if m := p.node.peerManager; m != nil {
+		m.AddAddrs(peerAddrs.Addrs)
+	}
+
+	return nil
+}
+
+func SendGetPeerAddrs(p *Peer, capabilities blockchain2.Capabilities) {
+	p.Send(blockchain2.MsgTypeGetPeerAddrs, GobEncode(GetPeerAddrs{capabilities}))
+}
+
+func SendPeerAddrs(p *Peer, addrs []string) {
+	if len(addrs) > blockchain2.MaxPeerAddrs {
+		addrs = addrs[:blockchain2.MaxPeerAddrs]
+	}
+
+	p.Send(blockchain2.MsgTypePeerAddrs, GobEncode(PeerAddrs{addrs}))
 }
@@ -0,0 +1,29 @@
+package network
+
+import (
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+)
+
+//封禁对方时告知其原因
+type BanReason struct {
+	Reason blockchain2.ReasonForBan
+}
+
+/*告知对方被封禁的原因*/
+func SendBanReason(p *Peer, reason blockchain2.ReasonForBan) {
+	p.Send(blockchain2.MsgTypeBanReason, GobEncode(BanReason{reason}))
+}
+
+/*对方封禁了本节点，断开连接*/
+func HandleBanReason(p *Peer, payload []byte) error {
+	var banReason BanReason
+	if err := GobDecode(payload, &banReason); err != nil {
+		return err
+	}
+
+	fmt.Printf("Banned by %s, reason %d\n", p, banReason.Reason)
+	p.Close()
+
+	return nil
+}
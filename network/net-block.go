@@ -1,57 +1,143 @@
 package network
 
 import (
-	"bytes"
-	"encoding/gob"
+	"errors"
 	"fmt"
 	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
-	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+	"time"
 )
 
 type Block struct {
-	AddrFrom string
-	Block    []byte
+	Block []byte
+}
+
+//请求一个区块体
+type GetBlock struct {
+	Hash []byte
 }
 
 //处理接收到区块时
-func HandleBlock(request []byte, chain *blockchain.BlockChain) {
-	//获取request内容
-	var buff bytes.Buffer
-	var payload Block
+//同步中请求的区块先与已验证的区块头比对，再按高度顺序连接到链上
+func HandleBlock(p *Peer, payload []byte) error {
+	//获取消息内容
+	var msg Block
+	if err := GobDecode(payload, &msg); err != nil {
+		return err
+	}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	utils.Handle(err)
+	//区块数据来自对方，解码失败不能让本节点崩溃
+	block := new(blockchain.Block)
+	if err := GobDecode(msg.Block, block); err != nil {
+		return misbehaving(p, banThreshold, blockchain2.ReasonBadBlock, err)
+	}
+	//至少有coinbase交易，否则无法计算默克尔根
+	if len(block.Transactions) == 0 {
+		return misbehaving(p, banThreshold, blockchain2.ReasonBadBlock, errors.New("block without transactions"))
+	}
 
-	//将接收到的区块添加到区块链中
-	blockData := payload.Block
-	block := blockchain.Deserialize(blockData)
+	n := p.node
+	p.addKnown(block.Hash)
+	key := string(block.Hash)
+	delete(n.blockRequests, key)
+	delete(n.partialBlocks, key)
 
-	fmt.Println("Received a new block!")
-	chain.AddBlock(block)
+	fmt.Printf("Received block %x from %s\n", block.Hash, p)
 
-	fmt.Printf("Added block %x\n", block.Hash)
+	header := n.pendingHeader(block.Hash)
+	if header == nil {
+		h := block.Header()
+		if err := h.Validate(); err != nil {
+			return misbehaving(p, banThreshold, blockchain2.ReasonBadBlock, err)
+		}
+		n.processBlock(p, block)
 
-	//若blockInTransit中还有内容，那么据此继续向对方请求区块数据
-	//这表示只要blockInTransit非空，就会不断请求，对方不断返回区块，自己不断处理区块
-	if len(blockInTransit) > 0 {
-		blockHash := blockInTransit[0]
-		SendGetData(payload.AddrFrom, "block", blockHash)
+		return nil
+	}
 
-		blockInTransit = blockInTransit[1:]
-	} else {
-		//更新未花费输出集
-		UTXOSet := blockchain.UTXOSet{chain}
-		UTXOSet.Reindex()
+	if err := block.ValidateBody(header); err != nil {
+		return misbehaving(p, banThreshold, blockchain2.ReasonBadBlock, err)
 	}
+
+	n.receivedBlocks[key] = block
+	n.connectReceivedBlocks()
+	n.scheduleDownloads()
+
+	return nil
 }
 
-/*向某地址发送区块*/
-func SendBlock(addr string, b *blockchain.Block) {
-	data := Block{nodeAddress, b.Serialize()}
-	payload := GobEncode(data)
-	request := append(CmdToBytes("block"), payload...)
+/*处理不是同步中请求的、已验证工作量证明的区块*/
+//前一区块已在链上则直接连接，否则放入孤块池并向对方请求缺失的区块
+func (n *Node) processBlock(p *Peer, block *blockchain.Block) {
+	if n.chain.HasBlock(block.Hash) {
+		return
+	}
+
+	if !n.chain.HasBlock(block.PrevHash) {
+		n.handleOrphanBlock(p, block)
+		return
+	}
+	n.connectBlock(block)
+}
+
+/*处理前一区块不在本地的区块*/
+//离链尾不远的放入孤块池，并向对方请求最早缺失的区块；相差太远则改为同步区块头，避免孤块池被占满
+func (n *Node) handleOrphanBlock(p *Peer, block *blockchain.Block) {
+	if block.Height > n.chain.GetBestHeight()+maxOrphanBlocks {
+		SendGetHeaders(p)
+		return
+	}
+
+	if _, ok := n.orphanBlocks[string(block.Hash)]; ok {
+		return
+	}
+	n.addOrphanBlock(block, p)
+
+	//缺失的区块已在请求中则不重复请求，请求超时后由下一个到达的孤块重新触发
+	root := n.orphanRoot(block.Hash)
+	if _, ok := n.blockRequests[string(root)]; !ok && n.pendingHeader(root) == nil {
+		SendGetBlock(p, root)
+		n.blockRequests[string(root)] = &blockRequest{p, time.Now()}
+	}
+}
+
+/*在待同步区块头中查找区块头*/
+func (n *Node) pendingHeader(hash []byte) *blockchain.BlockHeader {
+	for i := range n.pendingHeaders {
+		if string(n.pendingHeaders[i].Hash) == string(hash) {
+			return &n.pendingHeaders[i]
+		}
+	}
+
+	return nil
+}
+
+/*向某节点发送区块*/
+func SendBlock(p *Peer, b *blockchain.Block) {
+	p.addKnown(b.Hash)
+	payload := GobEncode(Block{b.Serialize()})
+
+	p.Send(blockchain2.MsgTypeBlock, payload)
+}
+
+/*处理区块请求，本节点没有该区块时忽略*/
+func HandleGetBlock(p *Peer, payload []byte) error {
+	var getBlock GetBlock
+	if err := GobDecode(payload, &getBlock); err != nil {
+		return err
+	}
+
+	block, err := p.chain.GetBlock(getBlock.Hash)
+	if err != nil {
+		return nil
+	}
+
+	SendBlock(p, &block)
+
+	return nil
+}
 
-	SendData(addr, request)
+/*向某节点请求区块*/
+func SendGetBlock(p *Peer, hash []byte) {
+	p.Send(blockchain2.MsgTypeGetBlock, GobEncode(GetBlock{hash}))
 }
@@ -0,0 +1,309 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+	"time"
+)
+
+//致密区块：区块头加上交易的短ID，接收方用本地内存池中的交易还原区块，只需补齐缺失的交易
+//短ID由交易ID经以区块哈希为密钥的siphash得到（blockchain2.Hash.ShortID），每个区块的密钥不同，难以构造碰撞
+//Coinbase交易不可能在对方内存池中，直接附带在消息中
+
+var partialBlockTimeout = 30 * time.Second //等待缺失交易的最长时间，超时后放弃，之后由区块头同步补齐。测试中调小
+
+//致密区块消息
+type CompactBlock struct {
+	Header    blockchain.BlockHeader
+	Prefilled []PrefilledTx         //直接附带的交易，按下标升序
+	ShortIDs  []blockchain2.ShortID //其余交易的短ID，按区块中的顺序依次填入Prefilled未占用的位置
+}
+
+//直接附带在致密区块中的交易
+type PrefilledTx struct {
+	Index int
+	Tx    []byte
+}
+
+//请求致密区块
+type GetCompactBlock struct {
+	Hash []byte
+}
+
+//向发来致密区块的节点请求本地没有的交易
+type GetBlockTxs struct {
+	Hash    []byte
+	Indexes []int
+}
+
+//对GetBlockTxs的应答，交易顺序与请求的下标一致
+type BlockTxs struct {
+	Hash []byte
+	Txs  [][]byte
+}
+
+//正在还原的区块，等待缺失的交易到达
+type partialBlock struct {
+	header  blockchain.BlockHeader
+	txs     []*blockchain.Transaction
+	missing []int //缺失交易在区块中的下标
+	peer    *Peer
+	added   time.Time
+}
+
+//方法列表
+//1.func NewCompactBlock(b *blockchain.Block) CompactBlock
+//2.func SendGetCompactBlock(p *Peer, hash []byte)
+//3.func HandleGetCompactBlock(p *Peer, payload []byte) error
+//4.func SendCompactBlock(p *Peer, b *blockchain.Block)
+//5.func HandleCompactBlock(p *Peer, payload []byte) error
+//6.func HandleGetBlockTxs(p *Peer, payload []byte) error
+//7.func HandleBlockTxs(p *Peer, payload []byte) error
+//8.func (n *Node) completePartialBlock(pb *partialBlock)
+//9.func (n *Node) expirePartialBlocks()
+
+/*由区块构造致密区块，Coinbase交易直接附带，其余交易以短ID表示*/
+func NewCompactBlock(b *blockchain.Block) CompactBlock {
+	cb := CompactBlock{Header: b.Header()}
+
+	for i, tx := range b.Transactions {
+		if tx.IsCoinbase() {
+			cb.Prefilled = append(cb.Prefilled, PrefilledTx{i, tx.Serialize()})
+			continue
+		}
+		cb.ShortIDs = append(cb.ShortIDs, blockchain2.Hash(tx.ID).ShortID(blockchain2.Hash(b.Hash)))
+	}
+
+	return cb
+}
+
+/*向对方请求致密区块*/
+func SendGetCompactBlock(p *Peer, hash []byte) {
+	p.Send(blockchain2.MsgTypeGetCompactBlock, GobEncode(GetCompactBlock{hash}))
+}
+
+/*处理致密区块请求，本节点没有该区块时忽略*/
+func HandleGetCompactBlock(p *Peer, payload []byte) error {
+	var getCompactBlock GetCompactBlock
+	if err := GobDecode(payload, &getCompactBlock); err != nil {
+		return err
+	}
+
+	block, err := p.chain.GetBlock(getCompactBlock.Hash)
+	if err != nil {
+		return nil
+	}
+
+	SendCompactBlock(p, &block)
+
+	return nil
+}
+
+/*向某节点发送致密区块*/
+func SendCompactBlock(p *Peer, b *blockchain.Block) {
+	p.addKnown(b.Hash)
+	payload := GobEncode(NewCompactBlock(b))
+
+	p.Send(blockchain2.MsgTypeCompactBlock, payload)
+}
+
+//处理接收到致密区块时
+//先验证区块头的工作量证明，再用内存池、孤立交易池和stem池中的交易按短ID还原区块，缺失的交易向对方请求
+func HandleCompactBlock(p *Peer, payload []byte) error {
+	var cb CompactBlock
+	if err := GobDecode(payload, &cb); err != nil {
+		return err
+	}
+
+	n := p.node
+	header := cb.Header
+	p.addKnown(header.Hash)
+
+	fmt.Printf("Received compact block %x from %s\n", header.Hash, p)
+
+	key := string(header.Hash)
+	if n.chain.HasBlock(header.Hash) || n.partialBlocks[key] != nil || n.orphanBlocks[key] != nil {
+		return nil
+	}
+
+	if err := header.Validate(); err != nil {
+		return misbehaving(p, banThreshold, blockchain2.ReasonBadCompactBlock, err)
+	}
+
+	//前一区块未知说明本节点落后不止一个区块，改为同步区块头
+	if !n.chain.HasBlock(header.PrevHash) {
+		SendGetHeaders(p)
+		return nil
+	}
+
+	//区块至少含有Coinbase交易，它总是作为第一笔交易附带
+	total := len(cb.Prefilled) + len(cb.ShortIDs)
+	if total == 0 || len(cb.Prefilled) == 0 || cb.Prefilled[0].Index != 0 {
+		return misbehaving(p, banThreshold, blockchain2.ReasonBadCompactBlock,
+			errors.New("compact block does not start with a prefilled coinbase"))
+	}
+
+	//放置附带的交易，下标须严格递增且不超出区块的交易数
+	txs := make([]*blockchain.Transaction, total)
+	last := -1
+	for _, prefilled := range cb.Prefilled {
+		if prefilled.Index <= last || prefilled.Index >= total {
+			return misbehaving(p, banThreshold, blockchain2.ReasonBadCompactBlock,
+				fmt.Errorf("prefilled transaction index %d out of order", prefilled.Index))
+		}
+		var tx blockchain.Transaction
+		if err := GobDecode(prefilled.Tx, &tx); err != nil {
+			return misbehaving(p, banThreshold, blockchain2.ReasonBadCompactBlock, err)
+		}
+		txs[prefilled.Index] = &tx
+		last = prefilled.Index
+	}
+	if !txs[0].IsCoinbase() {
+		return misbehaving(p, banThreshold, blockchain2.ReasonBadCompactBlock,
+			errors.New("first transaction of compact block is not coinbase"))
+	}
+
+	//本地交易按短ID建立索引，短ID相同的交易无法区分，当作缺失
+	candidates := make(map[string]*blockchain.Transaction)
+	ambiguous := make(map[string]bool)
+	addCandidate := func(tx blockchain.Transaction) {
+		id := string(blockchain2.Hash(tx.ID).ShortID(blockchain2.Hash(header.Hash)))
+		if _, ok := candidates[id]; ok {
+			ambiguous[id] = true
+		}
+		candidates[id] = &tx
+	}
+	for _, tx := range n.memoryPool {
+		addCandidate(tx)
+	}
+	for _, o := range n.orphanTxs {
+		addCandidate(o.tx)
+	}
+	for _, s := range n.stemPool {
+		addCandidate(s.tx)
+	}
+
+	pb := &partialBlock{header: header, txs: txs, peer: p, added: time.Now()}
+	next := 0
+	for i := range txs {
+		if txs[i] != nil {
+			continue
+		}
+		id := string(cb.ShortIDs[next])
+		next++
+		if tx, ok := candidates[id]; ok && !ambiguous[id] {
+			txs[i] = tx
+			continue
+		}
+		pb.missing = append(pb.missing, i)
+	}
+
+	if len(pb.missing) == 0 {
+		n.completePartialBlock(pb)
+		return nil
+	}
+
+	n.partialBlocks[key] = pb
+	p.Send(MsgTypeGetBlockTxs, GobEncode(GetBlockTxs{header.Hash, pb.missing}))
+
+	return nil
+}
+
+/*处理缺失交易的请求，返回区块中对应下标的交易*/
+func HandleGetBlockTxs(p *Peer, payload []byte) error {
+	var getBlockTxs GetBlockTxs
+	if err := GobDecode(payload, &getBlockTxs); err != nil {
+		return err
+	}
+
+	block, err := p.chain.GetBlock(getBlockTxs.Hash)
+	if err != nil {
+		return nil
+	}
+
+	txs := make([][]byte, 0, len(getBlockTxs.Indexes))
+	for _, i := range getBlockTxs.Indexes {
+		if i < 0 || i >= len(block.Transactions) {
+			return fmt.Errorf("requested transaction index %d out of range", i)
+		}
+		txs = append(txs, block.Transactions[i].Serialize())
+	}
+
+	p.Send(MsgTypeBlockTxs, GobEncode(BlockTxs{getBlockTxs.Hash, txs}))
+
+	return nil
+}
+
+/*处理对方发来的缺失交易，补齐后还原区块*/
+func HandleBlockTxs(p *Peer, payload []byte) error {
+	var blockTxs BlockTxs
+	if err := GobDecode(payload, &blockTxs); err != nil {
+		return err
+	}
+
+	n := p.node
+	key := string(blockTxs.Hash)
+	pb, ok := n.partialBlocks[key]
+	if !ok || pb.peer != p {
+		return nil
+	}
+	delete(n.partialBlocks, key)
+
+	if len(blockTxs.Txs) != len(pb.missing) {
+		return misbehaving(p, banThreshold, blockchain2.ReasonBadCompactBlock,
+			fmt.Errorf("got %d transactions, requested %d", len(blockTxs.Txs), len(pb.missing)))
+	}
+
+	for j, i := range pb.missing {
+		var tx blockchain.Transaction
+		if err := GobDecode(blockTxs.Txs[j], &tx); err != nil {
+			return misbehaving(p, banThreshold, blockchain2.ReasonBadCompactBlock, err)
+		}
+		pb.txs[i] = &tx
+	}
+	pb.missing = nil
+
+	n.completePartialBlock(pb)
+
+	return nil
+}
+
+/*由补齐交易的致密区块组成区块，与区块头比对后按收到区块的方式处理*/
+//短ID碰撞可能使还原出的交易与区块不符，此时改为向对方请求完整区块
+func (n *Node) completePartialBlock(pb *partialBlock) {
+	header := pb.header
+	block := &blockchain.Block{
+		Timestamp:    header.Timestamp,
+		Height:       header.Height,
+		Hash:         header.Hash,
+		Transactions: pb.txs,
+		PrevHash:     header.PrevHash,
+		Nonce:        header.Nonce,
+	}
+
+	if err := block.ValidateBody(&header); err != nil {
+		fmt.Printf("Failed to reconstruct compact block %x: %v\n", header.Hash, err)
+		if _, ok := n.blockRequests[string(header.Hash)]; !ok {
+			SendGetBlock(pb.peer, header.Hash)
+			n.blockRequests[string(header.Hash)] = &blockRequest{pb.peer, time.Now()}
+		}
+		return
+	}
+
+	fmt.Printf("Reconstructed compact block %x from %s\n", block.Hash, pb.peer)
+
+	n.processBlock(pb.peer, block)
+}
+
+/*放弃等待超时的致密区块，区块之后由区块头同步或下一个区块的存证补齐*/
+func (n *Node) expirePartialBlocks() {
+	now := time.Now()
+
+	for key, pb := range n.partialBlocks {
+		if now.Sub(pb.added) > partialBlockTimeout {
+			delete(n.partialBlocks, key)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package network
+
+import (
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+	"math/rand"
+	"time"
+)
+
+//Dandelion++交易传播：新交易先沿一条随机路径逐跳转发（stem阶段），之后才向全网广播（fluff阶段），
+//使观察者难以根据最先收到交易的节点推断交易的来源
+//每个纪元内，节点以StemProbability的概率作为中继节点，否则作为扩散节点；
+//中继节点从出站连接中选出至多两个stem中继，每个入站节点（以及本节点自己）固定使用其中一个；扩散节点收到stem交易后直接广播
+//stem阶段的交易放在单独的stem池中，不响应getdata，也不会被打包，以免泄露；
+//每笔stem交易有一个禁运计时器，超时仍未见到它被广播（例如下一跳丢弃了它），本节点自己广播
+
+const (
+	defaultDandelionEpoch   = 10 * time.Minute
+	defaultDandelionEmbargo = 180 * time.Second
+	defaultStemProbability  = 90
+
+	maxStemRelays = 2    //每个纪元选出的stem中继个数
+	maxStemTxs    = 1000 //stem池容量，池满时新交易直接广播
+)
+
+//Dandelion++参数，零值表示使用默认值
+type DandelionConfig struct {
+	Epoch           time.Duration //重新选择中继和节点角色的间隔
+	Embargo         time.Duration //stem交易的最短禁运时间，实际为1~1.5倍之间随机
+	StemProbability int           //每个纪元作为中继节点的概率（百分比），不在1~100之间时使用默认值
+}
+
+//stem阶段的交易
+type stemTx struct {
+	tx      blockchain.Transaction
+	embargo time.Time //超过此时间仍在stem池中则由本节点广播
+}
+
+//当前纪元的Dandelion++状态，只在持有handlerMu时访问
+type dandelionState struct {
+	epochEnd time.Time
+	fluff    bool            //本纪元是否为扩散节点
+	relays   []*Peer         //stem中继
+	routes   map[*Peer]*Peer //交易来源节点 -> stem中继，本节点产生的交易以nil为键
+}
+
+//方法列表
+//1.func (c *DandelionConfig) setDefaults()
+//2.func (n *Node) stemRelay(from *Peer) *Peer
+//3.func (n *Node) stemTx(tx blockchain.Transaction, from *Peer) bool
+//4.func (n *Node) fluffExpiredStems()
+//5.func (n *Node) SubmitTx(tx *blockchain.Transaction)
+//6.func SendStemTx(p *Peer, tx *blockchain.Transaction)
+//7.func HandleStemTx(p *Peer, payload []byte) error
+
+/*未设置的参数使用默认值*/
+func (c *DandelionConfig) setDefaults() {
+	if c.Epoch <= 0 {
+		c.Epoch = defaultDandelionEpoch
+	}
+	if c.Embargo <= 0 {
+		c.Embargo = defaultDandelionEmbargo
+	}
+	if c.StemProbability <= 0 || c.StemProbability > 100 {
+		c.StemProbability = defaultStemProbability
+	}
+}
+
+/*返回来自from的交易在stem阶段的下一跳，没有可用的出站连接时返回nil*/
+//纪元结束时重新决定节点角色并清空中继；中继断开时从其余出站连接中补充
+func (n *Node) stemRelay(from *Peer) *Peer {
+	d := &n.dandelion
+	if now := time.Now(); now.After(d.epochEnd) {
+		d.epochEnd = now.Add(n.Dandelion.Epoch)
+		d.fluff = rand.Intn(100) >= n.Dandelion.StemProbability
+		d.relays = nil
+		d.routes = make(map[*Peer]*Peer)
+	}
+
+	if relay := d.routes[from]; relay != nil && !relay.closed() {
+		return relay
+	}
+
+	relays := d.relays[:0]
+	for _, relay := range d.relays {
+		if !relay.closed() {
+			relays = append(relays, relay)
+		}
+	}
+	d.relays = relays
+
+	if len(d.relays) < maxStemRelays {
+		var candidates []*Peer
+		for _, peer := range n.connectedPeers() {
+			isRelay := false
+			for _, relay := range d.relays {
+				isRelay = isRelay || relay == peer
+			}
+			if !peer.Inbound && !isRelay {
+				candidates = append(candidates, peer)
+			}
+		}
+		rand.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+		for _, peer := range candidates {
+			if len(d.relays) >= maxStemRelays {
+				break
+			}
+			d.relays = append(d.relays, peer)
+		}
+	}
+
+	if len(d.relays) == 0 {
+		return nil
+	}
+	relay := d.relays[rand.Intn(len(d.relays))]
+	d.routes[from] = relay
+
+	return relay
+}
+
+/*将已验证的交易放入stem池并转发给下一跳，返回false表示交易应当直接广播*/
+//扩散节点、stem池已满或没有可用中继时直接广播；本节点产生的交易总是先经过stem阶段
+func (n *Node) stemTx(tx blockchain.Transaction, from *Peer) bool {
+	if len(n.stemPool) >= maxStemTxs {
+		return false
+	}
+
+	relay := n.stemRelay(from)
+	if relay == nil || relay == from || (from != nil && n.dandelion.fluff) {
+		return false
+	}
+
+	embargo := n.Dandelion.Embargo + time.Duration(rand.Int63n(int64(n.Dandelion.Embargo)/2+1))
+	n.stemPool[string(tx.ID)] = &stemTx{tx, time.Now().Add(embargo)}
+	SendStemTx(relay, &tx)
+
+	return true
+}
+
+/*广播禁运超时的stem交易，已上链或输入已被花费的交易被丢弃*/
+func (n *Node) fluffExpiredStems() {
+	now := time.Now()
+
+	for key, s := range n.stemPool {
+		if now.Before(s.embargo) {
+			continue
+		}
+		delete(n.stemPool, key)
+
+		if !n.chain.VerifyTransaction(&s.tx) {
+			continue
+		}
+		fmt.Printf("Embargo expired for transaction %x, fluffing\n", s.tx.ID)
+		n.acceptTx(s.tx, nil)
+	}
+}
+
+/*提交本节点产生的交易，交易先经过stem阶段再广播*/
+func (n *Node) SubmitTx(tx *blockchain.Transaction) {
+	n.handlerMu.Lock()
+	defer n.handlerMu.Unlock()
+
+	if !n.stemTx(*tx, nil) {
+		n.acceptTx(*tx, nil)
+	}
+}
+
+/*向stem中继发送交易*/
+func SendStemTx(p *Peer, tx *blockchain.Transaction) {
+	payload := GobEncode(Tx{tx.Serialize()})
+
+	p.Send(blockchain2.MsgTypeStemTransaction, payload)
+}
+
+//处理收到的stem交易
+//验证通过后，中继节点将其放入stem池并转发给下一跳，扩散节点则像普通交易一样放入内存池并广播
+func HandleStemTx(p *Peer, payload []byte) error {
+	var msg Tx
+	if err := GobDecode(payload, &msg); err != nil {
+		return err
+	}
+
+	n := p.node
+	var tx blockchain.Transaction
+	if err := GobDecode(msg.Transaction, &tx); err != nil {
+		return misbehaving(p, banThreshold, blockchain2.ReasonBadMessage, err)
+	}
+	if !tx.VerifyID() {
+		return misbehaving(p, banThreshold, blockchain2.ReasonBadMessage, fmt.Errorf("transaction id %x does not match its content", tx.ID))
+	}
+
+	if _, ok := n.stemPool[string(tx.ID)]; ok || n.inMemoryPool(tx.ID) || n.hasOrphanTx(tx.ID) {
+		return nil
+	}
+
+	//来源交易不在链上的交易无法在stem阶段验证，按普通交易处理
+	if tx.IsCoinbase() || len(p.chain.MissingInputs(&tx)) > 0 {
+		return HandleTx(p, payload)
+	}
+
+	if !p.chain.VerifyTransaction(&tx) {
+		fmt.Printf("Rejected invalid stem transaction %x from %s\n", tx.ID, p)
+		return nil
+	}
+
+	if n.stemTx(tx, p) {
+		return nil
+	}
+
+	//对方的交易只在stem池中，广播时不排除对方
+	n.acceptTx(tx, nil)
+	if len(n.memoryPool) >= 2 && len(n.MinerAddress) > 0 {
+		n.mineTx()
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+	"time"
+)
+
+const (
+	userAgent          = "golang-MimbleWimble-try/0.1"
+	minProtocolVersion = uint32(1)        //能够接受的最低协议版本
+	handshakeTimeout   = 10 * time.Second //主动连接时等待对方Shake的时间
+)
+
+// 本节点支持的功能
+var localCapabilities blockchain2.Capabilities = blockchain2.CapFullNode
+
+// 主动连接方发送的握手消息
+// 魔数在每个消息帧的头部检查，魔数不同的网络的节点在读取第一帧时即被断开
+type Hand struct {
+	Version      uint32
+	Capabilities blockchain2.Capabilities
+	Nonce        uint64 //随机数，收到自己发出的随机数说明连接到了自己
+	BestHeight   int
+	UserAgent    string
+	AddrFrom     string //发送方的监听地址
+}
+
+// 被连接方对Hand的应答，收到后握手完成
+type Shake struct {
+	Version      uint32
+	Capabilities blockchain2.Capabilities
+	BestHeight   int
+	UserAgent    string
+}
+
+// 断开连接前告知对方原因
+type ErrorMsg struct {
+	Code    int
+	Message string
+}
+
+var errSelfConnect = errors.New("connected to self")
+
+//方法列表
+//1.func (p *Peer) SendHand()
+//2.func (p *Peer) SendShake()
+//3.func (p *Peer) SendError(code int, message string)
+//4.func (p *Peer) handleHandshake(msgType uint8, payload []byte) error
+//5.func HandleError(p *Peer, payload []byte) error
+
+func newNonce() uint64 {
+	var b [8]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return uint64(time.Now().UnixNano())
+	}
+
+	return binary.BigEndian.Uint64(b[:])
+}
+
+/*向对方发送Hand，开始握手*/
+func (p *Peer) SendHand() {
+	nonce := newNonce()
+
+	n := p.node
+	n.sentNoncesMu.Lock()
+	n.sentNonces[nonce] = true
+	n.sentNoncesMu.Unlock()
+
+	hand := Hand{
+		Version:      blockchain2.ProtocolVersion,
+		Capabilities: localCapabilities,
+		Nonce:        nonce,
+		BestHeight:   p.chain.GetBestHeight(),
+		UserAgent:    userAgent,
+		AddrFrom:     n.AdvertiseAddr,
+	}
+
+	p.Send(blockchain2.MsgTypeHand, GobEncode(hand))
+}
+
+/*应答对方的Hand*/
+func (p *Peer) SendShake() {
+	shake := Shake{
+		Version:      p.Version,
+		Capabilities: localCapabilities,
+		BestHeight:   p.chain.GetBestHeight(),
+		UserAgent:    userAgent,
+	}
+
+	p.Send(blockchain2.MsgTypeShake, GobEncode(shake))
+}
+
+/*告知对方断开连接的原因*/
+func (p *Peer) SendError(code int, message string) {
+	p.Send(blockchain2.MsgTypeError, GobEncode(ErrorMsg{code, message}))
+}
+
+/*协商协议版本，对方版本过低时通知对方并返回错误*/
+func (p *Peer) negotiateVersion(version uint32) error {
+	if version < minProtocolVersion {
+		p.SendError(blockchain2.NetUnsupportedVersion, fmt.Sprintf("unsupported protocol version %d", version))
+		return fmt.Errorf("unsupported protocol version %d", version)
+	}
+
+	p.Version = blockchain2.ProtocolVersion
+	if version < p.Version {
+		p.Version = version
+	}
+
+	return nil
+}
+
+/*处理握手阶段的消息，握手完成前只接受Hand、Shake和Error*/
+//入站连接等待对方的Hand并回复Shake；出站连接等待对方的Shake
+func (p *Peer) handleHandshake(msgType uint8, payload []byte) error {
+	switch {
+	case msgType == blockchain2.MsgTypeError:
+		return HandleError(p, payload)
+
+	case msgType == blockchain2.MsgTypeHand && p.Inbound:
+		var hand Hand
+		if err := GobDecode(payload, &hand); err != nil {
+			return err
+		}
+
+		n := p.node
+		n.sentNoncesMu.Lock()
+		self := n.sentNonces[hand.Nonce]
+		n.sentNoncesMu.Unlock()
+		if self {
+			return errSelfConnect
+		}
+
+		if err := p.negotiateVersion(hand.Version); err != nil {
+			return err
+		}
+
+		//按连接的对端IP而不是对方声称的地址检查封禁
+		if n.peerManager != nil && n.peerManager.IsBanned(p.remoteAddr()) {
+			SendBanReason(p, blockchain2.ReasonManualBan)
+			return fmt.Errorf("%s is banned", p.remoteAddr())
+		}
+
+		//对方声称的监听地址只记入地址簿，连接仍以实际的对端地址登记
+		p.ListenAddr = hand.AddrFrom
+		p.Capabilities = hand.Capabilities
+		p.UserAgent = hand.UserAgent
+		p.BestHeight = hand.BestHeight
+
+		p.SendShake()
+
+	case msgType == blockchain2.MsgTypeShake && !p.Inbound:
+		var shake Shake
+		if err := GobDecode(payload, &shake); err != nil {
+			return err
+		}
+
+		if err := p.negotiateVersion(shake.Version); err != nil {
+			return err
+		}
+
+		p.Capabilities = shake.Capabilities
+		p.UserAgent = shake.UserAgent
+		p.BestHeight = shake.BestHeight
+
+	default:
+		return fmt.Errorf("unexpected %s message before handshake", MsgTypeName(msgType))
+	}
+
+	return p.setConnected()
+}
+
+/*对方因错误断开连接*/
+func HandleError(p *Peer, payload []byte) error {
+	var msg ErrorMsg
+	if err := GobDecode(payload, &msg); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("peer error %d: %s", msg.Code, msg.Message)
+}
@@ -0,0 +1,125 @@
+package network
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+)
+
+const rawAddr = "raw:3000"
+
+/*不经过Node直接连向节点i，由测试读写消息帧*/
+func dialRaw(t *testing.T, h *simHarness, i int) net.Conn {
+	conn, err := h.net.Transport(rawAddr).Dial(h.nodes[i].Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(convergeTimeout))
+
+	return conn
+}
+
+/*检查连接已被节点关闭，且关闭前没有发来应答*/
+func expectClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	msgType, _, err := ReadMessage(conn)
+	if err == nil {
+		t.Fatalf("got %s message, want the connection closed", MsgTypeName(msgType))
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		t.Fatal("connection was not closed")
+	}
+}
+
+func testHand(version uint32, nonce uint64) []byte {
+	return GobEncode(Hand{
+		Version:      version,
+		Capabilities: localCapabilities,
+		Nonce:        nonce,
+		UserAgent:    userAgent,
+		AddrFrom:     rawAddr,
+	})
+}
+
+//协议版本过低的节点收到原因后被断开
+func TestHandshakeOldVersion(t *testing.T) {
+	h := newSimHarness(t, 1)
+	defer h.close()
+
+	conn := dialRaw(t, h, 0)
+	defer conn.Close()
+	if err := WriteMessage(conn, blockchain2.MsgTypeHand, testHand(minProtocolVersion-1, newNonce())); err != nil {
+		t.Fatal(err)
+	}
+
+	msgType, payload, err := ReadMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg ErrorMsg
+	if msgType != blockchain2.MsgTypeError || GobDecode(payload, &msg) != nil || msg.Code != blockchain2.NetUnsupportedVersion {
+		t.Fatalf("got %s message %+v, want unsupported version error", MsgTypeName(msgType), msg)
+	}
+	expectClosed(t, conn)
+
+	if h.nodes[0].isConnected(rawAddr) {
+		t.Fatal("peer with an old version connected")
+	}
+}
+
+//魔数不同的网络的节点在第一帧即被断开，不会收到Shake
+func TestHandshakeWrongMagic(t *testing.T) {
+	h := newSimHarness(t, 1)
+	defer h.close()
+
+	var frame bytes.Buffer
+	if err := WriteMessage(&frame, blockchain2.MsgTypeHand, testHand(blockchain2.ProtocolVersion, newNonce())); err != nil {
+		t.Fatal(err)
+	}
+	wrong := frame.Bytes()
+	wrong[0] ^= 0xff
+	if _, _, err := ReadMessage(bytes.NewReader(wrong)); err != errWrongMagic {
+		t.Fatalf("read frame with wrong magic: %v", err)
+	}
+
+	conn := dialRaw(t, h, 0)
+	defer conn.Close()
+	if _, err := conn.Write(wrong); err != nil {
+		t.Fatal(err)
+	}
+	expectClosed(t, conn)
+
+	if h.nodes[0].isConnected(rawAddr) {
+		t.Fatal("peer with a wrong magic code connected")
+	}
+}
+
+//收到本节点自己发出的随机数说明连到了自己，连接被断开
+func TestHandshakeSelfConnect(t *testing.T) {
+	h := newSimHarness(t, 1)
+	defer h.close()
+
+	n := h.nodes[0]
+	nonce := newNonce()
+	n.sentNoncesMu.Lock()
+	n.sentNonces[nonce] = true
+	n.sentNoncesMu.Unlock()
+
+	conn := dialRaw(t, h, 0)
+	defer conn.Close()
+	if err := WriteMessage(conn, blockchain2.MsgTypeHand, testHand(blockchain2.ProtocolVersion, nonce)); err != nil {
+		t.Fatal(err)
+	}
+	expectClosed(t, conn)
+
+	if _, err := n.Connect(n.Addr()); err == nil {
+		t.Fatal("connecting to self succeeded")
+	}
+	if n.isConnected(rawAddr) || n.isConnected(n.Addr()) {
+		t.Fatal("self connection registered")
+	}
+}
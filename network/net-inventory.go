@@ -1,67 +1,181 @@
 package network
 
 import (
-	"bytes"
-	"encoding/gob"
-	"encoding/hex"
 	"fmt"
-	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
-	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"math/rand"
+	"time"
 )
 
+const (
+	invTypeBlock = "block"
+	invTypeTx    = "tx"
+
+	maxKnownInventory = 5000 //每个节点记录的已知存证条数上限，超出后淘汰最早的
+	maxInvItems       = 1000 //一条存证消息中的条目上限
+)
+
+var trickleInterval = 2 * time.Second //批量发送存证的平均间隔，每次在0.5~1.5倍之间随机。测试中调小
+
 type Inv struct {
-	AddrFrom string
-	Type     string
-	Items    [][]byte
+	Type  string
+	Items [][]byte
+}
+
+//记录对方已经知道的区块与交易（对方发来过或本节点发给过对方的），以及等待批量发送的存证
+//存证不立即发送，而是攒到下一次随机间隔的发送时机一起发出，既减少消息数量，也让他人难以根据转发时间推断交易来源
+type inventory struct {
+	known   map[string]struct{}
+	order   []string            //按加入顺序记录已知条目，用于淘汰
+	pending map[string][][]byte //存证类型 -> 等待发送的条目
 }
 
-/*向某节点发送存证*/
-func SendInv(address, kind string, items [][]byte) {
-	inventory := Inv{nodeAddress, kind, items}
-	payload := GobEncode(inventory)
-	request := append(CmdToBytes("inv"), payload...)
+func newInventory() *inventory {
+	return &inventory{
+		known:   make(map[string]struct{}),
+		pending: make(map[string][][]byte),
+	}
+}
+
+//方法列表
+//1.func (p *Peer) addKnown(id []byte)
+//2.func (p *Peer) queueInv(kind string, id []byte)
+//3.func (p *Peer) takeInv() []Inv
+//4.func (n *Node) relayInv(kind string, id []byte, from *Peer)
+//5.func SendInv(p *Peer, kind string, items [][]byte)
+//6.func HandleInv(p *Peer, payload []byte) error
 
-	SendData(address, request)
+/*记录对方已知某一区块或交易，之后不再向对方发送其存证*/
+func (p *Peer) addKnown(id []byte) {
+	p.invMu.Lock()
+	defer p.invMu.Unlock()
+
+	p.addKnownLocked(string(id))
 }
 
-//处理节点接收到来自其他节点的存证，存证有区块存证和交易存证两种
-func HandleInv(request []byte, chain *blockchain.BlockChain) {
-	//获取request中的内容
-	var buff bytes.Buffer
-	var payload Inv
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	utils.Handle(err)
-
-	fmt.Printf("Received inventory with %d %s\n", len(payload.Items), payload.Type)
-
-	if payload.Type == "block" {
-		//收到区块存证，则向对方请求这个区块的数据
-		blockInTransit = payload.Items
-
-		blockHash := payload.Items[0]
-		SendGetData(payload.AddrFrom, "block", blockHash)
-
-		//将blockInTransit中不是payload中那块的区块哈希加入newInTransit
-		//再用newInTransit更新blockInTransit
-		var newInTransit [][]byte
-		for _, b := range blockInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
+func (p *Peer) addKnownLocked(key string) {
+	inv := p.inv
+	if _, ok := inv.known[key]; ok {
+		return
+	}
+
+	if len(inv.order) >= maxKnownInventory {
+		delete(inv.known, inv.order[0])
+		inv.order = inv.order[1:]
+	}
+	inv.known[key] = struct{}{}
+	inv.order = append(inv.order, key)
+}
+
+/*将存证放入待发送队列，对方已知的条目被忽略*/
+func (p *Peer) queueInv(kind string, id []byte) {
+	p.invMu.Lock()
+	defer p.invMu.Unlock()
+
+	key := string(id)
+	if _, ok := p.inv.known[key]; ok {
+		return
+	}
+
+	p.addKnownLocked(key)
+	p.inv.pending[kind] = append(p.inv.pending[kind], id)
+}
+
+/*取出所有待发送的存证，每条消息至多maxInvItems个条目，区块存证排在前面*/
+func (p *Peer) takeInv() []Inv {
+	p.invMu.Lock()
+	defer p.invMu.Unlock()
+
+	var invs []Inv
+	for _, kind := range []string{invTypeBlock, invTypeTx} {
+		items := p.inv.pending[kind]
+		for len(items) > 0 {
+			n := len(items)
+			if n > maxInvItems {
+				n = maxInvItems
 			}
+			invs = append(invs, Inv{kind, items[:n]})
+			items = items[n:]
 		}
-		blockInTransit = newInTransit
+		delete(p.inv.pending, kind)
 	}
 
-	if payload.Type == "tx" {
-		txID := payload.Items[0]
+	return invs
+}
 
+/*下一次批量发送存证前的等待时间*/
+func nextTrickle() time.Duration {
+	return trickleInterval/2 + time.Duration(rand.Int63n(int64(trickleInterval)))
+}
+
+/*向除来源节点外的所有已连接节点转发存证，from为nil表示本节点产生的区块或交易*/
+func (n *Node) relayInv(kind string, id []byte, from *Peer) {
+	for _, peer := range n.connectedPeers() {
+		if peer != from {
+			peer.queueInv(kind, id)
+		}
+	}
+}
+
+/*向某节点立即发送存证*/
+func SendInv(p *Peer, kind string, items [][]byte) {
+	for _, id := range items {
+		p.addKnown(id)
+	}
+
+	payload := GobEncode(Inv{kind, items})
+
+	p.Send(MsgTypeInv, payload)
+}
+
+//处理节点接收到来自其他节点的存证，存证有区块存证和交易存证两种
+func HandleInv(p *Peer, payload []byte) error {
+	//获取消息内容
+	var inv Inv
+	if err := GobDecode(payload, &inv); err != nil {
+		return err
+	}
+
+	fmt.Printf("Received inventory with %d %s\n", len(inv.Items), inv.Type)
+
+	if len(inv.Items) > maxInvItems {
+		return fmt.Errorf("inventory with %d items exceeds limit %d", len(inv.Items), maxInvItems)
+	}
+
+	//对方发来存证，说明对方已有这些区块或交易
+	for _, id := range inv.Items {
+		p.addKnown(id)
+	}
+
+	switch inv.Type {
+	case invTypeBlock:
+		//新出的单个区块向对方请求致密区块，用内存池中的交易还原；多个未知区块说明落后较多，先请求区块头，验证后再下载区块体
+		n := p.node
+		var unknown [][]byte
+		for _, blockHash := range inv.Items {
+			key := string(blockHash)
+			if !p.chain.HasBlock(blockHash) && n.pendingHeader(blockHash) == nil &&
+				n.partialBlocks[key] == nil && n.orphanBlocks[key] == nil && n.blockRequests[key] == nil {
+				unknown = append(unknown, blockHash)
+			}
+		}
+		if len(unknown) == 1 && len(inv.Items) == 1 {
+			SendGetCompactBlock(p, unknown[0])
+		} else if len(unknown) > 0 {
+			SendGetHeaders(p)
+		}
+	case invTypeTx:
 		//如果本地内存池中没有对方发来存证的交易，则向对方请求交易数据
-		if memoryPool[hex.EncodeToString(txID)].ID == nil {
-			SendGetData(payload.AddrFrom, "tx", txID)
+		//stem池中的交易已被他人广播，直接结束其stem阶段
+		for _, txID := range inv.Items {
+			if s, ok := p.node.stemPool[string(txID)]; ok {
+				p.node.acceptTx(s.tx, p)
+				continue
+			}
+			if !p.node.inMemoryPool(txID) && !p.node.hasOrphanTx(txID) {
+				SendGetData(p, invTypeTx, txID)
+			}
 		}
 	}
 
+	return nil
 }
@@ -0,0 +1,156 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+	"io"
+)
+
+//消息帧格式：MagicCode(2字节) + 消息类型(1字节) + 消息体长度(8字节，大端) + 消息体
+//头部长度即blockchain2.HeaderLen，消息体为gob编码的数据，长度不得超过blockchain2.MaxMsgLen，各类型的消息另有各自的上限
+
+//本包特有的消息类型，与blockchain2中定义的p2p消息类型共用一个编号空间，从0x80开始以免冲突
+const (
+	MsgTypeInv uint8 = 0x80 + iota
+	MsgTypeGetData
+	MsgTypeGetBlockTxs
+	MsgTypeBlockTxs
+)
+
+var msgTypeNames = map[uint8]string{
+	blockchain2.MsgTypeError:           "error",
+	blockchain2.MsgTypeHand:            "hand",
+	blockchain2.MsgTypeShake:           "shake",
+	blockchain2.MsgTypePing:            "ping",
+	blockchain2.MsgTypePong:            "pong",
+	blockchain2.MsgTypeBlock:           "block",
+	blockchain2.MsgTypeGetCompactBlock: "getcompactblock",
+	blockchain2.MsgTypeCompactBlock:    "compactblock",
+	MsgTypeGetBlockTxs:                 "getblocktxs",
+	MsgTypeBlockTxs:                    "blocktxs",
+	blockchain2.MsgTypeTransaction:     "tx",
+	blockchain2.MsgTypeStemTransaction: "stemtx",
+	MsgTypeInv:                         "inv",
+	MsgTypeGetData:                     "getdata",
+	blockchain2.MsgTypeGetHeaders:      "getheaders",
+	blockchain2.MsgTypeHeaders:         "headers",
+	blockchain2.MsgTypeGetBlock:        "getblock",
+	blockchain2.MsgTypeGetPeerAddrs:    "getpeeraddrs",
+	blockchain2.MsgTypePeerAddrs:       "peeraddrs",
+	blockchain2.MsgTypeBanReason:       "banreason",
+}
+
+const (
+	smallMsgLen = 1024       //只含几个哈希或整数的请求类消息的长度上限，包括gob的类型描述
+	maxTxLen    = 100 * 1024 //单笔交易消息的长度上限
+
+	minTxLen    = 200                              //区块中一笔交易编码后的最小长度（一个输入一个输出约230字节）
+	maxBlockTxs = blockchain2.MaxMsgLen / minTxLen //区块消息不超过blockchain2.MaxMsgLen，所含交易数因此有上限
+)
+
+//各类型消息体的长度上限，按消息中条目数的上限估算。未列出的类型（区块等）以blockchain2.MaxMsgLen为上限
+//致密区块附带一笔Coinbase交易，其余每笔交易一个短ID；缺失交易的请求每笔一个下标，回复中的交易不会多于区块本身
+//读取消息头后即检查长度，超长的消息不会被读入内存
+var maxPayloadLens = map[uint8]uint64{
+	blockchain2.MsgTypeError:           smallMsgLen,
+	blockchain2.MsgTypeHand:            4 * 1024,
+	blockchain2.MsgTypeShake:           4 * 1024,
+	blockchain2.MsgTypePing:            smallMsgLen,
+	blockchain2.MsgTypePong:            smallMsgLen,
+	blockchain2.MsgTypeGetPeerAddrs:    smallMsgLen,
+	blockchain2.MsgTypePeerAddrs:       smallMsgLen + uint64(blockchain2.MaxPeerAddrs)*64,
+	blockchain2.MsgTypeGetHeaders:      smallMsgLen + uint64(blockchain2.MaxLocators)*64,
+	blockchain2.MsgTypeHeaders:         smallMsgLen + uint64(blockchain2.MaxBlockHeaders)*256,
+	blockchain2.MsgTypeGetBlock:        smallMsgLen,
+	blockchain2.MsgTypeGetCompactBlock: smallMsgLen,
+	blockchain2.MsgTypeCompactBlock:    smallMsgLen + maxTxLen + maxBlockTxs*(blockchain2.ShortIDSize+1),
+	MsgTypeGetBlockTxs:                 smallMsgLen + maxBlockTxs*5,
+	MsgTypeBlockTxs:                    blockchain2.MaxMsgLen,
+	blockchain2.MsgTypeTransaction:     maxTxLen,
+	blockchain2.MsgTypeStemTransaction: maxTxLen,
+	blockchain2.MsgTypeBanReason:       smallMsgLen,
+	MsgTypeInv:                         smallMsgLen + maxInvItems*64,
+	MsgTypeGetData:                     smallMsgLen,
+}
+
+var errWrongMagic = errors.New("wrong magic code in message header")
+
+//消息体超过该类型的长度上限
+type msgTooLongError struct {
+	msgType uint8
+	length  uint64
+}
+
+func (e *msgTooLongError) Error() string {
+	return fmt.Sprintf("%s message too long: %d bytes, limit %d", MsgTypeName(e.msgType), e.length, MaxPayloadLen(e.msgType))
+}
+
+//方法列表
+//1.func MsgTypeName(msgType uint8) string
+//2.func MaxPayloadLen(msgType uint8) uint64
+//3.func WriteMessage(w io.Writer, msgType uint8, payload []byte) error
+//4.func ReadMessage(r io.Reader) (uint8, []byte, error)
+
+/*返回消息类型的名称，用于日志*/
+func MsgTypeName(msgType uint8) string {
+	if name, ok := msgTypeNames[msgType]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%d)", msgType)
+}
+
+/*返回某一类型消息体的长度上限*/
+func MaxPayloadLen(msgType uint8) uint64 {
+	if max, ok := maxPayloadLens[msgType]; ok {
+		return max
+	}
+
+	return blockchain2.MaxMsgLen
+}
+
+/*将消息加上头部组成一帧写出*/
+func WriteMessage(w io.Writer, msgType uint8, payload []byte) error {
+	if uint64(len(payload)) > MaxPayloadLen(msgType) {
+		return &msgTooLongError{msgType, uint64(len(payload))}
+	}
+
+	frame := make([]byte, blockchain2.HeaderLen, blockchain2.HeaderLen+uint64(len(payload)))
+	copy(frame, blockchain2.MagicCode[:])
+	frame[2] = msgType
+	binary.BigEndian.PutUint64(frame[3:], uint64(len(payload)))
+	frame = append(frame, payload...)
+
+	_, err := w.Write(frame)
+
+	return err
+}
+
+/*读取一帧消息，返回消息类型和消息体*/
+//魔数不符或长度超过该类型的上限时返回错误（后者为*msgTooLongError），调用者应断开连接
+func ReadMessage(r io.Reader) (uint8, []byte, error) {
+	header := make([]byte, blockchain2.HeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	if !bytes.Equal(header[:2], blockchain2.MagicCode[:]) {
+		return 0, nil, errWrongMagic
+	}
+
+	msgType := header[2]
+	length := binary.BigEndian.Uint64(header[3:])
+	if length > MaxPayloadLen(msgType) {
+		return 0, nil, &msgTooLongError{msgType, length}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return msgType, payload, nil
+}
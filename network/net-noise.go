@@ -0,0 +1,595 @@
+package network
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+//加密连接：按Noise协议的XX模式握手，双方交换临时密钥和静态身份密钥，握手后用派生出的两个密钥分别加密两个方向的数据
+//协议名Noise_XX_P256_AESGCM_SHA256：椭圆曲线为P256（与钱包相同），对称加密为AES-256-GCM，哈希为SHA256
+//握手消息和加密后的数据块都以2字节长度（大端）开头。明文连接的第一帧以MagicCode开头，被连接方据此区分明文与加密连接
+//  -> e
+//  <- e, ee, s, es
+//  -> s, se
+//发起连接的一方记住每个地址第一次加密握手时对方出示的身份公钥（trust on first use），之后连接该地址时
+//对方必须出示同一公钥，握手失败时也不再退回明文，防止中间人冒充对方或迫使双方降级为明文。
+//节点确实更换了身份密钥时，删除knownKeysFile中的记录即可重新信任
+
+const knownKeysFile = "./tmp/nodekeys_%s.data" //已知节点的身份公钥，按nodeId区分
+
+const (
+	noiseProtocolName = "Noise_XX_P256_AESGCM_SHA256"
+	noisePubKeyLen    = 65    //未压缩格式的公钥长度
+	noiseTagLen       = 16    //AES-GCM认证标签长度
+	noiseMaxMsgLen    = 65535 //一个握手消息或加密数据块的长度上限
+)
+
+//连接的加密方式
+type EncryptionMode int
+
+const (
+	EncryptionOff       EncryptionMode = iota //不加密
+	EncryptionPreferred                       //优先加密，对方不支持时使用明文；曾加密连接过的地址不再使用明文
+	EncryptionRequired                        //只使用加密连接
+)
+
+var encryptionModeNames = map[string]EncryptionMode{
+	"off":     EncryptionOff,
+	"prefer":  EncryptionPreferred,
+	"require": EncryptionRequired,
+}
+
+var (
+	errPlaintextRefused = errors.New("plaintext connection refused, encryption required")
+	errBadNoiseMessage  = errors.New("malformed noise handshake message")
+	errBadPublicKey     = errors.New("invalid public key")
+)
+
+//一个方向的加密状态，nonce为已加密的数据块数
+type cipherState struct {
+	aead cipher.AEAD
+	n    uint64
+}
+
+//握手过程中的对称状态，ck为链式密钥，h为握手记录的哈希
+type symmetricState struct {
+	ck []byte
+	h  []byte
+	cs *cipherState
+}
+
+//加密连接，握手在第一次读写时进行。被连接方收到明文连接时，若允许则之后按明文读写
+type secureConn struct {
+	net.Conn
+	key       *ecdsa.PrivateKey
+	mode      EncryptionMode
+	initiator bool
+
+	handshakeMu   sync.Mutex
+	handshakeDone bool
+	handshakeErr  error
+	plain         bool   //对方使用明文
+	remoteKey     []byte //对方的静态公钥
+	send          *cipherState
+	recv          *cipherState
+
+	readMu  sync.Mutex
+	readBuf []byte //已解密尚未读取的数据；明文连接中为区分连接类型时读到的字节
+	writeMu sync.Mutex
+}
+
+//在另一种连接方式之上建立加密连接
+type secureTransport struct {
+	Transport
+	key  *ecdsa.PrivateKey
+	mode EncryptionMode
+
+	keysFile string            //为空时不保存已知公钥
+	keys     map[string][]byte //地址 -> 第一次加密握手时对方的身份公钥
+	keysMu   sync.Mutex
+}
+
+type secureListener struct {
+	net.Listener
+	t *secureTransport
+}
+
+//方法列表
+//1.func ParseEncryptionMode(s string) (EncryptionMode, error)
+//2.func (t *secureTransport) Listen(addr string) (net.Listener, error)
+//3.func (t *secureTransport) Dial(addr string, timeout time.Duration) (net.Conn, error)
+//4.func (c *secureConn) Handshake() error
+//5.func (c *secureConn) Read(b []byte) (int, error)
+//6.func (c *secureConn) Write(b []byte) (int, error)
+//7.func (c *secureConn) RemoteKey() []byte
+//8.func newSecureTransport(inner Transport, key *ecdsa.PrivateKey, mode EncryptionMode, nodeId string) *secureTransport
+
+/*解析命令行中的加密方式：off、prefer或require*/
+func ParseEncryptionMode(s string) (EncryptionMode, error) {
+	if mode, ok := encryptionModeNames[s]; ok {
+		return mode, nil
+	}
+
+	return EncryptionOff, fmt.Errorf("unknown encryption mode %q, expected off, prefer or require", s)
+}
+
+func (t *secureTransport) Listen(addr string) (net.Listener, error) {
+	ln, err := t.Transport.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &secureListener{ln, t}, nil
+}
+
+func (l *secureListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &secureConn{Conn: conn, key: l.t.key, mode: l.t.mode}, nil
+}
+
+/*建立连接并立即握手。对方的身份公钥与该地址已知的公钥不同时拒绝连接；握手失败时，允许明文且该地址从未加密连接过才重新以明文连接*/
+//不支持加密的节点读到非MagicCode开头的数据会断开连接，因此握手失败时无法区分对方不支持加密还是连接被篡改
+func (t *secureTransport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := t.Transport.Dial(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	known := t.knownKey(addr)
+	c := &secureConn{Conn: conn, key: t.key, mode: t.mode, initiator: true}
+	conn.SetDeadline(time.Now().Add(timeout))
+	err = c.Handshake()
+	conn.SetDeadline(time.Time{})
+	if err == nil {
+		if known == nil {
+			t.pinKey(addr, c.remoteKey)
+			return c, nil
+		}
+		if bytes.Equal(known, c.remoteKey) {
+			return c, nil
+		}
+		conn.Close()
+		return nil, fmt.Errorf("node key of %s is %x, expected %x", addr, c.remoteKey, known)
+	}
+	conn.Close()
+
+	if t.mode == EncryptionRequired {
+		return nil, fmt.Errorf("encrypted handshake with %s failed: %v", addr, err)
+	}
+	if known != nil {
+		return nil, fmt.Errorf("encrypted handshake with %s failed, not falling back to plaintext for a node known to support encryption: %v", addr, err)
+	}
+	fmt.Printf("Encrypted handshake with %s failed, falling back to plaintext: %v\n", addr, err)
+
+	return t.Transport.Dial(addr, timeout)
+}
+
+/*在inner之上创建加密传输，从文件恢复已知节点的公钥。nodeId为空时已知公钥只保存在内存中*/
+func newSecureTransport(inner Transport, key *ecdsa.PrivateKey, mode EncryptionMode, nodeId string) *secureTransport {
+	t := &secureTransport{Transport: inner, key: key, mode: mode, keys: make(map[string][]byte)}
+	if nodeId == "" {
+		return t
+	}
+	t.keysFile = fmt.Sprintf(knownKeysFile, nodeId)
+
+	fileContent, err := ioutil.ReadFile(t.keysFile)
+	if err != nil {
+		return t
+	}
+	var keys map[string][]byte
+	if err := gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&keys); err != nil {
+		fmt.Printf("Known node keys are corrupted, discarding: %v\n", err)
+		return t
+	}
+	if keys != nil {
+		t.keys = keys
+	}
+
+	return t
+}
+
+func (t *secureTransport) knownKey(addr string) []byte {
+	t.keysMu.Lock()
+	defer t.keysMu.Unlock()
+
+	return t.keys[addr]
+}
+
+/*记住地址对应的身份公钥并写入文件*/
+func (t *secureTransport) pinKey(addr string, key []byte) {
+	t.keysMu.Lock()
+	defer t.keysMu.Unlock()
+
+	if _, ok := t.keys[addr]; ok {
+		return
+	}
+	t.keys[addr] = key
+	if t.keysFile == "" {
+		return
+	}
+
+	var content bytes.Buffer
+	err := gob.NewEncoder(&content).Encode(t.keys)
+	utils.Handle(err)
+
+	err = ioutil.WriteFile(t.keysFile, content.Bytes(), 0644)
+	if err != nil && !os.IsNotExist(err) {
+		utils.Handle(err)
+	}
+}
+
+/*进行握手，只进行一次，之后返回第一次握手的结果*/
+func (c *secureConn) Handshake() error {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+
+	if !c.handshakeDone {
+		c.handshakeDone = true
+		if c.initiator {
+			c.handshakeErr = c.initiatorHandshake()
+		} else {
+			c.handshakeErr = c.responderHandshake()
+		}
+	}
+
+	return c.handshakeErr
+}
+
+func (c *secureConn) initiatorHandshake() error {
+	ss := newSymmetricState()
+	e, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	//-> e
+	ePub := marshalPubKey(&e.PublicKey)
+	ss.mixHash(ePub)
+	if err := c.writeFrame(append(ePub, ss.encryptAndHash(nil)...)); err != nil {
+		return err
+	}
+
+	//<- e, ee, s, es
+	msg, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	if len(msg) != noisePubKeyLen*2+noiseTagLen*2 {
+		return errBadNoiseMessage
+	}
+	re := msg[:noisePubKeyLen]
+	ss.mixHash(re)
+	if err := ss.mixDH(e, re); err != nil {
+		return err
+	}
+	rs, err := ss.decryptAndHash(msg[noisePubKeyLen : noisePubKeyLen*2+noiseTagLen])
+	if err != nil {
+		return err
+	}
+	if err := ss.mixDH(e, rs); err != nil {
+		return err
+	}
+	if _, err := ss.decryptAndHash(msg[noisePubKeyLen*2+noiseTagLen:]); err != nil {
+		return err
+	}
+
+	//-> s, se
+	out := ss.encryptAndHash(marshalPubKey(&c.key.PublicKey))
+	if err := ss.mixDH(c.key, re); err != nil {
+		return err
+	}
+	out = append(out, ss.encryptAndHash(nil)...)
+	if err := c.writeFrame(out); err != nil {
+		return err
+	}
+
+	c.send, c.recv = ss.split()
+	c.remoteKey = rs
+
+	return nil
+}
+
+func (c *secureConn) responderHandshake() error {
+	//明文连接以MagicCode开头，加密连接以第一个握手消息的长度开头
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return err
+	}
+	if bytes.Equal(header, blockchain2.MagicCode[:]) {
+		if c.mode == EncryptionRequired {
+			return errPlaintextRefused
+		}
+		c.plain = true
+		c.readBuf = header
+		return nil
+	}
+
+	//-> e
+	if binary.BigEndian.Uint16(header) != noisePubKeyLen {
+		return errBadNoiseMessage
+	}
+	re := make([]byte, noisePubKeyLen)
+	if _, err := io.ReadFull(c.Conn, re); err != nil {
+		return err
+	}
+	ss := newSymmetricState()
+	ss.mixHash(re)
+	if _, err := ss.decryptAndHash(nil); err != nil {
+		return err
+	}
+
+	//<- e, ee, s, es
+	e, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	out := marshalPubKey(&e.PublicKey)
+	ss.mixHash(out)
+	if err := ss.mixDH(e, re); err != nil {
+		return err
+	}
+	out = append(out, ss.encryptAndHash(marshalPubKey(&c.key.PublicKey))...)
+	if err := ss.mixDH(c.key, re); err != nil {
+		return err
+	}
+	out = append(out, ss.encryptAndHash(nil)...)
+	if err := c.writeFrame(out); err != nil {
+		return err
+	}
+
+	//-> s, se
+	msg, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	if len(msg) != noisePubKeyLen+noiseTagLen*2 {
+		return errBadNoiseMessage
+	}
+	rs, err := ss.decryptAndHash(msg[:noisePubKeyLen+noiseTagLen])
+	if err != nil {
+		return err
+	}
+	if err := ss.mixDH(e, rs); err != nil {
+		return err
+	}
+	if _, err := ss.decryptAndHash(msg[noisePubKeyLen+noiseTagLen:]); err != nil {
+		return err
+	}
+
+	c.recv, c.send = ss.split()
+	c.remoteKey = rs
+
+	return nil
+}
+
+/*读取数据，加密连接中逐块读取并解密*/
+func (c *secureConn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if c.plain && len(c.readBuf) == 0 {
+		return c.Conn.Read(b)
+	}
+
+	for len(c.readBuf) == 0 {
+		frame, err := c.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		if c.readBuf, err = c.recv.decrypt(nil, frame); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+
+	return n, nil
+}
+
+/*写出数据，加密连接中分块加密后一次写出*/
+func (c *secureConn) Write(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if c.plain {
+		return c.Conn.Write(b)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	var out []byte
+	for rest := b; len(rest) > 0; {
+		n := len(rest)
+		if n > noiseMaxMsgLen-noiseTagLen {
+			n = noiseMaxMsgLen - noiseTagLen
+		}
+		out = appendFrame(out, c.send.encrypt(nil, rest[:n]))
+		rest = rest[n:]
+	}
+
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+/*对方的静态公钥，明文连接或握手完成前为nil*/
+func (c *secureConn) RemoteKey() []byte {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+
+	return c.remoteKey
+}
+
+func (c *secureConn) writeFrame(data []byte) error {
+	_, err := c.Conn.Write(appendFrame(nil, data))
+	return err
+}
+
+func (c *secureConn) readFrame() ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(header))
+	if _, err := io.ReadFull(c.Conn, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func appendFrame(out, data []byte) []byte {
+	var header [2]byte
+	binary.BigEndian.PutUint16(header[:], uint16(len(data)))
+
+	return append(append(out, header[:]...), data...)
+}
+
+func newCipherState(key []byte) *cipherState {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	return &cipherState{aead: aead}
+}
+
+//nonce为4字节0加上8字节大端的计数
+func (cs *cipherState) nonce() []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], cs.n)
+	cs.n++
+
+	return nonce
+}
+
+func (cs *cipherState) encrypt(ad, plaintext []byte) []byte {
+	return cs.aead.Seal(nil, cs.nonce(), plaintext, ad)
+}
+
+func (cs *cipherState) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	return cs.aead.Open(nil, cs.nonce(), ciphertext, ad)
+}
+
+/*以协议名初始化握手状态，并以MagicCode作为前言，使不同网络的节点无法完成握手*/
+func newSymmetricState() *symmetricState {
+	h := make([]byte, sha256.Size)
+	copy(h, noiseProtocolName)
+	ss := &symmetricState{ck: h, h: h}
+	ss.mixHash(blockchain2.MagicCode[:])
+
+	return ss
+}
+
+func (ss *symmetricState) mixHash(data []byte) {
+	sum := sha256.Sum256(append(append([]byte{}, ss.h...), data...))
+	ss.h = sum[:]
+}
+
+func (ss *symmetricState) mixKey(ikm []byte) {
+	var key []byte
+	ss.ck, key = hkdf(ss.ck, ikm)
+	ss.cs = newCipherState(key)
+}
+
+/*用私钥与对方公钥做ECDH，结果混入链式密钥*/
+func (ss *symmetricState) mixDH(private *ecdsa.PrivateKey, public []byte) error {
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, public)
+	if x == nil {
+		return errBadPublicKey
+	}
+
+	sx, _ := curve.ScalarMult(x, y, private.D.Bytes())
+	shared := make([]byte, 32)
+	b := sx.Bytes()
+	copy(shared[32-len(b):], b)
+	ss.mixKey(shared)
+
+	return nil
+}
+
+func (ss *symmetricState) encryptAndHash(plaintext []byte) []byte {
+	out := plaintext
+	if ss.cs != nil {
+		out = ss.cs.encrypt(ss.h, plaintext)
+	}
+	ss.mixHash(out)
+
+	return out
+}
+
+func (ss *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	out := ciphertext
+	if ss.cs != nil {
+		var err error
+		if out, err = ss.cs.decrypt(ss.h, ciphertext); err != nil {
+			return nil, err
+		}
+	}
+	ss.mixHash(ciphertext)
+
+	return out, nil
+}
+
+/*握手完成后派生两个方向的密钥，第一个用于连接方发送*/
+func (ss *symmetricState) split() (*cipherState, *cipherState) {
+	k1, k2 := hkdf(ss.ck, nil)
+
+	return newCipherState(k1), newCipherState(k2)
+}
+
+/*Noise中的HKDF，输出两个32字节的密钥*/
+func hkdf(chainingKey, ikm []byte) ([]byte, []byte) {
+	tempKey := hmacSHA256(chainingKey, ikm)
+	out1 := hmacSHA256(tempKey, []byte{0x01})
+	out2 := hmacSHA256(tempKey, append(append([]byte{}, out1...), 0x02))
+
+	return out1, out2
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+func marshalPubKey(pub *ecdsa.PublicKey) []byte {
+	return elliptic.Marshal(elliptic.P256(), pub.X, pub.Y)
+}
@@ -0,0 +1,198 @@
+package network
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
+	"time"
+)
+
+const (
+	maxOrphanBlocks   = 64               //孤块池容量
+	maxOrphanTxs      = 100              //孤立交易池容量
+	orphanBlockExpiry = 10 * time.Minute //孤块在池中的最长保留时间
+	orphanTxExpiry    = 20 * time.Minute //孤立交易在池中的最长保留时间
+)
+
+//前一区块尚未收到的区块
+type orphanBlock struct {
+	block *blockchain.Block
+	peer  *Peer //发来该区块的节点，缺失的前一区块向它请求
+	added time.Time
+}
+
+//来源交易尚未上链的交易
+type orphanTx struct {
+	tx      blockchain.Transaction
+	peer    *Peer
+	missing [][]byte //缺失的来源交易ID
+	added   time.Time
+}
+
+//方法列表
+//1.func (n *Node) addOrphanBlock(block *blockchain.Block, p *Peer)
+//2.func (n *Node) removeOrphanBlock(o *orphanBlock)
+//3.func (n *Node) orphanRoot(hash []byte) []byte
+//4.func (n *Node) connectOrphanBlocks(parent []byte)
+//5.func (n *Node) addOrphanTx(tx blockchain.Transaction, p *Peer, missing [][]byte)
+//6.func (n *Node) removeOrphanTx(o *orphanTx)
+//7.func (n *Node) processOrphanTxs(parents [][]byte)
+//8.func (n *Node) expireOrphans()
+//9.func (n *Node) hasOrphanTx(id []byte) bool
+//10.func (n *Node) inMemoryPool(id []byte) bool
+
+/*将孤块放入池中，池满时淘汰最早加入的孤块*/
+func (n *Node) addOrphanBlock(block *blockchain.Block, p *Peer) {
+	if _, ok := n.orphanBlocks[string(block.Hash)]; ok {
+		return
+	}
+
+	if len(n.orphanBlocks) >= maxOrphanBlocks {
+		var oldest *orphanBlock
+		for _, o := range n.orphanBlocks {
+			if oldest == nil || o.added.Before(oldest.added) {
+				oldest = o
+			}
+		}
+		n.removeOrphanBlock(oldest)
+	}
+
+	o := &orphanBlock{block, p, time.Now()}
+	n.orphanBlocks[string(block.Hash)] = o
+	prev := string(block.PrevHash)
+	n.orphanBlocksByPrev[prev] = append(n.orphanBlocksByPrev[prev], o)
+
+	fmt.Printf("Added orphan block %x, waiting for %x\n", block.Hash, block.PrevHash)
+}
+
+func (n *Node) removeOrphanBlock(o *orphanBlock) {
+	delete(n.orphanBlocks, string(o.block.Hash))
+
+	prev := string(o.block.PrevHash)
+	siblings := n.orphanBlocksByPrev[prev]
+	for i, s := range siblings {
+		if s == o {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(n.orphanBlocksByPrev, prev)
+	} else {
+		n.orphanBlocksByPrev[prev] = siblings
+	}
+}
+
+/*沿孤块的前一区块回溯，返回最早缺失的区块哈希，即应当向对方请求的区块*/
+func (n *Node) orphanRoot(hash []byte) []byte {
+	for {
+		o, ok := n.orphanBlocks[string(hash)]
+		if !ok {
+			return hash
+		}
+		hash = o.block.PrevHash
+	}
+}
+
+/*父区块连接到链上后，连接等待它的孤块，孤块的后代由connectBlock继续连接*/
+func (n *Node) connectOrphanBlocks(parent []byte) {
+	children := append([]*orphanBlock(nil), n.orphanBlocksByPrev[string(parent)]...)
+	for _, o := range children {
+		n.removeOrphanBlock(o)
+		n.connectBlock(o.block)
+	}
+}
+
+/*将孤立交易放入池中，池满时淘汰最早加入的孤立交易*/
+func (n *Node) addOrphanTx(tx blockchain.Transaction, p *Peer, missing [][]byte) {
+	key := string(tx.ID)
+	if _, ok := n.orphanTxs[key]; ok {
+		return
+	}
+
+	if len(n.orphanTxs) >= maxOrphanTxs {
+		var oldest *orphanTx
+		for _, o := range n.orphanTxs {
+			if oldest == nil || o.added.Before(oldest.added) {
+				oldest = o
+			}
+		}
+		n.removeOrphanTx(oldest)
+	}
+
+	o := &orphanTx{tx, p, missing, time.Now()}
+	n.orphanTxs[key] = o
+	for _, id := range missing {
+		n.orphanTxsByMissing[string(id)] = append(n.orphanTxsByMissing[string(id)], o)
+	}
+
+	fmt.Printf("Added orphan transaction %x, missing %d inputs\n", tx.ID, len(missing))
+}
+
+func (n *Node) removeOrphanTx(o *orphanTx) {
+	delete(n.orphanTxs, string(o.tx.ID))
+
+	for _, id := range o.missing {
+		key := string(id)
+		waiting := n.orphanTxsByMissing[key]
+		for i, w := range waiting {
+			if w == o {
+				waiting = append(waiting[:i], waiting[i+1:]...)
+				break
+			}
+		}
+		if len(waiting) == 0 {
+			delete(n.orphanTxsByMissing, key)
+		} else {
+			n.orphanTxsByMissing[key] = waiting
+		}
+	}
+}
+
+/*来源交易上链后，重新检查等待它们的孤立交易，来源交易齐全且验证通过的放入内存池*/
+func (n *Node) processOrphanTxs(parents [][]byte) {
+	for _, parent := range parents {
+		waiting := append([]*orphanTx(nil), n.orphanTxsByMissing[string(parent)]...)
+		for _, o := range waiting {
+			if len(n.chain.MissingInputs(&o.tx)) > 0 {
+				continue
+			}
+
+			n.removeOrphanTx(o)
+			if !n.chain.VerifyTransaction(&o.tx) {
+				fmt.Printf("Dropped invalid orphan transaction %x\n", o.tx.ID)
+				continue
+			}
+			n.acceptTx(o.tx, o.peer)
+		}
+	}
+}
+
+/*淘汰超时的孤块和孤立交易*/
+func (n *Node) expireOrphans() {
+	now := time.Now()
+
+	for _, o := range n.orphanBlocks {
+		if now.Sub(o.added) > orphanBlockExpiry {
+			n.removeOrphanBlock(o)
+		}
+	}
+
+	for _, o := range n.orphanTxs {
+		if now.Sub(o.added) > orphanTxExpiry {
+			n.removeOrphanTx(o)
+		}
+	}
+}
+
+/*孤立交易池中是否有某交易*/
+func (n *Node) hasOrphanTx(id []byte) bool {
+	_, ok := n.orphanTxs[string(id)]
+	return ok
+}
+
+/*交易是否在内存池中*/
+func (n *Node) inMemoryPool(id []byte) bool {
+	_, ok := n.memoryPool[hex.EncodeToString(id)]
+	return ok
+}
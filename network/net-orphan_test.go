@@ -0,0 +1,150 @@
+package network
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
+)
+
+/*只带孤块池和孤立交易池的节点*/
+func newOrphanTestNode() *Node {
+	return &Node{
+		orphanBlocks:       make(map[string]*orphanBlock),
+		orphanBlocksByPrev: make(map[string][]*orphanBlock),
+		orphanTxs:          make(map[string]*orphanTx),
+		orphanTxsByMissing: make(map[string][]*orphanTx),
+	}
+}
+
+func testOrphanBlock(i int) *blockchain.Block {
+	return &blockchain.Block{Hash: []byte(fmt.Sprintf("block%d", i)), PrevHash: []byte(fmt.Sprintf("prev%d", i%3))}
+}
+
+func testOrphanTx(i int) blockchain.Transaction {
+	return blockchain.Transaction{ID: []byte(fmt.Sprintf("tx%d", i))}
+}
+
+/*依次放入count个孤块，加入时间按序号递增*/
+func addOrphanBlocks(n *Node, from, count int, base time.Time) {
+	for i := from; i < from+count; i++ {
+		block := testOrphanBlock(i)
+		n.addOrphanBlock(block, nil)
+		n.orphanBlocks[string(block.Hash)].added = base.Add(time.Duration(i) * time.Second)
+	}
+}
+
+/*依次放入count个孤立交易，每个缺失两个来源交易，加入时间按序号递增*/
+func addOrphanTxs(n *Node, from, count int, base time.Time) {
+	for i := from; i < from+count; i++ {
+		tx := testOrphanTx(i)
+		n.addOrphanTx(tx, nil, [][]byte{[]byte("shared"), []byte(fmt.Sprintf("parent%d", i))})
+		n.orphanTxs[string(tx.ID)].added = base.Add(time.Duration(i) * time.Second)
+	}
+}
+
+/*检查按前一区块和缺失来源交易建立的索引与池中内容一致*/
+func checkOrphanIndexes(t *testing.T, n *Node) {
+	t.Helper()
+
+	indexed := 0
+	for prev, orphans := range n.orphanBlocksByPrev {
+		for _, o := range orphans {
+			if n.orphanBlocks[string(o.block.Hash)] != o || string(o.block.PrevHash) != prev {
+				t.Errorf("stale orphan block %s indexed under %s", o.block.Hash, prev)
+			}
+			indexed++
+		}
+	}
+	if indexed != len(n.orphanBlocks) {
+		t.Errorf("%d orphan blocks indexed, %d in the pool", indexed, len(n.orphanBlocks))
+	}
+
+	for missing, orphans := range n.orphanTxsByMissing {
+		if len(orphans) == 0 {
+			t.Errorf("empty index entry for %s", missing)
+		}
+		for _, o := range orphans {
+			if n.orphanTxs[string(o.tx.ID)] != o {
+				t.Errorf("stale orphan transaction %s indexed under %s", o.tx.ID, missing)
+			}
+		}
+	}
+	if got := len(n.orphanTxsByMissing["shared"]); got != len(n.orphanTxs) {
+		t.Errorf("%d orphan transactions waiting for the shared parent, %d in the pool", got, len(n.orphanTxs))
+	}
+}
+
+func TestOrphanBlockLimit(t *testing.T) {
+	n := newOrphanTestNode()
+	base := time.Now()
+
+	//池满后每放入一个淘汰一个最早加入的
+	addOrphanBlocks(n, 0, maxOrphanBlocks+5, base)
+	if len(n.orphanBlocks) != maxOrphanBlocks {
+		t.Fatalf("%d orphan blocks, want %d", len(n.orphanBlocks), maxOrphanBlocks)
+	}
+	for i := 0; i < maxOrphanBlocks+5; i++ {
+		_, ok := n.orphanBlocks[string(testOrphanBlock(i).Hash)]
+		if want := i >= 5; ok != want {
+			t.Errorf("orphan block %d in pool = %v, want %v", i, ok, want)
+		}
+	}
+	checkOrphanIndexes(t, n)
+
+	//重复放入不占位置，也不淘汰其他孤块
+	n.addOrphanBlock(testOrphanBlock(maxOrphanBlocks), nil)
+	if _, ok := n.orphanBlocks[string(testOrphanBlock(5).Hash)]; !ok || len(n.orphanBlocks) != maxOrphanBlocks {
+		t.Error("duplicate orphan block evicted another")
+	}
+}
+
+func TestOrphanTxLimit(t *testing.T) {
+	n := newOrphanTestNode()
+	base := time.Now()
+
+	addOrphanTxs(n, 0, maxOrphanTxs+5, base)
+	if len(n.orphanTxs) != maxOrphanTxs {
+		t.Fatalf("%d orphan transactions, want %d", len(n.orphanTxs), maxOrphanTxs)
+	}
+	for i := 0; i < maxOrphanTxs+5; i++ {
+		id := testOrphanTx(i).ID
+		if want := i >= 5; n.hasOrphanTx(id) != want {
+			t.Errorf("orphan transaction %d in pool = %v, want %v", i, !want, want)
+		}
+		if _, ok := n.orphanTxsByMissing[fmt.Sprintf("parent%d", i)]; ok != (i >= 5) {
+			t.Errorf("index of evicted orphan transaction %d not removed", i)
+		}
+	}
+	checkOrphanIndexes(t, n)
+}
+
+func TestExpireOrphans(t *testing.T) {
+	n := newOrphanTestNode()
+
+	//前一半已超过保留时间
+	expired := time.Now().Add(-orphanTxExpiry - time.Minute)
+	addOrphanBlocks(n, 0, 4, expired)
+	addOrphanTxs(n, 0, 4, expired)
+	addOrphanBlocks(n, 4, 4, time.Now())
+	addOrphanTxs(n, 4, 4, time.Now())
+
+	//孤块的保留时间更短，刚过孤块保留时间的孤立交易不被淘汰
+	between := time.Now().Add(-orphanBlockExpiry - 2*time.Minute)
+	addOrphanBlocks(n, 8, 1, between.Add(-8*time.Second))
+	addOrphanTxs(n, 8, 1, between.Add(-8*time.Second))
+
+	n.expireOrphans()
+
+	for i := 0; i < 9; i++ {
+		_, block := n.orphanBlocks[string(testOrphanBlock(i).Hash)]
+		if want := i >= 4 && i < 8; block != want {
+			t.Errorf("orphan block %d in pool = %v, want %v", i, block, want)
+		}
+		if want := i >= 4; n.hasOrphanTx(testOrphanTx(i).ID) != want {
+			t.Errorf("orphan transaction %d in pool = %v, want %v", i, !want, want)
+		}
+	}
+	checkOrphanIndexes(t, n)
+}
@@ -0,0 +1,409 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	outboundQueueSize = 1000                      //每个节点待发送消息队列的条数上限
+	maxQueuedBytes    = 2 * blockchain2.MaxMsgLen //每个节点待发送消息的字节数上限，至少能放下一条最长的消息
+	dialTimeout       = 5 * time.Second           //主动连接的超时时间
+	flushTimeout      = 10 * time.Second          //关闭连接前发送剩余消息的超时时间
+)
+
+var writeTimeout = time.Minute //写出一个消息帧的超时时间，对方长时间不读取时断开。测试中调小
+
+var (
+	errPeerClosed    = errors.New("peer connection closed")
+	errSendQueueFull = errors.New("send queue is full")
+)
+
+// 待发送的一条消息
+type outMessage struct {
+	msgType uint8
+	payload []byte
+}
+
+// 与另一个节点之间的长连接
+// 读循环不断读取消息帧并交给处理函数，写循环从发送队列中取出消息写入连接
+// 握手完成前读循环只处理握手消息，握手完成后节点才被登记并开始处理其他消息
+type Peer struct {
+	//收发统计，原子访问，放在结构体开头以保证64位对齐
+	bytesSent   uint64
+	bytesRecv   uint64
+	lastRecv    int64  //最近一次收到消息的时间（UnixNano）
+	queuedBytes uint64 //发送队列中消息的总字节数
+
+	Addr       string //出站连接为连接的地址，入站连接为连接的实际对端地址，节点以此登记
+	ListenAddr string //对方的监听地址，入站连接为对方在Hand中声称的地址，无法验证，只用作地址簿的线索
+	Inbound    bool   //是否为对方主动发起的连接
+
+	//握手时得到的对方信息
+	Version      uint32 //双方协商后使用的协议版本
+	Capabilities blockchain2.Capabilities
+	UserAgent    string
+	BestHeight   int
+	NodeKey      []byte //对方在加密握手中出示的身份公钥，明文连接为nil
+	ConnectedAt  time.Time
+
+	//保活状态，握手后只在持有handlerMu时访问
+	TotalDifficulty uint64        //对方在ping或pong中报告的累计难度
+	PingTime        time.Duration //最近一次测得的往返时间
+	pingNonce       uint64        //未应答的ping的随机数，没有未应答的ping时为0
+	pingSent        time.Time     //最近一次发出ping的时间
+
+	conn      net.Conn
+	node      *Node
+	chain     *blockchain.BlockChain
+	outbound  chan outMessage
+	flushing  time.Time //开始发送剩余消息的截止时间，只由写循环访问
+	quit      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	connected bool          //握手是否完成，只由读循环访问
+	ready     chan struct{} //握手完成时关闭
+	err       error         //读循环退出的原因，读写循环退出后才可读取
+
+	invMu sync.Mutex
+	inv   *inventory //对方已知的区块与交易，以及等待批量发送的存证
+
+	bandwidth *tokenBucket //接收限速，只由读循环访问
+	msgRate   *tokenBucket
+}
+
+//方法列表
+//1.func (n *Node) Connect(addr string) (*Peer, error)
+//2.func (n *Node) GetPeer(addr string) (*Peer, error)
+//3.func (p *Peer) Send(msgType uint8, payload []byte) error
+//4.func (p *Peer) Close()
+//5.func (p *Peer) Wait()
+
+/*创建节点对象并启动读写循环，本节点已停止时关闭连接并返回nil*/
+func (n *Node) newPeer(conn net.Conn, addr string, inbound bool) *Peer {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+
+	if n.stopped() {
+		conn.Close()
+		return nil
+	}
+
+	p := &Peer{
+		Addr:      addr,
+		Inbound:   inbound,
+		conn:      conn,
+		node:      n,
+		chain:     n.chain,
+		outbound:  make(chan outMessage, outboundQueueSize),
+		quit:      make(chan struct{}),
+		ready:     make(chan struct{}),
+		inv:       newInventory(),
+		bandwidth: newTokenBucket(maxBandwidth, float64(bandwidthBurst)),
+		msgRate:   newTokenBucket(maxMsgRate, msgRateBurst),
+	}
+	if !inbound {
+		p.ListenAddr = addr
+	}
+	if inbound {
+		n.inboundConns++
+	}
+
+	p.wg.Add(2)
+	go p.readLoop()
+	go p.writeLoop()
+
+	//节点停止时等待连接关闭
+	n.wg.Add(1)
+	go func() {
+		p.Wait()
+		if inbound {
+			n.peersMu.Lock()
+			n.inboundConns--
+			n.peersMu.Unlock()
+		}
+		n.wg.Done()
+	}()
+
+	return p
+}
+
+/*主动连接某一地址并完成握手，返回新建立的节点连接*/
+func (n *Node) Connect(addr string) (*Peer, error) {
+	conn, err := n.Transport.Dial(addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	p := n.newPeer(conn, addr, false)
+	if p == nil {
+		return nil, errNodeStopped
+	}
+	p.SendHand()
+
+	select {
+	case <-p.ready:
+		return p, nil
+	case <-p.quit:
+		p.Wait()
+		if p.err == nil {
+			p.err = errPeerClosed
+		}
+		return nil, fmt.Errorf("handshake with %s failed: %v", addr, p.err)
+	case <-time.After(handshakeTimeout):
+		p.Close()
+		return nil, fmt.Errorf("handshake with %s timed out", addr)
+	}
+}
+
+/*握手完成，登记节点并通知上层。已有以同一地址登记的连接时返回错误，调用者应断开新连接*/
+func (p *Peer) setConnected() error {
+	if c, ok := p.conn.(*secureConn); ok {
+		p.NodeKey = c.RemoteKey()
+	}
+	if err := p.node.registerPeer(p); err != nil {
+		return err
+	}
+	p.ConnectedAt = time.Now()
+	p.connected = true
+	close(p.ready)
+
+	if p.NodeKey != nil {
+		fmt.Printf("Connected to %s (%s, version %d, encrypted, key %x)\n", p, p.UserAgent, p.Version, p.NodeKey)
+	} else {
+		fmt.Printf("Connected to %s (%s, version %d)\n", p, p.UserAgent, p.Version)
+	}
+	p.node.handlePeerConnected(p)
+
+	return nil
+}
+
+/*返回与某一地址的已有连接，没有则新建连接*/
+func (n *Node) GetPeer(addr string) (*Peer, error) {
+	n.peersMu.Lock()
+	p, ok := n.peers[addr]
+	n.peersMu.Unlock()
+
+	if ok {
+		return p, nil
+	}
+
+	return n.Connect(addr)
+}
+
+/*登记节点，不替换已有的连接：入站连接以实际的对端地址登记，对方无法通过声称的地址顶替其他节点*/
+func (n *Node) registerPeer(p *Peer) error {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+
+	if old, ok := n.peers[p.Addr]; ok && old != p {
+		return fmt.Errorf("already connected to %s", p.Addr)
+	}
+	n.peers[p.Addr] = p
+
+	return nil
+}
+
+func (n *Node) unregisterPeer(p *Peer) {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+
+	if n.peers[p.Addr] == p {
+		delete(n.peers, p.Addr)
+	}
+}
+
+/*将消息放入发送队列，不等待。连接已关闭时返回错误*/
+//对方读取太慢以致队列超过条数或字节数上限时断开连接，调用者（可能持有handlerMu）不会因一个慢节点而阻塞
+func (p *Peer) Send(msgType uint8, payload []byte) error {
+	select {
+	case <-p.quit:
+		return errPeerClosed
+	default:
+	}
+
+	size := uint64(blockchain2.HeaderLen) + uint64(len(payload))
+	if atomic.AddUint64(&p.queuedBytes, size) <= maxQueuedBytes {
+		select {
+		case p.outbound <- outMessage{msgType, payload}:
+			return nil
+		default:
+		}
+	}
+	atomic.AddUint64(&p.queuedBytes, -size)
+
+	fmt.Printf("Send queue to %s is full, disconnecting\n", p)
+	p.Close()
+
+	return errSendQueueFull
+}
+
+/*关闭连接，发送队列中剩余的消息会先被写出*/
+func (p *Peer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+		p.node.unregisterPeer(p)
+	})
+}
+
+/*等待读写循环退出*/
+func (p *Peer) Wait() {
+	p.wg.Wait()
+}
+
+/*连接是否已关闭*/
+func (p *Peer) closed() bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Peer) String() string {
+	return p.Addr
+}
+
+/*连接的实际对端地址，封禁和不良行为分数按其中的IP计*/
+func (p *Peer) remoteAddr() string {
+	return p.conn.RemoteAddr().String()
+}
+
+/*读循环：读取消息帧并处理，出错或超时未收到消息时关闭连接*/
+func (p *Peer) readLoop() {
+	defer p.wg.Done()
+	defer p.Close()
+
+	for {
+		p.setReadDeadline()
+		msgType, payload, err := ReadMessage(p.conn)
+		if err != nil {
+			select {
+			case <-p.quit:
+			default:
+				fmt.Printf("Disconnected from %s: %v\n", p, err)
+				p.err = err
+				//超长的消息没有被读入，无法继续读取后续消息，握手后发送超长消息的节点直接封禁
+				if _, ok := err.(*msgTooLongError); ok && p.connected {
+					misbehaving(p, banThreshold, blockchain2.ReasonBadMessage, err)
+				}
+			}
+			return
+		}
+
+		size := int(blockchain2.HeaderLen) + len(payload)
+		atomic.AddUint64(&p.bytesRecv, uint64(size))
+		atomic.StoreInt64(&p.lastRecv, time.Now().UnixNano())
+
+		if err := p.throttle(size); err != nil {
+			p.err = err
+			return
+		}
+
+		if !p.connected {
+			//握手失败直接断开
+			if err := p.handleHandshake(msgType, payload); err != nil {
+				fmt.Printf("Handshake with %s failed: %v\n", p, err)
+				p.err = err
+				return
+			}
+			continue
+		}
+
+		if err := p.node.handleMessage(p, msgType, payload); err != nil {
+			//无法处理的消息计入不良行为分数，分数过高时被封禁；没有节点管理器时直接断开
+			if p.node.peerManager == nil {
+				fmt.Printf("Bad %s message from %s: %v\n", MsgTypeName(msgType), p, err)
+				p.err = err
+				return
+			}
+			p.node.peerManager.Misbehaving(p, scoreBadMessage, blockchain2.ReasonBadMessage, err.Error())
+		}
+
+		//处理消息时连接可能已被关闭（如对方被封禁），不再读取后续消息
+		if p.closed() {
+			return
+		}
+	}
+}
+
+/*写循环：将发送队列中的消息逐一写出，并每隔一段随机时间批量发出积攒的存证。关闭连接或节点停止时先写完队列中剩余的消息再断开连接*/
+func (p *Peer) writeLoop() {
+	defer p.wg.Done()
+	defer p.conn.Close()
+
+	trickle := time.NewTimer(nextTrickle())
+	defer trickle.Stop()
+
+	for {
+		select {
+		case msg := <-p.outbound:
+			if err := p.writeQueued(msg); err != nil {
+				p.Close()
+				return
+			}
+		case <-trickle.C:
+			for _, inv := range p.takeInv() {
+				if err := p.writeMessage(MsgTypeInv, GobEncode(inv)); err != nil {
+					p.Close()
+					return
+				}
+			}
+			trickle.Reset(nextTrickle())
+		case <-p.node.quit:
+			//本节点停止
+			p.Close()
+			p.flush()
+			return
+		case <-p.quit:
+			p.flush()
+			return
+		}
+	}
+}
+
+/*在flushTimeout内写出队列中剩余的消息*/
+func (p *Peer) flush() {
+	p.flushing = time.Now().Add(flushTimeout)
+
+	for {
+		select {
+		case msg := <-p.outbound:
+			if err := p.writeQueued(msg); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+/*写出发送队列中取出的消息，并从队列字节数中扣除*/
+func (p *Peer) writeQueued(msg outMessage) error {
+	atomic.AddUint64(&p.queuedBytes, -(uint64(blockchain2.HeaderLen) + uint64(len(msg.payload))))
+
+	return p.writeMessage(msg.msgType, msg.payload)
+}
+
+/*写出一条消息并计入发送字节数，只由写循环调用。每个消息帧都设置写超时，对方不读取时写操作不会无限阻塞*/
+func (p *Peer) writeMessage(msgType uint8, payload []byte) error {
+	deadline := time.Now().Add(writeTimeout)
+	if !p.flushing.IsZero() && p.flushing.Before(deadline) {
+		deadline = p.flushing
+	}
+	p.conn.SetWriteDeadline(deadline)
+
+	if err := WriteMessage(p.conn, msgType, payload); err != nil {
+		return err
+	}
+	atomic.AddUint64(&p.bytesSent, uint64(blockchain2.HeaderLen)+uint64(len(payload)))
+
+	return nil
+}
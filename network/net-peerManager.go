@@ -0,0 +1,476 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const addrBookFile = "./tmp/peers_%s.data" //地址簿文件，按nodeId区分
+
+const (
+	defaultTargetOutbound = 8    //希望保持的出站连接数
+	defaultMaxInbound     = 32   //最多接受的入站连接数
+	maxAddrBookSize       = 1000 //地址簿最多保存的地址数
+
+	connectInterval = 10 * time.Second //检查连接数并补充连接的间隔
+	retryBase       = 5 * time.Second  //连接失败后重试的初始等待时间，之后每失败一次翻倍
+	retryMax        = 30 * time.Minute //重试等待时间的上限
+	maxFailures     = 20               //连续失败这么多次后将地址移出地址簿（种子节点除外）
+
+	banThreshold = 100            //不良行为分数达到此值即封禁
+	banDuration  = 24 * time.Hour //封禁时长，按对方IP封禁
+
+	scoreBadMessage = 20 //收到无法处理的消息
+)
+
+//地址簿中的一个节点地址
+type PeerEntry struct {
+	Addr         string
+	Capabilities blockchain2.Capabilities
+	LastSeen     time.Time //最近一次握手成功的时间
+	LastAttempt  time.Time //最近一次主动连接的时间
+	Failures     int       //连续连接失败的次数
+}
+
+//对某一节点的封禁
+type Ban struct {
+	Until  time.Time
+	Reason blockchain2.ReasonForBan
+}
+
+//持久化到文件的地址簿
+type AddrBook struct {
+	Entries map[string]*PeerEntry //节点监听地址 -> 地址信息
+	Bans    map[string]*Ban       //节点IP（本机地址为IP和端口） -> 封禁
+}
+
+//节点管理器：维护地址簿，保持目标数量的连接，对行为不良的节点计分并封禁
+type PeerManager struct {
+	TargetOutbound int
+	MaxInbound     int
+
+	node    *Node
+	seeds   map[string]bool
+	book    AddrBook
+	scores  map[string]int //节点IP（本机地址为IP和端口） -> 不良行为分数
+	dirty   bool
+	dialing map[string]bool
+	mu      sync.Mutex
+	quit    chan struct{}
+}
+
+//方法列表
+//1.func NewPeerManager(n *Node) *PeerManager
+//2.func (m *PeerManager) Run()
+//3.func (m *PeerManager) Stop()
+//4.func (m *PeerManager) AddAddrs(addrs []string)
+//5.func (m *PeerManager) PeerAddrs(capabilities blockchain2.Capabilities, max int) []string
+//6.func (m *PeerManager) Misbehaving(p *Peer, score int, reason blockchain2.ReasonForBan, why string)
+//7.func (m *PeerManager) BanPeer(p *Peer, reason blockchain2.ReasonForBan)
+//8.func (m *PeerManager) IsBanned(addr string) bool
+//9.func (m *PeerManager) AcceptInbound() bool
+//10.func (m *PeerManager) SaveFile()
+//11.func (m *PeerManager) Score(addr string) int
+
+/*为节点创建节点管理器，从文件恢复地址簿，并加入种子节点*/
+func NewPeerManager(n *Node) *PeerManager {
+	m := &PeerManager{
+		TargetOutbound: defaultTargetOutbound,
+		MaxInbound:     defaultMaxInbound,
+		node:           n,
+		seeds:          make(map[string]bool),
+		scores:         make(map[string]int),
+		dialing:        make(map[string]bool),
+		quit:           make(chan struct{}),
+	}
+
+	m.loadFile()
+
+	for _, seed := range n.SeedNodes {
+		m.seeds[seed] = true
+	}
+	m.AddAddrs(n.SeedNodes)
+
+	return m
+}
+
+func (m *PeerManager) loadFile() {
+	m.book = AddrBook{make(map[string]*PeerEntry), make(map[string]*Ban)}
+
+	bookFile := fmt.Sprintf(addrBookFile, m.node.NodeID)
+	fileContent, err := ioutil.ReadFile(bookFile)
+	if err != nil {
+		return
+	}
+
+	//地址簿损坏时丢弃，从种子节点重新发现
+	var book AddrBook
+	if err := gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&book); err != nil {
+		fmt.Printf("Address book is corrupted, starting from seeds: %v\n", err)
+		return
+	}
+
+	if book.Entries != nil {
+		m.book.Entries = book.Entries
+	}
+	if book.Bans != nil {
+		m.book.Bans = book.Bans
+	}
+}
+
+/*将地址簿写入文件*/
+func (m *PeerManager) SaveFile() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.saveFile()
+}
+
+func (m *PeerManager) saveFile() {
+	var content bytes.Buffer
+	bookFile := fmt.Sprintf(addrBookFile, m.node.NodeID)
+
+	err := gob.NewEncoder(&content).Encode(m.book)
+	utils.Handle(err)
+
+	err = ioutil.WriteFile(bookFile, content.Bytes(), 0644)
+	if err != nil && !os.IsNotExist(err) {
+		utils.Handle(err)
+	}
+
+	m.dirty = false
+}
+
+/*每隔connectInterval检查一次连接数，不足时从地址簿中挑选节点连接，直到Stop被调用*/
+func (m *PeerManager) Run() {
+	ticker := time.NewTicker(connectInterval)
+	defer ticker.Stop()
+
+	for {
+		m.connectMore()
+
+		select {
+		case <-ticker.C:
+		case <-m.quit:
+			m.SaveFile()
+			return
+		}
+	}
+}
+
+/*停止Run循环并保存地址簿*/
+func (m *PeerManager) Stop() {
+	close(m.quit)
+}
+
+/*将新发现的地址加入地址簿，重复的地址、本节点地址和被封禁的地址被忽略*/
+func (m *PeerManager) AddAddrs(addrs []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, addr := range addrs {
+		if addr == "" || addr == m.node.AdvertiseAddr || m.isBanned(addr) {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			continue
+		}
+		if _, ok := m.book.Entries[addr]; ok {
+			continue
+		}
+		if len(m.book.Entries) >= maxAddrBookSize {
+			break
+		}
+
+		m.book.Entries[addr] = &PeerEntry{Addr: addr}
+		m.dirty = true
+	}
+}
+
+/*返回至多max个健康的节点地址（曾成功连接且未被封禁），按最近连接时间排序*/
+func (m *PeerManager) PeerAddrs(capabilities blockchain2.Capabilities, max int) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []*PeerEntry
+	for _, entry := range m.book.Entries {
+		if entry.LastSeen.IsZero() || entry.Failures > 0 || m.isBanned(entry.Addr) {
+			continue
+		}
+		if entry.Capabilities&capabilities != capabilities {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastSeen.After(entries[j].LastSeen)
+	})
+
+	var addrs []string
+	for i := 0; i < len(entries) && i < max; i++ {
+		addrs = append(addrs, entries[i].Addr)
+	}
+
+	return addrs
+}
+
+/*记录节点的不良行为，分数达到banThreshold时封禁该节点*/
+func (m *PeerManager) Misbehaving(p *Peer, score int, reason blockchain2.ReasonForBan, why string) {
+	key := banKey(p.remoteAddr())
+
+	m.mu.Lock()
+	m.scores[key] += score
+	total := m.scores[key]
+	m.mu.Unlock()
+
+	fmt.Printf("Peer %s misbehaving (%d/%d): %s\n", p, total, banThreshold, why)
+
+	if total >= banThreshold {
+		m.BanPeer(p, reason)
+	}
+}
+
+/*对方发来了无效的数据：有节点管理器时记入不良行为分数并返回nil，否则返回err使调用者断开连接*/
+func misbehaving(p *Peer, score int, reason blockchain2.ReasonForBan, err error) error {
+	m := p.node.peerManager
+	if m == nil {
+		return err
+	}
+
+	m.Misbehaving(p, score, reason, err.Error())
+
+	return nil
+}
+
+/*封禁节点：告知对方原因后断开连接，banDuration内拒绝与其连接*/
+//按连接的对端IP封禁，对方换端口或在Hand中声称别的地址都不能绕过封禁，也不能让别的节点被封禁
+//本机地址按IP和端口封禁，本地测试网络中同一主机上的其他节点不受影响
+func (m *PeerManager) BanPeer(p *Peer, reason blockchain2.ReasonForBan) {
+	key := banKey(p.remoteAddr())
+
+	m.mu.Lock()
+	m.book.Bans[key] = &Ban{time.Now().Add(banDuration), reason}
+	delete(m.scores, key)
+	m.saveFile()
+	m.mu.Unlock()
+
+	fmt.Printf("Banned %s for %v, reason %d\n", p, banDuration, reason)
+
+	SendBanReason(p, reason)
+	p.Close()
+}
+
+/*检查地址所在的IP是否被封禁*/
+func (m *PeerManager) IsBanned(addr string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.isBanned(addr)
+}
+
+func (m *PeerManager) isBanned(addr string) bool {
+	key := banKey(addr)
+	ban, ok := m.book.Bans[key]
+	if !ok {
+		return false
+	}
+
+	//封禁过期则解除
+	if time.Now().After(ban.Until) {
+		delete(m.book.Bans, key)
+		m.dirty = true
+		return false
+	}
+
+	return true
+}
+
+/*封禁和不良行为分数的键：地址中的主机部分，本机地址则为整个地址*/
+//本机上通常同时运行多个节点，只能按端口区分。本机的入站连接使用临时端口，重连即可避开封禁，但这只影响本机
+func banKey(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+		return addr
+	}
+
+	return host
+}
+
+/*返回地址所在IP当前的不良行为分数*/
+func (m *PeerManager) Score(addr string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.scores[banKey(addr)]
+}
+
+/*决定是否接受一个入站连接，入站连接数（包括尚未完成握手的）达到上限时拒绝。被封禁的节点在握手时拒绝*/
+func (m *PeerManager) AcceptInbound() bool {
+	n := m.node
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+
+	return n.inboundConns < m.MaxInbound
+}
+
+/*握手完成后更新地址簿*/
+func (m *PeerManager) peerConnected(p *Peer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if p.ListenAddr == "" {
+		return
+	}
+
+	entry, ok := m.book.Entries[p.ListenAddr]
+	if !ok {
+		if len(m.book.Entries) >= maxAddrBookSize {
+			return
+		}
+		entry = &PeerEntry{Addr: p.ListenAddr}
+		m.book.Entries[p.ListenAddr] = entry
+	}
+
+	entry.Capabilities = p.Capabilities
+	entry.LastSeen = time.Now()
+	entry.Failures = 0
+	m.dirty = true
+}
+
+/*连接失败后延长该地址的重试等待时间，失败次数过多则移出地址簿*/
+func (m *PeerManager) connectFailed(addr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.book.Entries[addr]
+	if !ok {
+		return
+	}
+
+	entry.Failures++
+	if entry.Failures >= maxFailures && !m.seeds[addr] {
+		delete(m.book.Entries, addr)
+	}
+	m.dirty = true
+}
+
+/*连接失败n次后需等待的时间*/
+func retryDelay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+
+	delay := retryBase
+	for i := 1; i < failures && delay < retryMax; i++ {
+		delay *= 2
+	}
+	if delay > retryMax {
+		delay = retryMax
+	}
+
+	return delay
+}
+
+/*出站连接不足TargetOutbound时，随机挑选可以重试的地址发起连接*/
+func (m *PeerManager) connectMore() {
+	_, outbound := m.node.countPeers()
+
+	m.mu.Lock()
+	need := m.TargetOutbound - outbound - len(m.dialing)
+
+	var candidates []*PeerEntry
+	now := time.Now()
+	for addr, entry := range m.book.Entries {
+		if addr == m.node.AdvertiseAddr || m.dialing[addr] || m.node.isConnected(addr) || m.isBanned(addr) {
+			continue
+		}
+		if now.Before(entry.LastAttempt.Add(retryDelay(entry.Failures))) {
+			continue
+		}
+		candidates = append(candidates, entry)
+	}
+
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if need < len(candidates) {
+		if need < 0 {
+			need = 0
+		}
+		candidates = candidates[:need]
+	}
+
+	for _, entry := range candidates {
+		entry.LastAttempt = now
+		m.dialing[entry.Addr] = true
+		m.dirty = true
+	}
+
+	if m.dirty {
+		m.saveFile()
+	}
+	m.mu.Unlock()
+
+	//节点停止时等待进行中的连接结束
+	for _, entry := range candidates {
+		m.node.wg.Add(1)
+		go func(addr string) {
+			defer m.node.wg.Done()
+			m.dial(addr)
+		}(entry.Addr)
+	}
+}
+
+func (m *PeerManager) dial(addr string) {
+	_, err := m.node.Connect(addr)
+
+	m.mu.Lock()
+	delete(m.dialing, addr)
+	m.mu.Unlock()
+
+	if err != nil {
+		fmt.Printf("%s is not available: %v\n", addr, err)
+		m.connectFailed(addr)
+	}
+}
+
+/*统计已握手的入站和出站连接数*/
+func (n *Node) countPeers() (int, int) {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+
+	inbound, outbound := 0, 0
+	for _, p := range n.peers {
+		if p.Inbound {
+			inbound++
+		} else {
+			outbound++
+		}
+	}
+
+	return inbound, outbound
+}
+
+func (n *Node) isConnected(addr string) bool {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+
+	_, ok := n.peers[addr]
+
+	return ok
+}
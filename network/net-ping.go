@@ -0,0 +1,240 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+//保活：每隔PingInterval向每个已握手的节点发送ping，对方以pong应答，由此测量往返时间
+//ping和pong都带有发送方的链高度和累计难度，对方的链更长时向其同步区块头，不必等到下一个区块的存证
+//发出ping后PingTimeout内没有收到应答的节点被断开，这样无需等到发送失败就能发现失联的节点
+
+const (
+	defaultPingInterval = time.Minute
+	defaultPingTimeout  = 30 * time.Second
+)
+
+const peerInfoFile = "./tmp/peerinfo_%s.data" //节点信息快照，供命令行查询，按nodeId区分
+
+//ping消息，Nonce由应答原样带回
+type Ping struct {
+	Nonce           uint64
+	Height          int
+	TotalDifficulty uint64
+}
+
+//对ping的应答
+type Pong struct {
+	Nonce           uint64
+	Height          int
+	TotalDifficulty uint64
+}
+
+//一个已握手节点的状态与统计
+type PeerInfo struct {
+	Addr            string
+	Inbound         bool
+	UserAgent       string
+	Version         uint32
+	NodeKey         []byte //加密连接中对方的身份公钥，明文连接为nil
+	BestHeight      int
+	TotalDifficulty uint64
+	PingTime        time.Duration //最近一次测得的往返时间，尚未测得时为0
+	PingWait        time.Duration //已发出但尚未应答的ping等待了多久
+	ConnectedAt     time.Time
+	LastRecv        time.Time
+	BytesSent       uint64
+	BytesRecv       uint64
+	BanScore        int
+}
+
+//节点信息快照，运行中的节点定期写入文件
+type PeerInfoSnapshot struct {
+	Updated time.Time
+	Peers   []PeerInfo
+}
+
+//方法列表
+//1.func SendPing(p *Peer, nonce uint64)
+//2.func HandlePing(p *Peer, payload []byte) error
+//3.func SendPong(p *Peer, nonce uint64)
+//4.func HandlePong(p *Peer, payload []byte) error
+//5.func (n *Node) updatePeerChain(p *Peer, height int, totalDifficulty uint64) error
+//6.func (n *Node) checkPings()
+//7.func (n *Node) pingLoop()
+//8.func (n *Node) PeerInfo() []PeerInfo
+//9.func (n *Node) savePeerInfo()
+//10.func LoadPeerInfo(nodeId string) (*PeerInfoSnapshot, error)
+
+/*向对方发送ping，附带本节点的链高度和累计难度*/
+func SendPing(p *Peer, nonce uint64) {
+	payload := GobEncode(Ping{nonce, p.chain.GetBestHeight(), p.chain.GetTotalDifficulty()})
+
+	p.Send(blockchain2.MsgTypePing, payload)
+}
+
+/*处理ping：记录对方的链高度并应答*/
+func HandlePing(p *Peer, payload []byte) error {
+	var ping Ping
+	if err := GobDecode(payload, &ping); err != nil {
+		return err
+	}
+
+	SendPong(p, ping.Nonce)
+
+	return p.node.updatePeerChain(p, ping.Height, ping.TotalDifficulty)
+}
+
+func SendPong(p *Peer, nonce uint64) {
+	payload := GobEncode(Pong{nonce, p.chain.GetBestHeight(), p.chain.GetTotalDifficulty()})
+
+	p.Send(blockchain2.MsgTypePong, payload)
+}
+
+/*处理pong：随机数与最近发出的ping相符时记录往返时间，不相符的应答忽略*/
+func HandlePong(p *Peer, payload []byte) error {
+	var pong Pong
+	if err := GobDecode(payload, &pong); err != nil {
+		return err
+	}
+
+	if p.pingNonce == 0 || pong.Nonce != p.pingNonce {
+		return nil
+	}
+	p.PingTime = time.Since(p.pingSent)
+	p.pingNonce = 0
+
+	return p.node.updatePeerChain(p, pong.Height, pong.TotalDifficulty)
+}
+
+/*更新对方报告的链高度和累计难度，对方的链更长且本节点没有在同步时向其请求区块头*/
+func (n *Node) updatePeerChain(p *Peer, height int, totalDifficulty uint64) error {
+	if height < 0 {
+		return fmt.Errorf("negative height %d", height)
+	}
+	p.BestHeight = height
+	p.TotalDifficulty = totalDifficulty
+
+	if totalDifficulty > n.chain.GetTotalDifficulty() && len(n.pendingHeaders) == 0 {
+		SendGetHeaders(p)
+	}
+
+	return nil
+}
+
+/*断开ping超时的节点，向距上次ping已超过PingInterval的节点发送新的ping*/
+//每个节点同时只有一个未应答的ping
+func (n *Node) checkPings() {
+	now := time.Now()
+
+	for _, p := range n.connectedPeers() {
+		if p.pingNonce != 0 {
+			if now.Sub(p.pingSent) > n.PingTimeout {
+				fmt.Printf("Evicting %s: no pong within %v\n", p, n.PingTimeout)
+				p.Close()
+			}
+			continue
+		}
+
+		if now.Sub(p.pingSent) >= n.PingInterval {
+			p.pingNonce = newNonce()
+			p.pingSent = now
+			SendPing(p, p.pingNonce)
+		}
+	}
+}
+
+/*循环：定期检查保活并更新节点信息快照，节点停止时删除快照*/
+func (n *Node) pingLoop() {
+	interval := n.PingInterval
+	if n.PingTimeout < interval {
+		interval = n.PingTimeout
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.quit:
+			os.Remove(fmt.Sprintf(peerInfoFile, n.NodeID))
+			return
+		}
+
+		n.handlerMu.Lock()
+		n.checkPings()
+		n.handlerMu.Unlock()
+
+		n.savePeerInfo()
+	}
+}
+
+/*返回所有已握手节点的状态与统计*/
+func (n *Node) PeerInfo() []PeerInfo {
+	n.handlerMu.Lock()
+	defer n.handlerMu.Unlock()
+
+	now := time.Now()
+	var list []PeerInfo
+	for _, p := range n.connectedPeers() {
+		info := PeerInfo{
+			Addr:            p.Addr,
+			Inbound:         p.Inbound,
+			UserAgent:       p.UserAgent,
+			Version:         p.Version,
+			NodeKey:         p.NodeKey,
+			BestHeight:      p.BestHeight,
+			TotalDifficulty: p.TotalDifficulty,
+			PingTime:        p.PingTime,
+			ConnectedAt:     p.ConnectedAt,
+			LastRecv:        time.Unix(0, atomic.LoadInt64(&p.lastRecv)),
+			BytesSent:       atomic.LoadUint64(&p.bytesSent),
+			BytesRecv:       atomic.LoadUint64(&p.bytesRecv),
+		}
+		if p.pingNonce != 0 {
+			info.PingWait = now.Sub(p.pingSent)
+		}
+		if n.peerManager != nil {
+			info.BanScore = n.peerManager.Score(p.remoteAddr())
+		}
+		list = append(list, info)
+	}
+
+	return list
+}
+
+/*将节点信息快照写入文件*/
+func (n *Node) savePeerInfo() {
+	var content bytes.Buffer
+	file := fmt.Sprintf(peerInfoFile, n.NodeID)
+
+	err := gob.NewEncoder(&content).Encode(PeerInfoSnapshot{time.Now(), n.PeerInfo()})
+	utils.Handle(err)
+
+	err = ioutil.WriteFile(file, content.Bytes(), 0644)
+	if err != nil && !os.IsNotExist(err) {
+		utils.Handle(err)
+	}
+}
+
+/*读取运行中的节点写入的节点信息快照，节点未运行时文件不存在*/
+func LoadPeerInfo(nodeId string) (*PeerInfoSnapshot, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf(peerInfoFile, nodeId))
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot PeerInfoSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
@@ -0,0 +1,92 @@
+package network
+
+import (
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+	"time"
+)
+
+//对每个节点的接收流量和消息数限速，超出速率时暂停读取该节点的消息，由TCP流量控制让对方放慢发送
+//需要等待的时间超过maxThrottleDelay说明对方持续远超限速发送，计入不良行为分数
+
+const (
+	maxBandwidth     = 1 << 20               //每个节点的接收带宽上限（字节/秒）
+	bandwidthBurst   = blockchain2.MaxMsgLen //带宽可突发的字节数，保证单条最大长度的消息不会被视为超速
+	maxMsgRate       = 100                   //每个节点每秒处理的消息数上限
+	msgRateBurst     = 500                   //可突发的消息数
+	maxThrottleDelay = 5 * time.Second
+
+	idleTimeout = 20 * time.Minute //握手后对方这么久没有发来任何消息则断开
+
+	scoreRateLimit = 10 //对方发送速率持续超出限速
+)
+
+//令牌桶，令牌以rate个每秒的速度补充，至多积攒burst个
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+//方法列表
+//1.func (b *tokenBucket) take(n float64) time.Duration
+//2.func (p *Peer) throttle(size int) error
+//3.func (p *Peer) setReadDeadline()
+
+/*取出n个令牌，令牌不足时仍然取出（令牌数变为负），返回需要等待多久才能补足*/
+func (b *tokenBucket) take(n float64) time.Duration {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+/*读取到一条size字节的消息后调用，超出限速时等待，连接在等待期间关闭则返回错误*/
+//只由读循环调用
+func (p *Peer) throttle(size int) error {
+	wait := p.bandwidth.take(float64(size))
+	if w := p.msgRate.take(1); w > wait {
+		wait = w
+	}
+	if wait == 0 {
+		return nil
+	}
+
+	if wait > maxThrottleDelay && p.connected {
+		err := fmt.Errorf("receiving faster than rate limit, throttled for %v", wait)
+		if misbehaving(p, scoreRateLimit, blockchain2.ReasonBadMessage, err) != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-p.quit:
+		return errPeerClosed
+	}
+}
+
+/*设置读取下一条消息的期限，握手前为握手超时时间，握手后为空闲超时时间*/
+func (p *Peer) setReadDeadline() {
+	timeout := idleTimeout
+	if !p.connected {
+		timeout = handshakeTimeout
+	}
+
+	p.conn.SetReadDeadline(time.Now().Add(timeout))
+}
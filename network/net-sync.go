@@ -0,0 +1,300 @@
+package network
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+	"time"
+)
+
+const (
+	maxBlocksInFlight = 16   //向每个节点同时请求的区块体个数上限
+	downloadWindow    = 1024 //只下载待同步区块头中最靠前的这么多个区块体，限制缓存的区块数
+)
+
+//以下两项测试中调小
+var (
+	blockRequestTimeout = 30 * time.Second //区块体请求超时后改向其他节点请求
+	syncInterval        = 5 * time.Second  //检查超时请求的间隔
+)
+
+//区块头请求，对方从定位器中找到双方共同的区块，返回其后的区块头
+type GetHeaders struct {
+	Locator [][]byte
+}
+
+//对GetHeaders的应答，至多blockchain2.MaxBlockHeaders个
+type Headers struct {
+	Headers []blockchain.BlockHeader
+}
+
+//一个正在下载的区块体
+type blockRequest struct {
+	peer *Peer
+	sent time.Time
+}
+
+//方法列表
+//1.func SendGetHeaders(p *Peer)
+//2.func HandleGetHeaders(p *Peer, payload []byte) error
+//3.func SendHeaders(p *Peer, headers []blockchain.BlockHeader)
+//4.func HandleHeaders(p *Peer, payload []byte) error
+//5.func (n *Node) scheduleDownloads()
+//6.func (n *Node) connectBlock(block *blockchain.Block) bool
+
+/*向对方请求本节点之后的区块头*/
+//定位器以待同步的最后一个区块头开头，这样可以接着上一批区块头继续请求
+func SendGetHeaders(p *Peer) {
+	pendingHeaders := p.node.pendingHeaders
+	locator := p.chain.GetLocator(blockchain2.MaxLocators)
+	if len(pendingHeaders) > 0 {
+		last := pendingHeaders[len(pendingHeaders)-1].Hash
+		locator = append([][]byte{last}, locator...)
+		if len(locator) > blockchain2.MaxLocators {
+			//去掉倒数第二个，保留创世区块
+			locator = append(locator[:blockchain2.MaxLocators-1], locator[len(locator)-1])
+		}
+	}
+
+	p.Send(blockchain2.MsgTypeGetHeaders, GobEncode(GetHeaders{locator}))
+}
+
+/*处理区块头请求*/
+func HandleGetHeaders(p *Peer, payload []byte) error {
+	var getHeaders GetHeaders
+	if err := GobDecode(payload, &getHeaders); err != nil {
+		return err
+	}
+
+	if len(getHeaders.Locator) > blockchain2.MaxLocators {
+		return fmt.Errorf("too many locator hashes: %d", len(getHeaders.Locator))
+	}
+
+	headers := p.chain.GetHeadersAfter(getHeaders.Locator, blockchain2.MaxBlockHeaders)
+	SendHeaders(p, headers)
+
+	return nil
+}
+
+func SendHeaders(p *Peer, headers []blockchain.BlockHeader) {
+	p.Send(blockchain2.MsgTypeHeaders, GobEncode(Headers{headers}))
+}
+
+/*处理收到的区块头：验证后加入待同步列表，安排下载区块体*/
+//区块头无效说明对方在作恶，直接封禁
+func HandleHeaders(p *Peer, payload []byte) error {
+	var msg Headers
+	if err := GobDecode(payload, &msg); err != nil {
+		return err
+	}
+
+	if len(msg.Headers) > blockchain2.MaxBlockHeaders {
+		return fmt.Errorf("too many headers: %d", len(msg.Headers))
+	}
+	if len(msg.Headers) == 0 {
+		return nil
+	}
+
+	n := p.node
+	headers, err := n.validateHeaders(msg.Headers)
+	if err != nil {
+		return misbehaving(p, banThreshold, blockchain2.ReasonBadBlockHeader, err)
+	}
+
+	n.pendingHeaders = append(n.pendingHeaders, headers...)
+
+	last := msg.Headers[len(msg.Headers)-1]
+	if last.Height > p.BestHeight {
+		p.BestHeight = last.Height
+	}
+	fmt.Printf("Received %d headers from %s, %d blocks to download\n", len(msg.Headers), p, len(n.pendingHeaders))
+
+	//对方返回了满额的区块头，说明后面还有
+	if len(msg.Headers) == blockchain2.MaxBlockHeaders {
+		SendGetHeaders(p)
+	}
+
+	n.scheduleDownloads()
+
+	return nil
+}
+
+/*验证一批区块头：工作量证明有效、依次相连且高度连续，第一个须接在已知区块之后*/
+//返回其中尚未下载的区块头
+func (n *Node) validateHeaders(headers []blockchain.BlockHeader) ([]blockchain.BlockHeader, error) {
+	first := headers[0]
+	pendingHeaders := n.pendingHeaders
+
+	//找到第一个区块头的前一区块
+	var prevHash []byte
+	var prevHeight int
+	if last := len(pendingHeaders) - 1; last >= 0 && bytes.Compare(pendingHeaders[last].Hash, first.PrevHash) == 0 {
+		prevHash, prevHeight = pendingHeaders[last].Hash, pendingHeaders[last].Height
+	} else if prev, err := n.chain.GetBlock(first.PrevHash); err == nil {
+		prevHash, prevHeight = prev.Hash, prev.Height
+	} else {
+		return nil, errors.New("headers do not connect to a known block")
+	}
+
+	pending := make(map[string]bool)
+	for _, h := range pendingHeaders {
+		pending[string(h.Hash)] = true
+	}
+
+	var fresh []blockchain.BlockHeader
+	for i := range headers {
+		h := &headers[i]
+
+		if bytes.Compare(h.PrevHash, prevHash) != 0 || h.Height != prevHeight+1 {
+			return nil, fmt.Errorf("header %x does not follow %x", h.Hash, prevHash)
+		}
+		if err := h.Validate(); err != nil {
+			return nil, fmt.Errorf("header %x: %v", h.Hash, err)
+		}
+
+		if !pending[string(h.Hash)] && !n.chain.HasBlock(h.Hash) {
+			fresh = append(fresh, *h)
+		}
+		prevHash, prevHeight = h.Hash, h.Height
+	}
+
+	return fresh, nil
+}
+
+/*为待同步区块头中尚未请求或请求超时的区块体挑选节点下载*/
+//优先选择请求数最少的节点，使下载分散到多个节点并行进行
+func (n *Node) scheduleDownloads() {
+	inFlight := make(map[*Peer]int)
+	for key, req := range n.blockRequests {
+		if req.peer.closed() || time.Since(req.sent) > blockRequestTimeout {
+			delete(n.blockRequests, key)
+			continue
+		}
+		inFlight[req.peer]++
+	}
+
+	candidates := n.connectedPeers()
+
+	for i := 0; i < len(n.pendingHeaders) && i < downloadWindow; i++ {
+		h := n.pendingHeaders[i]
+		key := string(h.Hash)
+		if n.receivedBlocks[key] != nil || n.blockRequests[key] != nil {
+			continue
+		}
+
+		var best *Peer
+		for _, peer := range candidates {
+			if peer.BestHeight < h.Height || inFlight[peer] >= maxBlocksInFlight {
+				continue
+			}
+			if best == nil || inFlight[peer] < inFlight[best] {
+				best = peer
+			}
+		}
+		if best == nil {
+			return
+		}
+
+		SendGetBlock(best, h.Hash)
+		n.blockRequests[key] = &blockRequest{best, time.Now()}
+		inFlight[best]++
+	}
+}
+
+/*按高度顺序将已下载的区块连接到链上*/
+func (n *Node) connectReceivedBlocks() {
+	for len(n.pendingHeaders) > 0 {
+		key := string(n.pendingHeaders[0].Hash)
+
+		//区块可能已经通过其他途径连接到链上
+		if n.chain.HasBlock(n.pendingHeaders[0].Hash) {
+			delete(n.receivedBlocks, key)
+			n.pendingHeaders = n.pendingHeaders[1:]
+			continue
+		}
+
+		block, ok := n.receivedBlocks[key]
+		if !ok {
+			return
+		}
+
+		n.connectBlock(block)
+		delete(n.receivedBlocks, key)
+		n.pendingHeaders = n.pendingHeaders[1:]
+	}
+}
+
+/*将区块加入区块链，区块成为新链尾时增量更新UTXO集，发生链切换时重建UTXO集，并向其他节点转发该区块的存证*/
+//发来该区块的节点已被记为知道该区块，不会再收到存证
+//区块连接后，等待它的孤块和孤立交易也会被处理。返回区块是否已在链上
+func (n *Node) connectBlock(block *blockchain.Block) bool {
+	chain := n.chain
+	if chain.HasBlock(block.Hash) {
+		return true
+	}
+
+	//交易未通过验证的区块不存入也不转发
+	if err := chain.ValidateTransactions(block); err != nil {
+		fmt.Printf("Rejected block %x: %v\n", block.Hash, err)
+		return false
+	}
+
+	oldTip := chain.LastHash
+	if err := chain.AddBlock(block); err != nil {
+		fmt.Printf("Failed to add block %x: %v\n", block.Hash, err)
+		return false
+	}
+
+	if bytes.Compare(chain.LastHash, block.Hash) == 0 {
+		UTXOSet := blockchain.UTXOSet{chain}
+		if bytes.Compare(block.PrevHash, oldTip) == 0 {
+			UTXOSet.Update(block)
+		} else {
+			UTXOSet.Reindex()
+		}
+
+		//已打包的交易移出内存池
+		for _, tx := range block.Transactions {
+			delete(n.memoryPool, hex.EncodeToString(tx.ID))
+			delete(n.stemPool, string(tx.ID))
+		}
+
+		fmt.Printf("Added block %x at height %d\n", block.Hash, block.Height)
+
+		n.relayInv(invTypeBlock, block.Hash, nil)
+	}
+
+	var txIDs [][]byte
+	for _, tx := range block.Transactions {
+		txIDs = append(txIDs, tx.ID)
+	}
+	n.processOrphanTxs(txIDs)
+	n.connectOrphanBlocks(block.Hash)
+
+	return true
+}
+
+/*定期检查超时的区块请求并重新安排下载，淘汰超时的孤块、孤立交易和致密区块，广播禁运超时的stem交易*/
+//节点停止时退出
+func (n *Node) syncLoop() {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.quit:
+			return
+		}
+
+		n.handlerMu.Lock()
+		n.scheduleDownloads()
+		n.expireOrphans()
+		n.expirePartialBlocks()
+		n.fluffExpiredStems()
+		n.handlerMu.Unlock()
+	}
+}
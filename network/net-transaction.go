@@ -1,108 +1,144 @@
 package network
 
 import (
-	"bytes"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
-	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
 )
 
 type Tx struct {
-	AddrFrom    string
 	Transaction []byte
 }
 
 //处理收到一笔交易信息
-func HandleTx(request []byte, chain *blockchain.BlockChain) {
-	//将request的command后内容解码写入payload(Tx)
-	var buff bytes.Buffer
-	var payload Tx
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	utils.Handle(err)
-
-	//将该交易存入内存池
-	txData := payload.Transaction
-	tx := blockchain.DeserializeTransaction(txData)
-	memoryPool[hex.EncodeToString(tx.ID)] = tx
-
-	fmt.Printf("%s, %d\n", nodeAddress, len(memoryPool))
-
-	//若本地节点是已知节点集第一个（已知节点集第一个初始化为本地节点）
-	//则向已知节点集中除自己和发交易给自己的节点外的所有节点发送存证，告诉大家我收到了这个交易
-	if nodeAddress == KnownNodes[0] {
-		for _, node := range KnownNodes {
-			if node != nodeAddress && node != payload.AddrFrom {
-				SendInv(node, "tx", [][]byte{tx.ID})
+//验证通过的新交易放入内存池，并转发给除来源节点外的所有节点
+//来源交易尚未上链的交易放入孤立交易池，并向对方请求本节点没有的来源交易
+func HandleTx(p *Peer, payload []byte) error {
+	//将消息体解码写入msg(Tx)
+	var msg Tx
+	if err := GobDecode(payload, &msg); err != nil {
+		return err
+	}
+
+	n := p.node
+	var tx blockchain.Transaction
+	if err := GobDecode(msg.Transaction, &tx); err != nil {
+		return misbehaving(p, banThreshold, blockchain2.ReasonBadMessage, err)
+	}
+	//交易ID须与内容相符，否则对方可以冒用其他交易的ID，使本节点忽略真正的交易
+	if !tx.VerifyID() {
+		return misbehaving(p, banThreshold, blockchain2.ReasonBadMessage, fmt.Errorf("transaction id %x does not match its content", tx.ID))
+	}
+	p.addKnown(tx.ID)
+
+	//已处理过的交易不再处理，避免转发成环
+	if n.inMemoryPool(tx.ID) || n.hasOrphanTx(tx.ID) {
+		return nil
+	}
+
+	//Coinbase交易只能由矿工在出块时创建，不能单独广播
+	if tx.IsCoinbase() {
+		fmt.Printf("Rejected coinbase transaction %x from %s\n", tx.ID, p)
+		return nil
+	}
+
+	if missing := p.chain.MissingInputs(&tx); len(missing) > 0 {
+		n.addOrphanTx(tx, p, missing)
+		for _, id := range missing {
+			if !n.inMemoryPool(id) && !n.hasOrphanTx(id) {
+				SendGetData(p, invTypeTx, id)
 			}
 		}
-	} else { //内存池有至少两个交易且挖矿节点地址被设定，进行MineTx
-		if len(memoryPool) >= 2 && len(mineAddress) > 0 {
-			MineTx(chain)
-		}
+		return nil
+	}
+
+	if !p.chain.VerifyTransaction(&tx) {
+		fmt.Printf("Rejected invalid transaction %x from %s\n", tx.ID, p)
+		return nil
+	}
+
+	n.acceptTx(tx, p)
+
+	//内存池有至少两个交易且挖矿节点地址被设定，进行mineTx
+	if len(n.memoryPool) >= 2 && len(n.MinerAddress) > 0 {
+		n.mineTx()
 	}
+
+	return nil
 }
 
-/*挖矿，将本地交易打包发布*/
-func MineTx(chain *blockchain.BlockChain) {
-	var txs []*blockchain.Transaction
+/*将验证通过的交易放入内存池，并转发给除来源节点外的所有节点，stem池中的同一交易随之结束stem阶段*/
+func (n *Node) acceptTx(tx blockchain.Transaction, from *Peer) {
+	n.memoryPool[hex.EncodeToString(tx.ID)] = tx
+	delete(n.stemPool, string(tx.ID))
+
+	fmt.Printf("%s, %d\n", n.AdvertiseAddr, len(n.memoryPool))
 
-	//从内存池（记忆池）中遍历交易，交易符合规则的加入待出块交易集合
+	n.relayInv(invTypeTx, tx.ID, from)
+}
+
+/*挖矿，将本地交易打包发布，调用者须持有handlerMu*/
+func (n *Node) mineTx() {
+	chain := n.chain
+	memoryPool := n.memoryPool
+	//挖矿者在出块时自行创建Coinbase交易，数据域可以自行指定，若为空则随机字符串
+	//Coinbase交易必须是区块的第一笔交易
+	cbTx := blockchain.CoinbaseTx(n.MinerAddress, "")
+	txs := []*blockchain.Transaction{cbTx}
+
+	//从内存池（记忆池）中遍历交易，与已选交易一起能通过区块验证的加入待出块交易集合
 	for id := range memoryPool {
 		fmt.Printf("tx: %s\n", memoryPool[id].ID)
 		tx := memoryPool[id]
-		if chain.VerifyTransaction(&tx) {
-			txs = append(txs, &tx)
+		candidate := append(txs, &tx)
+		if err := chain.ValidateTransactions(&blockchain.Block{PrevHash: chain.LastHash, Transactions: candidate}); err != nil {
+			//来源输出已被链上或已选交易花费等原因导致失效的交易移出内存池
+			delete(memoryPool, id)
+			continue
 		}
+		txs = candidate
 	}
 
-	//若待出块交易集合长度为0，说明内存池所有交易均无效
-	if len(txs) == 0 {
+	//若待出块交易集合只有Coinbase交易，说明内存池所有交易均无效
+	if len(txs) == 1 {
 		fmt.Println("All Transaction are invalid")
 		return
 	}
 
-	//挖矿者在出块时自行创建Coinbase交易，数据域可以自行指定，若为空则随机字符串
-	cbTx := blockchain.CoinbaseTx(mineAddress, "")
-	txs = append(txs, cbTx)
-
 	//新区块
 	newBlock := chain.MineBlock(txs)
 	UTXOSet := blockchain.UTXOSet{chain}
-	UTXOSet.Reindex()
+	UTXOSet.Update(newBlock)
 
 	fmt.Println("New Block Mined")
 
+	//向所有已连接节点发送出块存证（告诉别人我挖出矿了）
+	n.relayInv(invTypeBlock, newBlock.Hash, nil)
+
 	//在出块之后，删除内存池中已放入待出块交易集合的交易
+	var minedIDs [][]byte
 	for _, tx := range txs {
 		txID := hex.EncodeToString(tx.ID)
 		delete(memoryPool, txID)
+		minedIDs = append(minedIDs, tx.ID)
 	}
 
-	//向已知节点集中除了本机外的节点发送出块存证（告诉别人我挖出矿了）
-	for _, node := range KnownNodes {
-		if node != nodeAddress {
-			SendInv(node, "block", [][]byte{newBlock.Hash})
-		}
-	}
+	//以这些交易为来源交易的孤立交易现在可以放入内存池
+	n.processOrphanTxs(minedIDs)
 
-	//递归调用，如果内存池不为空，继续MineTx
+	//递归调用，如果内存池不为空，继续mineTx
 	if len(memoryPool) > 0 {
-		MineTx(chain)
+		n.mineTx()
 	}
 
 	//注意：比如说比特币，设置出块时间约15分钟，则区块内交易量是挖矿者打包区块之前收了多少算多少
 }
 
-/*向某一地址发送交易数据*/
-func SendTx(addr string, tx *blockchain.Transaction) {
-	data := Tx{nodeAddress, tx.Serialize()}
-	payload := GobEncode(data)
-	request := append(CmdToBytes("tx"), payload...)
+/*向某节点发送交易数据*/
+func SendTx(p *Peer, tx *blockchain.Transaction) {
+	p.addKnown(tx.ID)
+	payload := GobEncode(Tx{tx.Serialize()})
 
-	SendData(addr, request)
+	p.Send(blockchain2.MsgTypeTransaction, payload)
 }
@@ -0,0 +1,24 @@
+package network
+
+import (
+	"net"
+	"time"
+)
+
+//节点之间建立连接的方式，默认使用TCP
+//测试中可以替换为在内存中模拟的网络，从而在一个进程中运行多个节点
+type Transport interface {
+	Listen(addr string) (net.Listener, error)
+	Dial(addr string, timeout time.Duration) (net.Conn, error)
+}
+
+//TCP连接
+type tcpTransport struct{}
+
+func (tcpTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen(protocol, addr)
+}
+
+func (tcpTransport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(protocol, addr, timeout)
+}
@@ -1,34 +1,91 @@
 package network
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
 	"github.com/azd1997/golang-MimbleWimble-try/utils"
-	"io/ioutil"
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
 	"net"
-	"os"
-	"runtime"
-
-	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
-	DEATH "github.com/vrecan/death"
-	SYS "syscall"
+	"sync"
+	"time"
 )
 
 //此处节点的意义是区块链网络中的客户端节点IP地址，形如“IP:PORT”
 //更贴切的称呼是peer
 
 const (
-	protocol      = "tcp"
-	version       = 1
-	commandLength = 12
+	protocol = "tcp"
 )
 
-var (
-	nodeAddress    string
-	mineAddress    string
-	KnownNodes     = []string{"localhost:3000"}
-	blockInTransit [][]byte
-	memoryPool     = make(map[string]blockchain.Transaction)
-)
+//默认的种子节点，地址簿为空时从这里开始发现其他节点
+var DefaultSeedNodes = []string{"localhost:3000"}
+
+var errNodeStopped = errors.New("node is stopped")
+
+//节点配置
+type Config struct {
+	NodeID        string    //节点编号，用于区分地址簿等数据文件
+	ListenAddr    string    //监听地址，形如"IP:PORT"，为空则不接受连接
+	AdvertiseAddr string    //告知其他节点的本节点地址，为空时与ListenAddr相同
+	MinerAddress  string    //出块奖励地址，为空则不挖矿
+	SeedNodes     []string  //种子节点
+	Transport     Transport //建立连接的方式，为nil时使用TCP
+	Dandelion     DandelionConfig
+
+	Encryption EncryptionMode    //节点之间的连接是否加密
+	NodeKey    *ecdsa.PrivateKey //加密连接中证明本节点身份的静态密钥，为nil时每次启动生成临时密钥
+
+	PingInterval time.Duration //向每个节点发送ping的间隔，为0时使用默认值
+	PingTimeout  time.Duration //ping超过这么久没有应答则断开，为0时使用默认值
+}
+
+//区块链网络中的一个节点，持有连接、内存池和同步状态
+//同一进程中可以运行多个节点，各自使用注入的区块链和钱包
+type Node struct {
+	Config
+
+	chain   *blockchain.BlockChain
+	wallets *wallet.Wallets //为nil时不维护钱包账本
+	ledger  *wallet.Ledger
+
+	peerManager *PeerManager //Start时创建，只发起连接而不启动的节点（如命令行工具）为nil
+	listener    net.Listener
+	started     bool
+	quit        chan struct{}
+	wg          sync.WaitGroup
+
+	peers        map[string]*Peer //已建立连接的节点，出站连接以连接的地址为键，入站连接以实际的对端地址为键
+	inboundConns int              //入站连接数，包括尚未完成握手的
+	peersMu      sync.Mutex
+
+	sentNonces   map[uint64]bool //本节点发出的Hand中的随机数，用于发现连向自己的连接
+	sentNoncesMu sync.Mutex
+
+	//各节点的读循环并发处理消息，用handlerMu保证同一时刻只处理一条消息，以下状态只在持有handlerMu时访问
+	handlerMu  sync.Mutex
+	memoryPool map[string]blockchain.Transaction
+	stemPool   map[string]*stemTx //stem阶段的交易，交易ID -> 交易
+	dandelion  dandelionState
+
+	//区块同步状态
+	pendingHeaders []blockchain.BlockHeader     //已验证、等待连接到链上的区块头，按高度升序
+	blockRequests  map[string]*blockRequest     //区块哈希 -> 请求
+	receivedBlocks map[string]*blockchain.Block //已下载、等待前一区块连接后再连接的区块
+	partialBlocks  map[string]*partialBlock     //等待缺失交易的致密区块，以区块哈希为键
+
+	//孤块池与孤立交易池，以缺失的父区块哈希、父交易ID为键
+	//乱序到达的区块和交易先放在池中，父区块或父交易到达后再连接，超时或池满时淘汰最早加入的
+	orphanBlocks       map[string]*orphanBlock   //区块哈希 -> 孤块
+	orphanBlocksByPrev map[string][]*orphanBlock //缺失的前一区块哈希 -> 孤块
+	orphanTxs          map[string]*orphanTx      //交易ID -> 孤立交易
+	orphanTxsByMissing map[string][]*orphanTx    //缺失的来源交易ID -> 孤立交易
+}
 
 //流程
 //1.create Blockchain
@@ -40,95 +97,285 @@ var (
 //7.block sent to central node	//
 //8.wallet syncs and verifies
 
-/*处理连接，对请求做出处理*/
-func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
-	//读取request
-	req, err := ioutil.ReadAll(conn)
-	defer conn.Close()
+//方法列表
+//1.func NewNode(cfg Config, chain *blockchain.BlockChain, wallets *wallet.Wallets) *Node
+//2.func (n *Node) Start(ctx context.Context) error
+//3.func (n *Node) Stop(ctx context.Context) error
+//4.func (n *Node) Chain() *blockchain.BlockChain
+//5.func (n *Node) Addr() string
+//6.func (n *Node) PublicKey() []byte
 
-	utils.Handle(err)
-
-	//从request获取command
-	command := BytesToCmd(req[:commandLength])
-	fmt.Printf("Received %s command\n", command)
+/*创建节点，chain为节点使用的区块链，wallets为节点的钱包（可为nil）*/
+func NewNode(cfg Config, chain *blockchain.BlockChain, wallets *wallet.Wallets) *Node {
+	if cfg.AdvertiseAddr == "" {
+		cfg.AdvertiseAddr = cfg.ListenAddr
+	}
+	if cfg.Transport == nil {
+		cfg.Transport = tcpTransport{}
+	}
+	cfg.Dandelion.setDefaults()
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = defaultPingInterval
+	}
+	if cfg.PingTimeout <= 0 {
+		cfg.PingTimeout = defaultPingTimeout
+	}
+	if cfg.Encryption != EncryptionOff {
+		if cfg.NodeKey == nil {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			utils.Handle(err)
+			cfg.NodeKey = key
+		}
+		cfg.Transport = newSecureTransport(cfg.Transport, cfg.NodeKey, cfg.Encryption, cfg.NodeID)
+	}
 
-	//对命令作出对应处理
-	switch command {
-	case "addr":
-		HandleAddr(req)
-	case "block":
-		HandleBlock(req, chain)
-	case "inv":
-		HandleInv(req, chain)
-	case "getblocks":
-		HandleGetBlocks(req, chain)
-	case "getdata":
-		HandleGetData(req, chain)
-	case "tx":
-		HandleTx(req, chain)
-	case "version":
-		HandleVersion(req, chain)
+	n := &Node{
+		Config:             cfg,
+		chain:              chain,
+		wallets:            wallets,
+		quit:               make(chan struct{}),
+		peers:              make(map[string]*Peer),
+		sentNonces:         make(map[uint64]bool),
+		memoryPool:         make(map[string]blockchain.Transaction),
+		stemPool:           make(map[string]*stemTx),
+		blockRequests:      make(map[string]*blockRequest),
+		receivedBlocks:     make(map[string]*blockchain.Block),
+		partialBlocks:      make(map[string]*partialBlock),
+		orphanBlocks:       make(map[string]*orphanBlock),
+		orphanBlocksByPrev: make(map[string][]*orphanBlock),
+		orphanTxs:          make(map[string]*orphanTx),
+		orphanTxsByMissing: make(map[string][]*orphanTx),
+	}
 
-	default:
-		fmt.Println("Unknown command")
+	//链尾更新时增量同步本节点的钱包账本
+	if wallets != nil {
+		n.ledger = wallet.CreateLedger(cfg.NodeID)
+		chain.OnNewBlock(func(block *blockchain.Block) {
+			chain.SyncLedger(n.ledger, wallets.GetPubKeyHashes())
+			n.ledger.SaveFile(cfg.NodeID)
+		})
 	}
+
+	return n
 }
 
-/*开启服务器，监听请求并跳转至处理连接方法*/
-func StartServer(nodeID, minerAddress string) {
-	//nodeID为进程端口号，字符串打印且输出为本地节点的地址nodeAddress(库全局变量)
-	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
-	//mineAddress(库全局变量)被赋值
-	mineAddress = minerAddress
+/*启动节点：开始监听，由节点管理器从地址簿和种子节点中挑选节点建立连接，并定期检查区块同步和保活*/
+//握手后若对方的链更长，本节点会向其请求区块。ctx只用于启动过程，节点运行到Stop被调用为止
+func (n *Node) Start(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if n.started {
+		return errors.New("node is already started")
+	}
+	n.started = true
+
+	if n.ListenAddr != "" {
+		ln, err := n.Transport.Listen(n.ListenAddr)
+		if err != nil {
+			return err
+		}
+		n.listener = ln
+
+		//监听端口为0时由系统分配端口，告知其他节点实际的地址
+		if _, port, err := net.SplitHostPort(n.AdvertiseAddr); err == nil && port == "0" {
+			n.AdvertiseAddr = ln.Addr().String()
+		}
+	}
 
-	//本地节点开启监听
-	ln, err := net.Listen(protocol, nodeAddress)
-	utils.Handle(err)
-	defer ln.Close()
+	n.peerManager = NewPeerManager(n)
 
-	//打开数据库获取区块链对象，关闭数据库
-	//TODO:ContinueB...函数暂时不需要address参数
-	chain := blockchain.ContinueBlockChain(nodeID)
-	defer chain.Db.Close()
-	go CloseDB(chain)
+	n.wg.Add(3)
+	go func() {
+		defer n.wg.Done()
+		n.peerManager.Run()
+	}()
+	go func() {
+		defer n.wg.Done()
+		n.syncLoop()
+	}()
+	go func() {
+		defer n.wg.Done()
+		n.pingLoop()
+	}()
 
-	//如果本地节点不是已知节点第一个节点，那么发送向已知节点集第一个节点发送版本
-	//TODO
-	if nodeAddress != KnownNodes[0] {
-		SendVersion(KnownNodes[0], chain)
+	if n.listener != nil {
+		n.wg.Add(1)
+		go n.acceptLoop()
 	}
 
-	//循环：接受请求，处理连接
+	return nil
+}
+
+/*循环：接受连接，为每个连接启动读写循环，握手完成后才登记该节点*/
+func (n *Node) acceptLoop() {
+	defer n.wg.Done()
+
 	for {
-		conn, err := ln.Accept()
-		utils.Handle(err)
-		go HandleConnection(conn, chain)
+		conn, err := n.listener.Accept()
+		if err != nil {
+			select {
+			case <-n.quit:
+			default:
+				fmt.Printf("Stopped accepting connections: %v\n", err)
+			}
+			return
+		}
+
+		if !n.peerManager.AcceptInbound() {
+			conn.Close()
+			continue
+		}
+		n.newPeer(conn, conn.RemoteAddr().String(), true)
 	}
 }
 
-/*检查某节点是否在已知节点集合中*/
-func NodeIsKnown(addr string) bool {
-	for _, node := range KnownNodes {
-		if node == addr {
-			return true
-		}
+/*停止节点：不再接受连接，断开所有节点（发送队列中的消息会先写出），保存地址簿*/
+//ctx到期时不再等待连接关闭，返回ctx的错误
+func (n *Node) Stop(ctx context.Context) error {
+	//持有peersMu关闭quit，此后newPeer不会再创建新的连接
+	n.peersMu.Lock()
+	if n.stopped() {
+		n.peersMu.Unlock()
+		return errNodeStopped
+	}
+	close(n.quit)
+	n.peersMu.Unlock()
+
+	if n.listener != nil {
+		n.listener.Close()
+	}
+	if n.peerManager != nil {
+		n.peerManager.Stop()
+	}
+
+	//各连接的写循环发现节点停止后自行关闭连接
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	return false
+/*节点使用的区块链*/
+func (n *Node) Chain() *blockchain.BlockChain {
+	return n.chain
 }
 
-/*关闭数据库*/
-func CloseDB(chain *blockchain.BlockChain) {
+/*告知其他节点的本节点地址*/
+func (n *Node) Addr() string {
+	return n.AdvertiseAddr
+}
 
-	//SIGINT标识中断信号；SIGTERM标识进程终结
-	//当产生中断时，返回death对象
-	d := DEATH.NewDeath(SYS.SIGINT, SYS.SIGTERM, os.Interrupt)
+/*本节点的身份公钥（未压缩格式），不加密连接时为nil*/
+func (n *Node) PublicKey() []byte {
+	if n.NodeKey == nil {
+		return nil
+	}
+
+	return marshalPubKey(&n.NodeKey.PublicKey)
+}
 
-	//保证进程结束时关闭数据库
-	d.WaitForDeathWithFunc(func() {
-		defer os.Exit(1)
-		defer runtime.Goexit()
-		chain.Db.Close()
-	})
+/*节点是否已停止*/
+func (n *Node) stopped() bool {
+	select {
+	case <-n.quit:
+		return true
+	default:
+		return false
+	}
 }
 
+/*处理一条消息，返回错误说明对方发来了无法处理的消息*/
+func (n *Node) handleMessage(p *Peer, msgType uint8, payload []byte) error {
+	n.handlerMu.Lock()
+	defer n.handlerMu.Unlock()
+
+	fmt.Printf("Received %s command from %s\n", MsgTypeName(msgType), p)
+
+	//对命令作出对应处理
+	switch msgType {
+	case blockchain2.MsgTypeGetPeerAddrs:
+		return HandleGetPeerAddrs(p, payload)
+	case blockchain2.MsgTypePeerAddrs:
+		return HandlePeerAddrs(p, payload)
+	case blockchain2.MsgTypeBanReason:
+		return HandleBanReason(p, payload)
+	case blockchain2.MsgTypeGetHeaders:
+		return HandleGetHeaders(p, payload)
+	case blockchain2.MsgTypeHeaders:
+		return HandleHeaders(p, payload)
+	case blockchain2.MsgTypeGetBlock:
+		return HandleGetBlock(p, payload)
+	case blockchain2.MsgTypeBlock:
+		return HandleBlock(p, payload)
+	case blockchain2.MsgTypeGetCompactBlock:
+		return HandleGetCompactBlock(p, payload)
+	case blockchain2.MsgTypeCompactBlock:
+		return HandleCompactBlock(p, payload)
+	case MsgTypeGetBlockTxs:
+		return HandleGetBlockTxs(p, payload)
+	case MsgTypeBlockTxs:
+		return HandleBlockTxs(p, payload)
+	case MsgTypeInv:
+		return HandleInv(p, payload)
+	case MsgTypeGetData:
+		return HandleGetData(p, payload)
+	case blockchain2.MsgTypeTransaction:
+		return HandleTx(p, payload)
+	case blockchain2.MsgTypeStemTransaction:
+		return HandleStemTx(p, payload)
+	case blockchain2.MsgTypePing:
+		return HandlePing(p, payload)
+	case blockchain2.MsgTypePong:
+		return HandlePong(p, payload)
+	case blockchain2.MsgTypeError:
+		return HandleError(p, payload)
+	case blockchain2.MsgTypeHand, blockchain2.MsgTypeShake:
+		return fmt.Errorf("unexpected %s message after handshake", MsgTypeName(msgType))
+
+	default:
+		fmt.Println("Unknown command")
+	}
+
+	return nil
+}
+
+/*与节点握手完成后，记入地址簿，若对方的链更长则向其请求区块头*/
+func (n *Node) handlePeerConnected(p *Peer) {
+	n.handlerMu.Lock()
+	defer n.handlerMu.Unlock()
+
+	if n.peerManager != nil {
+		n.peerManager.peerConnected(p)
+
+		//从能提供节点列表的节点处发现更多节点
+		if p.Capabilities&blockchain2.CapPeerList != 0 {
+			SendGetPeerAddrs(p, blockchain2.CapUnknown)
+		}
+	}
+
+	if p.BestHeight > n.chain.GetBestHeight() {
+		SendGetHeaders(p)
+	}
+}
+
+/*返回所有已握手的节点*/
+func (n *Node) connectedPeers() []*Peer {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+
+	list := make([]*Peer, 0, len(n.peers))
+	for _, p := range n.peers {
+		list = append(list, p)
+	}
+
+	return list
+}
@@ -0,0 +1,782 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain2"
+)
+
+const convergeTimeout = 30 * time.Second
+
+//握手完成后双方都登记了对方，且以监听地址为键
+func TestHandshake(t *testing.T) {
+	h := newSimHarness(t, 2)
+	defer h.close()
+
+	h.connect(0, 1)
+
+	h.waitFor("handshake", convergeTimeout, func() bool {
+		return h.nodes[0].isConnected(h.nodes[1].Addr()) && h.nodes[1].isConnected(h.nodes[0].Addr())
+	})
+
+	//连向自己的连接被拒绝
+	if _, err := h.nodes[0].Connect(h.nodes[0].Addr()); err == nil {
+		t.Fatal("connecting to self succeeded")
+	}
+}
+
+//区块沿链状拓扑逐跳转发，没有直接相连的节点也能收到
+func TestBlockRelay(t *testing.T) {
+	h := newSimHarness(t, 4)
+	defer h.close()
+	h.net.SetLatency(20 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		h.connect(i, i+1)
+	}
+
+	for i := 0; i < 3; i++ {
+		h.mine(0)
+	}
+	h.waitConverged(0, convergeTimeout)
+
+	if _, height := h.tip(3); height != 3 {
+		t.Fatalf("height %d, want 3", height)
+	}
+}
+
+//新连接的节点从对方同步区块
+func TestSyncOnConnect(t *testing.T) {
+	h := newSimHarness(t, 2)
+	defer h.close()
+
+	for i := 0; i < 5; i++ {
+		h.mine(0)
+	}
+	h.connect(1, 0)
+	h.waitConverged(0, convergeTimeout)
+}
+
+//交易被转发到所有节点的内存池，打包后从内存池中移除
+func TestMempoolRelay(t *testing.T) {
+	h := newSimHarness(t, 3)
+	defer h.close()
+
+	h.connect(0, 1)
+	h.connect(1, 2)
+
+	tx := h.send(0, string(h.wallet.Address()), 10)
+	h.waitFor("transaction relay", convergeTimeout, func() bool {
+		return h.hasTx(1, tx.ID) && h.hasTx(2, tx.ID)
+	})
+
+	block := h.mine(2)
+	h.waitConverged(2, convergeTimeout)
+
+	found := false
+	for _, blockTx := range block.Transactions {
+		found = found || bytes.Equal(blockTx.ID, tx.ID)
+	}
+	if !found {
+		t.Fatal("mined block does not contain the relayed transaction")
+	}
+	h.waitFor("mempool cleanup", convergeTimeout, func() bool {
+		return !h.hasTx(0, tx.ID) && !h.hasTx(1, tx.ID)
+	})
+}
+
+//分区期间两边各自出块，恢复后较短一边切换到较长的链
+func TestPartitionReorg(t *testing.T) {
+	h := newSimHarness(t, 3)
+	defer h.close()
+
+	h.connect(0, 1)
+	h.connect(1, 2)
+	h.mine(0)
+	h.waitConverged(0, convergeTimeout)
+
+	h.net.Partition([]string{h.nodes[0].Addr()}, []string{h.nodes[1].Addr(), h.nodes[2].Addr()})
+	for i := 0; i < 2; i++ {
+		h.mine(0)
+	}
+	for i := 0; i < 4; i++ {
+		h.mine(2)
+	}
+	h.waitFor("partition to converge", convergeTimeout, func() bool {
+		a, _ := h.tip(1)
+		b, _ := h.tip(2)
+		return bytes.Equal(a, b)
+	})
+
+	//分区期间双方互发的消息都已丢失，原有连接上不会再有消息促使节点0同步
+	//恢复后节点2主动连接节点0，握手时节点0发现对方的链更长，立即同步
+	h.net.Heal()
+	h.connect(2, 0)
+	h.mine(2)
+	h.waitConverged(2, convergeTimeout)
+
+	if _, height := h.tip(0); height != 6 {
+		t.Fatalf("height %d after reorg, want 6", height)
+	}
+}
+
+//丢包时错过的区块在之后的区块到达时通过同步区块头补齐
+func TestLossyLink(t *testing.T) {
+	h := newSimHarness(t, 2)
+	defer h.close()
+
+	h.connect(0, 1)
+
+	h.net.SetLoss(0.5)
+	for i := 0; i < 5; i++ {
+		h.mine(0)
+	}
+	time.Sleep(time.Second)
+
+	h.net.SetLoss(0)
+	h.mine(0)
+	//丢包期间丢失的区块请求要等超时后才会重新发出
+	h.waitConverged(0, blockRequestTimeout+syncInterval+convergeTimeout)
+}
+
+//对方内存池中已有区块里的交易时，区块以致密区块转发并在本地还原，不需要下载完整区块
+func TestCompactBlockRelay(t *testing.T) {
+	h := newSimHarness(t, 2)
+	defer h.close()
+
+	h.connect(0, 1)
+
+	tx := h.send(0, string(h.wallet.Address()), 10)
+	h.waitFor("transaction relay", convergeTimeout, func() bool {
+		return h.hasTx(1, tx.ID)
+	})
+
+	h.mine(0)
+	h.waitConverged(0, convergeTimeout)
+
+	if got := h.net.Sent(blockchain2.MsgTypeCompactBlock); got != 1 {
+		t.Fatalf("%d compact blocks sent, want 1", got)
+	}
+	if got := h.net.Sent(MsgTypeGetBlockTxs); got != 0 {
+		t.Fatalf("%d missing transaction requests sent, want 0", got)
+	}
+	if got := h.net.Sent(blockchain2.MsgTypeBlock); got != 0 {
+		t.Fatalf("%d full blocks sent, want 0", got)
+	}
+}
+
+//对方内存池中缺少的交易通过getblocktxs补齐
+func TestCompactBlockMissingTx(t *testing.T) {
+	h := newSimHarness(t, 2)
+	defer h.close()
+
+	h.connect(0, 1)
+	h.waitFor("handshake", convergeTimeout, func() bool {
+		return h.nodes[0].isConnected(h.nodes[1].Addr())
+	})
+
+	//交易只进入节点0的内存池，不转发
+	n := h.nodes[0]
+	n.handlerMu.Lock()
+	UTXOSet := blockchain.UTXOSet{n.chain}
+	tx := blockchain.NewTransaction(h.wallet, string(h.wallet.Address()), 10, &UTXOSet)
+	n.memoryPool[hex.EncodeToString(tx.ID)] = *tx
+	n.handlerMu.Unlock()
+
+	h.mine(0)
+	h.waitConverged(0, convergeTimeout)
+
+	if got := h.net.Sent(MsgTypeBlockTxs); got != 1 {
+		t.Fatalf("%d missing transaction replies sent, want 1", got)
+	}
+	if got := h.net.Sent(blockchain2.MsgTypeBlock); got != 0 {
+		t.Fatalf("%d full blocks sent, want 0", got)
+	}
+}
+
+//没有交易或不以附带的Coinbase交易开头的致密区块不会使节点崩溃，发送者被封禁
+func TestBadCompactBlock(t *testing.T) {
+	cases := map[string]func(valid CompactBlock, tx *blockchain.Transaction) CompactBlock{
+		"empty": func(valid CompactBlock, tx *blockchain.Transaction) CompactBlock {
+			return CompactBlock{Header: valid.Header}
+		},
+		"no coinbase": func(valid CompactBlock, tx *blockchain.Transaction) CompactBlock {
+			return CompactBlock{Header: valid.Header, ShortIDs: valid.ShortIDs}
+		},
+		"coinbase not first": func(valid CompactBlock, tx *blockchain.Transaction) CompactBlock {
+			return CompactBlock{Header: valid.Header, Prefilled: []PrefilledTx{{0, tx.Serialize()}}, ShortIDs: valid.ShortIDs}
+		},
+	}
+
+	for name, corrupt := range cases {
+		func() {
+			h := newSimHarness(t, 2)
+			defer h.close()
+
+			p, err := h.nodes[0].Connect(h.nodes[1].Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n := h.nodes[0]
+			n.handlerMu.Lock()
+			UTXOSet := blockchain.UTXOSet{n.chain}
+			tx := blockchain.NewTransaction(h.wallet, string(h.wallet.Address()), 10, &UTXOSet)
+			cbTx := blockchain.CoinbaseTx(string(h.wallet.Address()), "")
+			block := blockchain.CreateBlock([]*blockchain.Transaction{cbTx, tx}, n.chain.LastHash, n.chain.GetBestHeight()+1)
+			n.handlerMu.Unlock()
+			p.Send(blockchain2.MsgTypeCompactBlock, GobEncode(corrupt(NewCompactBlock(block), tx)))
+
+			h.waitFor(name+" ban", convergeTimeout, func() bool {
+				return h.nodes[1].peerManager.IsBanned(h.nodes[0].Addr())
+			})
+		}()
+	}
+}
+
+//交易沿出站连接逐跳stem转发，到达没有出站连接的节点后被广播到所有节点
+func TestDandelionStem(t *testing.T) {
+	h := newSimHarnessWithConfig(t, 3, func(cfg *Config) {
+		cfg.Dandelion.StemProbability = 100
+		cfg.Dandelion.Embargo = time.Minute
+	})
+	defer h.close()
+
+	h.connect(0, 1)
+	h.connect(1, 2)
+	h.waitFor("handshake", convergeTimeout, func() bool {
+		return h.nodes[0].isConnected(h.nodes[1].Addr()) && h.nodes[1].isConnected(h.nodes[2].Addr())
+	})
+
+	UTXOSet := blockchain.UTXOSet{h.nodes[0].chain}
+	tx := blockchain.NewTransaction(h.wallet, string(h.wallet.Address()), 10, &UTXOSet)
+	h.nodes[0].SubmitTx(tx)
+
+	h.waitFor("transaction fluff", convergeTimeout, func() bool {
+		return h.hasTx(0, tx.ID) && h.hasTx(1, tx.ID) && h.hasTx(2, tx.ID)
+	})
+
+	if got := h.net.Sent(blockchain2.MsgTypeStemTransaction); got != 2 {
+		t.Fatalf("%d stem transactions sent, want 2", got)
+	}
+	for i, n := range h.nodes {
+		n.handlerMu.Lock()
+		stems := len(n.stemPool)
+		n.handlerMu.Unlock()
+		if stems != 0 {
+			t.Fatalf("node %d has %d transactions left in stem pool", i, stems)
+		}
+	}
+}
+
+//stem交易在下一跳丢失时，禁运超时后由本节点广播
+func TestDandelionEmbargo(t *testing.T) {
+	h := newSimHarnessWithConfig(t, 2, func(cfg *Config) {
+		cfg.Dandelion.StemProbability = 100
+		cfg.Dandelion.Embargo = time.Second
+	})
+	defer h.close()
+
+	h.connect(0, 1)
+	h.waitFor("handshake", convergeTimeout, func() bool {
+		return h.nodes[0].isConnected(h.nodes[1].Addr())
+	})
+
+	UTXOSet := blockchain.UTXOSet{h.nodes[0].chain}
+	tx := blockchain.NewTransaction(h.wallet, string(h.wallet.Address()), 10, &UTXOSet)
+	//消息由写循环异步发出，等待一段时间确保stem交易被丢弃
+	h.net.SetLoss(1)
+	h.nodes[0].SubmitTx(tx)
+	time.Sleep(200 * time.Millisecond)
+	h.net.SetLoss(0)
+
+	if h.hasTx(0, tx.ID) {
+		t.Fatal("transaction fluffed before embargo expired")
+	}
+	h.waitFor("embargo fluff", convergeTimeout, func() bool {
+		return h.hasTx(0, tx.ID) && h.hasTx(1, tx.ID)
+	})
+}
+
+//交易数最多的区块对应的致密区块和缺失交易请求不超过各自的长度上限
+func TestCompactBlockPayloadLimits(t *testing.T) {
+	header := blockchain.BlockHeader{Hash: make([]byte, 32), PrevHash: make([]byte, 32), MerkleRoot: make([]byte, 32)}
+	cb := CompactBlock{Header: header, Prefilled: []PrefilledTx{{0, make([]byte, maxTxLen-smallMsgLen)}}}
+	var indexes []int
+	for i := 1; uint64(i) < maxBlockTxs; i++ {
+		cb.ShortIDs = append(cb.ShortIDs, make([]byte, blockchain2.ShortIDSize))
+		indexes = append(indexes, i)
+	}
+
+	if l := uint64(len(GobEncode(cb))); l > MaxPayloadLen(blockchain2.MsgTypeCompactBlock) {
+		t.Errorf("compact block of %d bytes exceeds limit %d", l, MaxPayloadLen(blockchain2.MsgTypeCompactBlock))
+	}
+	if l := uint64(len(GobEncode(GetBlockTxs{header.Hash, indexes}))); l > MaxPayloadLen(MsgTypeGetBlockTxs) {
+		t.Errorf("getblocktxs of %d bytes exceeds limit %d", l, MaxPayloadLen(MsgTypeGetBlockTxs))
+	}
+	for _, msgType := range []uint8{blockchain2.MsgTypeCompactBlock, MsgTypeGetBlockTxs} {
+		if MaxPayloadLen(msgType) >= blockchain2.MaxMsgLen {
+			t.Errorf("%s is not limited below the block size", MsgTypeName(msgType))
+		}
+	}
+}
+
+//发送超过该类型长度上限的消息的节点被封禁，消息体不会被读取
+func TestOversizedMessageBan(t *testing.T) {
+	h := newSimHarness(t, 2)
+	defer h.close()
+
+	p, err := h.nodes[0].Connect(h.nodes[1].Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.waitFor("handshake", convergeTimeout, func() bool {
+		return h.nodes[1].isConnected(h.nodes[0].Addr())
+	})
+
+	frame := make([]byte, blockchain2.HeaderLen)
+	copy(frame, blockchain2.MagicCode[:])
+	frame[2] = blockchain2.MsgTypeTransaction
+	binary.BigEndian.PutUint64(frame[3:], MaxPayloadLen(blockchain2.MsgTypeTransaction)+1)
+	if _, err := p.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	h.waitFor("ban", convergeTimeout, func() bool {
+		return h.nodes[1].peerManager.IsBanned(h.nodes[0].Addr())
+	})
+}
+
+//入站连接以实际的对端地址登记，声称别的节点的监听地址不能顶替或连累该节点
+func TestSpoofedListenAddr(t *testing.T) {
+	i := 0
+	h := newSimHarnessWithConfig(t, 3, func(cfg *Config) {
+		if i == 2 {
+			cfg.AdvertiseAddr = "sim0:3000"
+		}
+		i++
+	})
+	defer h.close()
+
+	h.connect(0, 1)
+	h.connect(2, 1)
+
+	n := h.nodes[1]
+	h.waitFor("handshakes", convergeTimeout, func() bool {
+		return n.isConnected("sim0:3000") && n.isConnected("sim2:3000")
+	})
+	n.peersMu.Lock()
+	spoofer := n.peers["sim2:3000"]
+	n.peersMu.Unlock()
+	if spoofer.ListenAddr != "sim0:3000" {
+		t.Fatalf("advertised address %q, want sim0:3000", spoofer.ListenAddr)
+	}
+
+	n.peerManager.Misbehaving(spoofer, banThreshold, blockchain2.ReasonBadMessage, "test")
+	h.waitFor("disconnect", convergeTimeout, func() bool {
+		return !n.isConnected("sim2:3000")
+	})
+	if n.peerManager.IsBanned("sim0:3000") || !n.isConnected("sim0:3000") {
+		t.Fatal("honest node banned for the spoofer's misbehaviour")
+	}
+	if !n.peerManager.IsBanned("sim2:3000") {
+		t.Fatal("spoofer not banned")
+	}
+	if _, err := h.nodes[2].Connect(n.Addr()); err == nil {
+		t.Fatal("banned node reconnected")
+	}
+}
+
+//本机地址按端口封禁，同一主机上的其他节点仍能连接
+func TestLoopbackBan(t *testing.T) {
+	h := newSimHarnessWithConfig(t, 3, func(cfg *Config) {
+		var i int
+		fmt.Sscanf(cfg.NodeID, "sim%d", &i)
+		cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", 3000+i)
+		cfg.Transport = cfg.Transport.(*simTransport).net.Transport(cfg.ListenAddr)
+	})
+	defer h.close()
+
+	p, err := h.nodes[0].Connect(h.nodes[2].Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Send(blockchain2.MsgTypeBlock, GobEncode(Block{[]byte("garbage")}))
+	h.waitFor("ban", convergeTimeout, func() bool {
+		return h.nodes[2].peerManager.IsBanned(h.nodes[0].Addr())
+	})
+
+	if h.nodes[2].peerManager.IsBanned(h.nodes[1].Addr()) {
+		t.Fatal("another node on the same host banned")
+	}
+	h.connect(1, 2)
+	h.waitFor("handshake", convergeTimeout, func() bool {
+		return h.nodes[2].isConnected(h.nodes[1].Addr())
+	})
+}
+
+//交易ID与内容不符的节点被封禁，交易不进入内存池
+func TestForgedTxID(t *testing.T) {
+	h := newSimHarness(t, 2)
+	defer h.close()
+
+	p, err := h.nodes[0].Connect(h.nodes[1].Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := h.nodes[0]
+	n.handlerMu.Lock()
+	UTXOSet := blockchain.UTXOSet{n.chain}
+	tx := blockchain.NewTransaction(h.wallet, string(h.wallet.Address()), 10, &UTXOSet)
+	n.handlerMu.Unlock()
+	tx.ID = blockchain.CoinbaseTx(string(h.wallet.Address()), "").ID
+	SendTx(p, tx)
+
+	h.waitFor("ban", convergeTimeout, func() bool {
+		return h.nodes[1].peerManager.IsBanned(h.nodes[0].Addr())
+	})
+	if h.hasTx(1, tx.ID) {
+		t.Fatal("forged transaction accepted")
+	}
+}
+
+//含有签名无效交易的区块不被存入，也不被转发
+func TestBadSignatureBlock(t *testing.T) {
+	h := newSimHarness(t, 3)
+	defer h.close()
+
+	p, err := h.nodes[0].Connect(h.nodes[1].Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.connect(1, 2)
+
+	n := h.nodes[0]
+	n.handlerMu.Lock()
+	UTXOSet := blockchain.UTXOSet{n.chain}
+	tx := blockchain.NewTransaction(h.wallet, string(h.wallet.Address()), 10, &UTXOSet)
+	tx.TXInputs[0].Signature[0] ^= 0xff
+	cbTx := blockchain.CoinbaseTx(string(h.wallet.Address()), "bad-signature")
+	bad := blockchain.CreateBlock([]*blockchain.Transaction{cbTx, tx}, n.chain.LastHash, n.chain.GetBestHeight()+1)
+	n.handlerMu.Unlock()
+	SendBlock(p, bad)
+
+	//之后的合法区块沿同一连接到达，收到它时坏区块已被处理
+	good := h.mine(0)
+	hasBlock := func(i int, hash []byte) bool {
+		h.nodes[i].handlerMu.Lock()
+		defer h.nodes[i].handlerMu.Unlock()
+		return h.nodes[i].chain.HasBlock(hash)
+	}
+	h.waitFor("block relay", convergeTimeout, func() bool {
+		return hasBlock(1, good.Hash)
+	})
+	h.mine(0)
+	h.waitConverged(0, convergeTimeout)
+
+	for i := 1; i < 3; i++ {
+		if hasBlock(i, bad.Hash) {
+			t.Fatalf("node %d stored a block with an invalid signature", i)
+		}
+	}
+}
+
+//无法解码的区块和交易不会使节点崩溃，发送者被封禁
+func TestMalformedPayload(t *testing.T) {
+	payloads := map[string]struct {
+		msgType uint8
+		payload []byte
+	}{
+		"block":            {blockchain2.MsgTypeBlock, GobEncode(Block{[]byte("garbage")})},
+		"empty block":      {blockchain2.MsgTypeBlock, GobEncode(Block{(&blockchain.Block{Hash: []byte("empty")}).Serialize()})},
+		"transaction":      {blockchain2.MsgTypeTransaction, GobEncode(Tx{[]byte("garbage")})},
+		"stem transaction": {blockchain2.MsgTypeStemTransaction, GobEncode(Tx{[]byte("garbage")})},
+	}
+
+	for name, msg := range payloads {
+		func() {
+			h := newSimHarness(t, 2)
+			defer h.close()
+
+			p, err := h.nodes[0].Connect(h.nodes[1].Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Send(msg.msgType, msg.payload)
+
+			h.waitFor(name+" ban", convergeTimeout, func() bool {
+				return h.nodes[1].peerManager.IsBanned(h.nodes[0].Addr())
+			})
+		}()
+	}
+}
+
+//发送队列满时Send不等待，直接断开连接；对方不读取时写操作在写超时后失败
+func TestSlowPeer(t *testing.T) {
+	h := newSimHarness(t, 1)
+	defer h.close()
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	p := h.nodes[0].newPeer(local, "pipe", false)
+
+	payload := make([]byte, blockchain2.MaxMsgLen)
+	var err error
+	for i := 0; i < 5 && err == nil; i++ {
+		err = p.Send(blockchain2.MsgTypeBlock, payload)
+	}
+	if err != errSendQueueFull || !p.closed() {
+		t.Fatalf("send to a full queue returned %v", err)
+	}
+
+	//写循环卡在对方不读取的消息上，写超时后退出
+	done := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(writeTimeout + convergeTimeout):
+		t.Fatal("write did not time out")
+	}
+}
+
+//在本机TCP连接上使用加密传输，configure按节点编号设置加密方式
+func newEncryptedHarness(t *testing.T, modes ...EncryptionMode) *simHarness {
+	i := 0
+	return newSimHarnessWithConfig(t, len(modes), func(cfg *Config) {
+		cfg.Transport = tcpTransport{}
+		cfg.ListenAddr = "127.0.0.1:0"
+		cfg.Encryption = modes[i]
+		i++
+	})
+}
+
+//加密连接上双方得到对方的身份公钥，区块正常转发
+func TestEncryptedTransport(t *testing.T) {
+	h := newEncryptedHarness(t, EncryptionRequired, EncryptionRequired)
+	defer h.close()
+
+	p, err := h.nodes[0].Connect(h.nodes[1].Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.NodeKey, h.nodes[1].PublicKey()) {
+		t.Fatalf("peer key %x, want %x", p.NodeKey, h.nodes[1].PublicKey())
+	}
+	//本机上的入站连接以对方的临时端口登记
+	h.waitFor("handshake", convergeTimeout, func() bool {
+		return h.inboundPeer(1, h.nodes[0].Addr()) != nil
+	})
+	if key := h.inboundPeer(1, h.nodes[0].Addr()).NodeKey; !bytes.Equal(key, h.nodes[0].PublicKey()) {
+		t.Fatalf("inbound peer key %x, want %x", key, h.nodes[0].PublicKey())
+	}
+
+	h.mine(0)
+	h.waitConverged(0, convergeTimeout)
+}
+
+//优先加密的节点与不加密的节点以明文通信，要求加密的节点拒绝明文连接
+func TestEncryptionFallback(t *testing.T) {
+	h := newEncryptedHarness(t, EncryptionPreferred, EncryptionOff, EncryptionRequired)
+	defer h.close()
+
+	p, err := h.nodes[0].Connect(h.nodes[1].Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.NodeKey != nil {
+		t.Fatal("fallback connection reports a peer key")
+	}
+
+	if _, err := h.nodes[2].Connect(h.nodes[1].Addr()); err == nil {
+		t.Fatal("required encryption connected to plaintext node")
+	}
+	if _, err := h.nodes[1].Connect(h.nodes[2].Addr()); err == nil {
+		t.Fatal("plaintext node connected to node requiring encryption")
+	}
+
+	p, err = h.nodes[0].Connect(h.nodes[2].Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.NodeKey, h.nodes[2].PublicKey()) {
+		t.Fatalf("peer key %x, want %x", p.NodeKey, h.nodes[2].PublicKey())
+	}
+}
+
+/*在addr上监听，对每个连接只进行握手。key为nil时以明文监听，读到数据后断开*/
+func serveHandshakes(t *testing.T, addr string, key *ecdsa.PrivateKey) net.Listener {
+	var ln net.Listener
+	var err error
+	if key == nil {
+		ln, err = tcpTransport{}.Listen(addr)
+	} else {
+		ln, err = newSecureTransport(tcpTransport{}, key, EncryptionPreferred, "").Listen(addr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if c, ok := conn.(*secureConn); ok {
+					c.Handshake()
+					return
+				}
+				conn.Read(make([]byte, 1))
+			}()
+		}
+	}()
+
+	return ln
+}
+
+//加密连接过的地址换了身份公钥或不再支持加密时，发起方拒绝连接而不退回明文；已知公钥保存在文件中
+func TestKnownNodeKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodekeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Chdir(wd)
+	os.Mkdir("tmp", 0755)
+
+	keyA, _ := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	keyB, _ := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	keyC, _ := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	dialer := newSecureTransport(tcpTransport{}, keyA, EncryptionPreferred, "a")
+
+	ln := serveHandshakes(t, "127.0.0.1:0", keyB)
+	addr := ln.Addr().String()
+	conn, err := dialer.Dial(addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	ln.Close()
+
+	//同一地址出示另一个身份公钥
+	ln = serveHandshakes(t, addr, keyC)
+	if _, err := dialer.Dial(addr, time.Second); err == nil {
+		t.Error("connected to a node presenting a different key")
+	}
+	ln.Close()
+
+	//同一地址改为明文，从未连接过的节点仍退回明文
+	ln = serveHandshakes(t, addr, nil)
+	defer ln.Close()
+	if _, err := dialer.Dial(addr, time.Second); err == nil {
+		t.Error("fell back to plaintext for a node known to support encryption")
+	}
+	fresh := newSecureTransport(tcpTransport{}, keyA, EncryptionPreferred, "")
+	if conn, err := fresh.Dial(addr, time.Second); err != nil {
+		t.Errorf("first connection did not fall back to plaintext: %v", err)
+	} else {
+		conn.Close()
+	}
+
+	reloaded := newSecureTransport(tcpTransport{}, keyA, EncryptionPreferred, "a")
+	if key := reloaded.knownKey(addr); !bytes.Equal(key, marshalPubKey(&keyB.PublicKey)) {
+		t.Errorf("reloaded key %x, want %x", key, marshalPubKey(&keyB.PublicKey))
+	}
+}
+
+//被篡改的加密数据无法通过认证
+func TestSecureConnTamper(t *testing.T) {
+	a, b := net.Pipe()
+	keyA, _ := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	keyB, _ := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	initiator := &secureConn{Conn: a, key: keyA, mode: EncryptionRequired, initiator: true}
+	responder := &secureConn{Conn: b, key: keyB, mode: EncryptionRequired}
+	defer initiator.Close()
+	defer responder.Close()
+
+	errs := make(chan error, 1)
+	go func() { errs <- initiator.Handshake() }()
+	if err := responder.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		frame := initiator.send.encrypt(nil, []byte("hello"))
+		frame[0] ^= 1
+		initiator.writeFrame(frame)
+	}()
+	if _, err := responder.Read(make([]byte, 16)); err == nil {
+		t.Fatal("tampered data was accepted")
+	}
+}
+
+//ping测得往返时间并写入节点信息快照，网络断开后ping超时的节点被断开
+func TestPingEviction(t *testing.T) {
+	h := newSimHarnessWithConfig(t, 2, func(cfg *Config) {
+		cfg.PingInterval = 200 * time.Millisecond
+		cfg.PingTimeout = 500 * time.Millisecond
+	})
+	defer h.close()
+
+	h.connect(0, 1)
+	h.waitFor("pong", convergeTimeout, func() bool {
+		infos := h.nodes[0].PeerInfo()
+		return len(infos) == 1 && infos[0].PingTime > 0 && infos[0].TotalDifficulty > 0
+	})
+	h.waitFor("peer info snapshot", convergeTimeout, func() bool {
+		snapshot, err := LoadPeerInfo(h.nodes[0].NodeID)
+		return err == nil && len(snapshot.Peers) == 1 && snapshot.Peers[0].BytesRecv > 0
+	})
+
+	h.net.Partition([]string{h.nodes[0].Addr()}, []string{h.nodes[1].Addr()})
+	h.waitFor("eviction", convergeTimeout, func() bool {
+		return !h.nodes[0].isConnected(h.nodes[1].Addr()) && !h.nodes[1].isConnected(h.nodes[0].Addr())
+	})
+}
+
+//没有发布存证的区块也能通过ping中报告的链高度同步
+func TestPingTriggersSync(t *testing.T) {
+	h := newSimHarnessWithConfig(t, 2, func(cfg *Config) {
+		cfg.PingInterval = 200 * time.Millisecond
+		cfg.PingTimeout = 5 * time.Second
+	})
+	defer h.close()
+
+	h.connect(0, 1)
+
+	n := h.nodes[1]
+	n.handlerMu.Lock()
+	for i := 0; i < 2; i++ {
+		block := n.chain.MineBlock([]*blockchain.Transaction{blockchain.CoinbaseTx(string(h.wallet.Address()), fmt.Sprintf("silent-%d", i))})
+		UTXOSet := blockchain.UTXOSet{n.chain}
+		UTXOSet.Update(block)
+	}
+	n.handlerMu.Unlock()
+
+	h.waitConverged(1, convergeTimeout)
+}
@@ -0,0 +1,549 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/azd1997/golang-MimbleWimble-try/blockchain"
+	"github.com/azd1997/golang-MimbleWimble-try/wallet"
+)
+
+//测试中使用较短的间隔和超时，不必等待生产环境的时长
+func TestMain(m *testing.M) {
+	trickleInterval = 100 * time.Millisecond
+	syncInterval = 200 * time.Millisecond
+	blockRequestTimeout = 2 * time.Second
+	partialBlockTimeout = 2 * time.Second
+	writeTimeout = time.Second
+
+	os.Exit(m.Run())
+}
+
+//在内存中模拟的网络，供多节点测试使用
+//每次Write作为一条消息整体投递（WriteMessage一次写出一个完整的消息帧），
+//因此丢包只会丢掉整条消息而不会破坏帧格式。可以注入延迟、丢包和网络分区
+type simNet struct {
+	mu        sync.Mutex
+	listeners map[string]*simListener
+	groups    map[string]int //节点地址 -> 分区编号，不同分区的节点之间不能通信
+	latency   time.Duration
+	lossRate  float64
+	rand      *rand.Rand
+	sent      map[uint8]int //消息类型 -> 已送达的条数
+}
+
+func newSimNet(seed int64) *simNet {
+	return &simNet{
+		listeners: make(map[string]*simListener),
+		groups:    make(map[string]int),
+		sent:      make(map[uint8]int),
+		rand:      rand.New(rand.NewSource(seed)),
+	}
+}
+
+/*设置每条消息的延迟*/
+func (sn *simNet) SetLatency(d time.Duration) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	sn.latency = d
+}
+
+/*设置丢包率，0到1之间*/
+func (sn *simNet) SetLoss(rate float64) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	sn.lossRate = rate
+}
+
+/*将网络分为若干分区，未列出的节点在分区0中*/
+func (sn *simNet) Partition(groups ...[]string) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	sn.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, addr := range group {
+			sn.groups[addr] = i + 1
+		}
+	}
+}
+
+/*消除分区*/
+func (sn *simNet) Heal() {
+	sn.Partition()
+}
+
+/*返回某一类型的消息已送达的条数*/
+func (sn *simNet) Sent(msgType uint8) int {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	return sn.sent[msgType]
+}
+
+/*决定一条从from发往to的消息是否送达，送达时返回延迟*/
+func (sn *simNet) deliver(from, to string, frame []byte) (time.Duration, bool) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	if sn.groups[from] != sn.groups[to] {
+		return 0, false
+	}
+	if sn.lossRate > 0 && sn.rand.Float64() < sn.lossRate {
+		return 0, false
+	}
+
+	if len(frame) > 2 {
+		sn.sent[frame[2]]++
+	}
+
+	return sn.latency, true
+}
+
+/*返回某一地址的节点使用的连接方式*/
+func (sn *simNet) Transport(addr string) Transport {
+	return &simTransport{sn, addr}
+}
+
+type simTransport struct {
+	net  *simNet
+	addr string
+}
+
+func (t *simTransport) Listen(addr string) (net.Listener, error) {
+	sn := t.net
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	if _, ok := sn.listeners[addr]; ok {
+		return nil, fmt.Errorf("address %s already in use", addr)
+	}
+
+	ln := &simListener{net: sn, addr: addr, conns: make(chan net.Conn, 16), done: make(chan struct{})}
+	sn.listeners[addr] = ln
+
+	return ln, nil
+}
+
+func (t *simTransport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	sn := t.net
+	sn.mu.Lock()
+	ln, ok := sn.listeners[addr]
+	partitioned := sn.groups[t.addr] != sn.groups[addr]
+	sn.mu.Unlock()
+
+	if !ok || partitioned {
+		return nil, fmt.Errorf("dial %s: connection refused", addr)
+	}
+
+	local, remote := newSimConnPair(sn, t.addr, addr)
+	select {
+	case ln.conns <- remote:
+		return local, nil
+	case <-ln.done:
+		return nil, fmt.Errorf("dial %s: connection refused", addr)
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("dial %s: timeout", addr)
+	}
+}
+
+type simListener struct {
+	net   *simNet
+	addr  string
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *simListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *simListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+
+		l.net.mu.Lock()
+		delete(l.net.listeners, l.addr)
+		l.net.mu.Unlock()
+	})
+
+	return nil
+}
+
+func (l *simListener) Addr() net.Addr {
+	return simAddr(l.addr)
+}
+
+type simAddr string
+
+func (a simAddr) Network() string { return "sim" }
+func (a simAddr) String() string  { return string(a) }
+
+//一个方向上的消息队列
+type simPipe struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	packets []simPacket
+	closed  bool
+}
+
+type simPacket struct {
+	data []byte
+	at   time.Time //送达时间
+}
+
+//模拟连接的一端
+type simConn struct {
+	net    *simNet
+	local  string
+	remote string
+	in     *simPipe
+	out    *simPipe
+	buf    []byte //已读出但未被取走的数据
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+func newSimConnPair(sn *simNet, a, b string) (*simConn, *simConn) {
+	ab, ba := &simPipe{}, &simPipe{}
+	ab.cond = sync.NewCond(&ab.mu)
+	ba.cond = sync.NewCond(&ba.mu)
+
+	return &simConn{net: sn, local: a, remote: b, in: ba, out: ab},
+		&simConn{net: sn, local: b, remote: a, in: ab, out: ba}
+}
+
+func (c *simConn) Write(b []byte) (int, error) {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+
+	if c.out.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	if delay, ok := c.net.deliver(c.local, c.remote, b); ok {
+		data := append([]byte(nil), b...)
+		c.out.packets = append(c.out.packets, simPacket{data, time.Now().Add(delay)})
+		c.out.cond.Broadcast()
+	}
+
+	return len(b), nil
+}
+
+func (c *simConn) Read(b []byte) (int, error) {
+	if len(c.buf) == 0 {
+		packet, err := c.next()
+		if err != nil {
+			return 0, err
+		}
+		time.Sleep(time.Until(packet.at))
+		c.buf = packet.data
+	}
+
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+
+	return n, nil
+}
+
+/*取出下一条消息，没有消息时等待，连接关闭或读超时时返回错误*/
+func (c *simConn) next() (simPacket, error) {
+	c.mu.Lock()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+
+	p := c.in
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !deadline.IsZero() {
+		timer := time.AfterFunc(time.Until(deadline), func() {
+			p.mu.Lock()
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		})
+		defer timer.Stop()
+	}
+
+	for len(p.packets) == 0 {
+		if p.closed {
+			return simPacket{}, io.EOF
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return simPacket{}, timeoutError{}
+		}
+		p.cond.Wait()
+	}
+
+	packet := p.packets[0]
+	p.packets = p.packets[1:]
+
+	return packet, nil
+}
+
+func (c *simConn) Close() error {
+	for _, p := range []*simPipe{c.in, c.out} {
+		p.mu.Lock()
+		p.closed = true
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	}
+
+	return nil
+}
+
+func (c *simConn) LocalAddr() net.Addr  { return simAddr(c.local) }
+func (c *simConn) RemoteAddr() net.Addr { return simAddr(c.remote) }
+
+func (c *simConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *simConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+
+	return nil
+}
+
+//写操作不会阻塞，因此忽略写超时
+func (c *simConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+//多节点测试环境：所有节点共享同一个创世区块，通过模拟网络相连
+type simHarness struct {
+	t      *testing.T
+	net    *simNet
+	nodes  []*Node
+	wallet *wallet.Wallet //创世区块奖励的接收者
+	dir    string
+	wd     string
+}
+
+/*在临时目录中创建n个节点并启动，节点之间尚未连接。测试结束时须调用close*/
+func newSimHarness(t *testing.T, n int) *simHarness {
+	return newSimHarnessWithConfig(t, n, nil)
+}
+
+/*同newSimHarness，configure不为nil时在创建每个节点前修改其配置*/
+func newSimHarnessWithConfig(t *testing.T, n int, configure func(cfg *Config)) *simHarness {
+	dir, err := ioutil.TempDir("", "simnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//区块链和地址簿保存在当前目录下的tmp中
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("tmp/blocks", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &simHarness{t: t, net: newSimNet(1), dir: dir, wd: wd}
+
+	ws := &wallet.Wallets{WalletsMap: make(map[string]*wallet.Wallet), WatchOnlyMap: make(map[string]*wallet.WatchOnly)}
+	address := ws.AddWallet()
+	w, err := ws.GetWallet(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.wallet = &w
+
+	genesis := blockchain.InitBlockChain(address, "genesis")
+	genesis.Db.Close()
+
+	for i := 0; i < n; i++ {
+		nodeID := fmt.Sprintf("sim%d", i)
+		copyDir(t, "tmp/blocks/blocks_genesis", "tmp/blocks/blocks_"+nodeID)
+
+		chain := blockchain.ContinueBlockChain(nodeID)
+		UTXOSet := blockchain.UTXOSet{chain}
+		UTXOSet.Reindex()
+
+		addr := fmt.Sprintf("%s:3000", nodeID)
+		cfg := Config{NodeID: nodeID, ListenAddr: addr, Transport: h.net.Transport(addr)}
+		if configure != nil {
+			configure(&cfg)
+		}
+		node := NewNode(cfg, chain, nil)
+		if err := node.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		//连接由测试指定，节点管理器不自动补充连接
+		node.peerManager.mu.Lock()
+		node.peerManager.TargetOutbound = 0
+		node.peerManager.mu.Unlock()
+
+		h.nodes = append(h.nodes, node)
+	}
+
+	return h
+}
+
+/*停止所有节点，删除临时目录*/
+func (h *simHarness) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, node := range h.nodes {
+		if err := node.Stop(ctx); err != nil {
+			h.t.Errorf("stop %s: %v", node.NodeID, err)
+		}
+		node.chain.Db.Close()
+	}
+
+	os.Chdir(h.wd)
+	os.RemoveAll(h.dir)
+}
+
+func copyDir(t *testing.T, src, dst string) {
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, info.Mode())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*节点i主动连接节点j并完成握手*/
+func (h *simHarness) connect(i, j int) {
+	if _, err := h.nodes[i].Connect(h.nodes[j].Addr()); err != nil {
+		h.t.Fatalf("connect %d -> %d: %v", i, j, err)
+	}
+}
+
+/*节点i上对方声称监听地址为listenAddr的已握手入站连接，没有则返回nil*/
+func (h *simHarness) inboundPeer(i int, listenAddr string) *Peer {
+	for _, p := range h.nodes[i].connectedPeers() {
+		if p.Inbound && p.ListenAddr == listenAddr {
+			return p
+		}
+	}
+
+	return nil
+}
+
+/*节点i挖出一个区块并向其他节点发布，内存池中的交易被打包进区块*/
+func (h *simHarness) mine(i int) *blockchain.Block {
+	n := h.nodes[i]
+	n.handlerMu.Lock()
+	defer n.handlerMu.Unlock()
+
+	txs := []*blockchain.Transaction{blockchain.CoinbaseTx(string(h.wallet.Address()), fmt.Sprintf("%s-%d", n.NodeID, rand.Int()))}
+	for id, tx := range n.memoryPool {
+		tx := tx
+		txs = append(txs, &tx)
+		delete(n.memoryPool, id)
+	}
+
+	block := n.chain.MineBlock(txs)
+	UTXOSet := blockchain.UTXOSet{n.chain}
+	UTXOSet.Update(block)
+	n.relayInv(invTypeBlock, block.Hash, nil)
+
+	return block
+}
+
+/*创建一笔从创世区块奖励接收者转给to的交易，提交给节点i*/
+func (h *simHarness) send(i int, to string, amount int) *blockchain.Transaction {
+	n := h.nodes[i]
+	n.handlerMu.Lock()
+	defer n.handlerMu.Unlock()
+
+	UTXOSet := blockchain.UTXOSet{n.chain}
+	tx := blockchain.NewTransaction(h.wallet, to, amount, &UTXOSet)
+	if !n.chain.VerifyTransaction(tx) {
+		h.t.Fatalf("created invalid transaction %x", tx.ID)
+	}
+	n.acceptTx(*tx, nil)
+
+	return tx
+}
+
+/*节点的链尾哈希和高度*/
+func (h *simHarness) tip(i int) ([]byte, int) {
+	n := h.nodes[i]
+	n.handlerMu.Lock()
+	defer n.handlerMu.Unlock()
+
+	return n.chain.LastHash, n.chain.GetBestHeight()
+}
+
+/*节点的内存池中是否有某交易*/
+func (h *simHarness) hasTx(i int, id []byte) bool {
+	n := h.nodes[i]
+	n.handlerMu.Lock()
+	defer n.handlerMu.Unlock()
+
+	return n.inMemoryPool(id)
+}
+
+/*等待条件成立，超时则测试失败*/
+func (h *simHarness) waitFor(what string, timeout time.Duration, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+/*等待所有节点的链尾与节点i相同*/
+func (h *simHarness) waitConverged(i int, timeout time.Duration) {
+	h.waitFor("chains to converge", timeout, func() bool {
+		want, _ := h.tip(i)
+		for j := range h.nodes {
+			if got, _ := h.tip(j); !bytes.Equal(got, want) {
+				return false
+			}
+		}
+		return true
+	})
+}
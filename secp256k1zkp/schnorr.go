@@ -76,8 +76,14 @@ func SignMessage(publicKey Point, privateKey big.Int, message [32]byte) Signatur
 	// Compute a random nonce, k.
 	k := RandomInt()
 
-	// R is the public key for k.
+	// R is the public key for k. Only the x coordinate of R is serialized
+	// and DecodeSignature picks the y that is a quadratic residue, so use
+	// -k if R has the other y.
 	R := ScalarMulPoint(&G, k)
+	if !IsQuadraticResidue(R.Y) {
+		k = Neg(k)
+		R = ScalarMulPoint(&G, k)
+	}
 
 	// Compute a non-interactive challenge.
 	Rx := GetB32(R.X)
@@ -57,3 +57,16 @@ func TestVerifyKernel(t *testing.T) {
 		t.Errorf("verify failed")
 	}
 }
+
+func TestSignatureRoundTrip(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		x := RandomInt()
+		P := ScalarMulPoint(&G, x)
+		msg := ComputeMessage(uint64(i), 0)
+
+		sig := DecodeSignature(SignMessage(*P, *x, msg).Bytes())
+		if !VerifySignature(*P, msg, sig) {
+			t.Fatalf("failed to verify decoded signature %d", i)
+		}
+	}
+}
@@ -52,4 +52,9 @@ func Base58Decode(input []byte) []byte {
 	return decode
 }
 
+/*Base58解码，输入非法时返回错误而不是panic，用于解码用户输入*/
+func TryBase58Decode(input []byte) ([]byte, error) {
+	return base58.Decode(string(input[:]))
+}
+
 //注意点，经常性的，注释中提及[]byte为字节数组，在go中，[]byte称为切片slice，[32]byte（指定长度）称为数组
@@ -0,0 +1,179 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//Bech32地址的人类可读前缀，用以区分网络
+const (
+	MainNetHRP = "mw"
+	TestNetHRP = "tmw"
+)
+
+const (
+	bech32Charset        = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32Separator      = '1'
+	bech32ChecksumLength = 6
+	bech32MaxLength      = 90
+	addressVersion       = byte(0) //Bech32地址数据部分的版本号，目前只有P2PKH一种
+)
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+//当前网络的地址前缀，新生成的地址都使用它
+var addressHRP = MainNetHRP
+
+//方法列表
+//1.func SetNetwork(name string) error
+//2.func Bech32Encode(hrp string, data []byte) (string, error)
+//3.func Bech32Decode(address string) (string, []byte, error)
+
+/*根据网络名选择地址前缀，名称为空表示主网*/
+func SetNetwork(name string) error {
+	switch name {
+	case "", "mainnet":
+		addressHRP = MainNetHRP
+	case "testnet":
+		addressHRP = TestNetHRP
+	default:
+		return fmt.Errorf("unknown network %q", name)
+	}
+
+	return nil
+}
+
+/*Bech32校验码使用的BCH码多项式求余*/
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+
+	return chk
+}
+
+/*将前缀展开，使校验码同时覆盖前缀*/
+func bech32HRPExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+
+	return expanded
+}
+
+func bech32Checksum(hrp string, data []byte) []byte {
+	values := append(bech32HRPExpand(hrp), data...)
+	values = append(values, make([]byte, bech32ChecksumLength)...)
+	polymod := bech32Polymod(values) ^ 1
+
+	checksum := make([]byte, bech32ChecksumLength)
+	for i := range checksum {
+		checksum[i] = byte((polymod >> uint(5*(5-i))) & 31)
+	}
+
+	return checksum
+}
+
+/*在8位和5位分组之间转换，pad为false时要求输入恰好能转换完*/
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var out []byte
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<toBits - 1
+
+	for _, b := range data {
+		if uint32(b)>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+
+	return out, nil
+}
+
+/*将5位分组的数据编码为Bech32字符串*/
+func Bech32Encode(hrp string, data []byte) (string, error) {
+	if len(hrp)+1+len(data)+bech32ChecksumLength > bech32MaxLength {
+		return "", errors.New("bech32 string too long")
+	}
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte(bech32Separator)
+	for _, v := range append(data, bech32Checksum(hrp, data)...) {
+		if v > 31 {
+			return "", errors.New("invalid data range")
+		}
+		sb.WriteByte(bech32Charset[v])
+	}
+
+	return sb.String(), nil
+}
+
+/*解码Bech32字符串，返回前缀和5位分组的数据（不含校验码）*/
+//错误信息尽量指出问题所在，以便用户发现输错的地址
+func Bech32Decode(address string) (string, []byte, error) {
+	if len(address) > bech32MaxLength {
+		return "", nil, fmt.Errorf("address too long: %d characters, at most %d", len(address), bech32MaxLength)
+	}
+	if strings.ToLower(address) != address && strings.ToUpper(address) != address {
+		return "", nil, errors.New("address mixes upper and lower case")
+	}
+	address = strings.ToLower(address)
+
+	pos := strings.LastIndexByte(address, bech32Separator)
+	if pos < 1 {
+		return "", nil, errors.New("address has no network prefix")
+	}
+	if pos+bech32ChecksumLength+1 > len(address) {
+		return "", nil, errors.New("address too short")
+	}
+
+	hrp := address[:pos]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, fmt.Errorf("invalid character in network prefix at position %d", i)
+		}
+	}
+
+	data := make([]byte, 0, len(address)-pos-1)
+	for i := pos + 1; i < len(address); i++ {
+		v := strings.IndexByte(bech32Charset, address[i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid character %q at position %d", address[i], i)
+		}
+		data = append(data, byte(v))
+	}
+
+	if bech32Polymod(append(bech32HRPExpand(hrp), data...)) != 1 {
+		return "", nil, errors.New("address checksum mismatch, check for typos")
+	}
+
+	return hrp, data[:len(data)-bech32ChecksumLength], nil
+}
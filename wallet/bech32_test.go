@@ -0,0 +1,83 @@
+package wallet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBech32Vectors(t *testing.T) {
+	// Valid strings from BIP 173.
+	valid := []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	}
+	for _, s := range valid {
+		if _, _, err := Bech32Decode(s); err != nil {
+			t.Errorf("%q: unexpected error %v", s, err)
+		}
+	}
+
+	invalid := []string{
+		"pzry9x0s0muk",  // no separator
+		"1pzry9x0s0muk", // empty prefix
+		"x1b4n0q5v",     // invalid data character
+		"li1dgmt3",      // checksum too short
+		"A1G7SGD8",      // checksum computed over uppercase prefix
+		"a12UEL5L",      // mixed case
+	}
+	for _, s := range invalid {
+		if _, _, err := Bech32Decode(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestAddressFormats(t *testing.T) {
+	pubKeyHash := PublicKeyHash([]byte("public key"))
+
+	for _, address := range [][]byte{PubKeyHashToAddress(pubKeyHash), PubKeyHashToLegacyAddress(pubKeyHash)} {
+		decoded, err := DecodeAddress(string(address))
+		if err != nil {
+			t.Fatalf("%s: %v", address, err)
+		}
+		if !bytes.Equal(decoded, pubKeyHash) {
+			t.Fatalf("%s: decoded %x, want %x", address, decoded, pubKeyHash)
+		}
+
+		// A single mistyped character must be detected rather than panic.
+		typo := append([]byte{}, address...)
+		if typo[len(typo)-1] == 'q' {
+			typo[len(typo)-1] = 'p'
+		} else {
+			typo[len(typo)-1] = 'q'
+		}
+		if err := ValidateAddress(string(typo)); err == nil {
+			t.Fatalf("%s: typo not detected", typo)
+		}
+	}
+
+	if err := ValidateAddress("not an address"); err == nil {
+		t.Fatal("garbage accepted")
+	}
+}
+
+func TestAddressNetwork(t *testing.T) {
+	pubKeyHash := PublicKeyHash([]byte("public key"))
+	mainNet := string(PubKeyHashToAddress(pubKeyHash))
+
+	if err := SetNetwork("testnet"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetNetwork("")
+
+	testNet := string(PubKeyHashToAddress(pubKeyHash))
+	if testNet[:len(TestNetHRP)] != TestNetHRP {
+		t.Fatalf("%s: missing testnet prefix", testNet)
+	}
+	if err := ValidateAddress(mainNet); err == nil {
+		t.Fatal("mainnet address accepted on testnet")
+	}
+}
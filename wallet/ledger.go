@@ -0,0 +1,284 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+const ledgerFile = "./tmp/wallets/ledger_%s.data" //账本与钱包文件放在一起，同样按nodeId区分
+
+//账本交易记录的类别
+const (
+	CategoryReceive  = "receive"  //收款
+	CategorySend     = "send"     //付款
+	CategoryGenerate = "generate" //挖矿所得（coinbase）
+)
+
+//钱包持有的一笔交易输出，用以在付款时找回其金额
+type LedgerOutput struct {
+	TxID        []byte
+	Index       int
+	Address     string
+	Value       int
+	Height      int
+	Spent       bool
+	SpentHeight int
+}
+
+//账本中的一条交易记录，一笔交易对每个相关地址至多产生一条记录
+type TxRecord struct {
+	TxID      []byte
+	Address   string
+	Category  string
+	Amount    int
+	Height    int
+	BlockHash []byte
+	Timestamp int64
+}
+
+//钱包账本，记录钱包地址的收付款历史以及用户标签
+type Ledger struct {
+	Records    []*TxRecord
+	Outputs    map[string]*LedgerOutput //"txid:index" -> 输出
+	Labels     map[string]string        //地址 -> 标签
+	TxLabels   map[string]string        //交易ID(hex) -> 标签
+	TipHash    []byte                   //账本已同步到的区块哈希
+	TipHeight  int                      //账本已同步到的区块高度
+	Rescanning bool                     //是否有未完成的重新扫描，用于中断后继续
+}
+
+//方法列表
+//1.func CreateLedger(nodeId string) *Ledger
+//2.func (l *Ledger) SaveFile(nodeId string)
+//3.func (l *Ledger) LoadFile(nodeId string) error
+//4.func (l *Ledger) Reset()
+//5.func (l *Ledger) AddOutput(txID []byte, index int, address string, value, height int)
+//6.func (l *Ledger) SpendOutput(txID []byte, index, height int) (*LedgerOutput, bool)
+//7.func (l *Ledger) AddRecord(record *TxRecord)
+//8.func (l *Ledger) SetLabel(address, label string)
+//9.func (l *Ledger) SetTxLabel(txID, label string) error
+//10.func (l *Ledger) Label(record *TxRecord) string
+//11.func (l *Ledger) GetRecords(address string) []*TxRecord
+//12.func (l *Ledger) Rewind(height int)
+//13.func (l *Ledger) GetUnspent(address string) []*LedgerOutput
+//14.func (l *Ledger) Balance(address string) int
+
+/*创建账本对象，若账本文件存在则从文件中恢复*/
+func CreateLedger(nodeId string) *Ledger {
+	ledger := &Ledger{}
+	ledger.Reset()
+	ledger.Labels = make(map[string]string)
+	ledger.TxLabels = make(map[string]string)
+
+	//账本文件不存在时返回空账本，之后同步时会从创世区块开始重建
+	_ = ledger.LoadFile(nodeId)
+
+	return ledger
+}
+
+/*将账本编码后写入文件*/
+func (l *Ledger) SaveFile(nodeId string) {
+	var content bytes.Buffer
+	ledgerFile := fmt.Sprintf(ledgerFile, nodeId)
+
+	encoder := gob.NewEncoder(&content)
+	err := encoder.Encode(l)
+	utils.Handle(err)
+
+	err = ioutil.WriteFile(ledgerFile, content.Bytes(), 0644)
+	utils.Handle(err)
+}
+
+/*从文件加载账本*/
+func (l *Ledger) LoadFile(nodeId string) error {
+	ledgerFile := fmt.Sprintf(ledgerFile, nodeId)
+	if _, err := os.Stat(ledgerFile); os.IsNotExist(err) {
+		return err
+	}
+
+	var ledger Ledger
+
+	fileContent, err := ioutil.ReadFile(ledgerFile)
+	utils.Handle(err)
+
+	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
+	err = decoder.Decode(&ledger)
+	utils.Handle(err)
+
+	//gob不会编码空map，解码后需补上
+	if ledger.Outputs == nil {
+		ledger.Outputs = make(map[string]*LedgerOutput)
+	}
+	if ledger.Labels == nil {
+		ledger.Labels = make(map[string]string)
+	}
+	if ledger.TxLabels == nil {
+		ledger.TxLabels = make(map[string]string)
+	}
+
+	*l = ledger
+
+	return nil
+}
+
+/*清空交易记录和输出，保留用户标签。用于区块链切换后从头重建账本*/
+func (l *Ledger) Reset() {
+	l.Records = nil
+	l.Outputs = make(map[string]*LedgerOutput)
+	l.TipHash = nil
+	l.TipHeight = 0
+	l.Rescanning = false
+}
+
+func outputKey(txID []byte, index int) string {
+	return fmt.Sprintf("%x:%d", txID, index)
+}
+
+/*记录一笔属于本钱包的交易输出*/
+func (l *Ledger) AddOutput(txID []byte, index int, address string, value, height int) {
+	l.Outputs[outputKey(txID, index)] = &LedgerOutput{
+		TxID:    txID,
+		Index:   index,
+		Address: address,
+		Value:   value,
+		Height:  height,
+	}
+}
+
+/*将本钱包的一笔输出标记为已花费，若该输出不属于本钱包则返回false*/
+func (l *Ledger) SpendOutput(txID []byte, index, height int) (*LedgerOutput, bool) {
+	out, ok := l.Outputs[outputKey(txID, index)]
+	if !ok || out.Spent {
+		return nil, false
+	}
+
+	out.Spent = true
+	out.SpentHeight = height
+
+	return out, true
+}
+
+/*追加一条交易记录*/
+func (l *Ledger) AddRecord(record *TxRecord) {
+	l.Records = append(l.Records, record)
+}
+
+/*为地址设置标签，标签为空则删除*/
+func (l *Ledger) SetLabel(address, label string) {
+	if label == "" {
+		delete(l.Labels, address)
+		return
+	}
+	l.Labels[address] = label
+}
+
+/*为交易设置标签，标签为空则删除。txID为交易ID的十六进制形式，不区分大小写*/
+func (l *Ledger) SetTxLabel(txID, label string) error {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		return fmt.Errorf("invalid hex: %v", err)
+	}
+	if len(id) != sha256.Size {
+		return fmt.Errorf("transaction ID must be %d bytes, got %d", sha256.Size, len(id))
+	}
+
+	//Label按小写十六进制查找交易标签
+	key := hex.EncodeToString(id)
+	if label == "" {
+		delete(l.TxLabels, key)
+		return nil
+	}
+	l.TxLabels[key] = label
+
+	return nil
+}
+
+/*返回交易记录的标签，交易标签优先于地址标签*/
+func (l *Ledger) Label(record *TxRecord) string {
+	if label, ok := l.TxLabels[hex.EncodeToString(record.TxID)]; ok {
+		return label
+	}
+
+	return l.Labels[record.Address]
+}
+
+/*按区块高度顺序返回某地址的交易记录，地址为空则返回全部记录*/
+func (l *Ledger) GetRecords(address string) []*TxRecord {
+	var records []*TxRecord
+
+	for _, record := range l.Records {
+		if address == "" || record.Address == address {
+			records = append(records, record)
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Height < records[j].Height
+	})
+
+	return records
+}
+
+/*撤销高度不低于height的区块所带来的记录、输出和花费，用于从该高度重新扫描*/
+//调用者需随后将TipHash设为height-1处区块的哈希
+func (l *Ledger) Rewind(height int) {
+	var records []*TxRecord
+	for _, record := range l.Records {
+		if record.Height < height {
+			records = append(records, record)
+		}
+	}
+	l.Records = records
+
+	for key, out := range l.Outputs {
+		if out.Height >= height {
+			delete(l.Outputs, key)
+			continue
+		}
+		if out.Spent && out.SpentHeight >= height {
+			out.Spent = false
+			out.SpentHeight = 0
+		}
+	}
+
+	if l.TipHeight >= height {
+		l.TipHeight = height - 1
+	}
+}
+
+/*按区块高度顺序返回某地址未花费的输出，地址为空则返回全部*/
+func (l *Ledger) GetUnspent(address string) []*LedgerOutput {
+	var outs []*LedgerOutput
+
+	for _, out := range l.Outputs {
+		if !out.Spent && (address == "" || out.Address == address) {
+			outs = append(outs, out)
+		}
+	}
+
+	sort.Slice(outs, func(i, j int) bool {
+		if outs[i].Height != outs[j].Height {
+			return outs[i].Height < outs[j].Height
+		}
+		return outputKey(outs[i].TxID, outs[i].Index) < outputKey(outs[j].TxID, outs[j].Index)
+	})
+
+	return outs
+}
+
+/*返回某地址未花费输出的总额，地址为空则返回整个钱包的余额*/
+func (l *Ledger) Balance(address string) int {
+	balance := 0
+	for _, out := range l.GetUnspent(address) {
+		balance += out.Value
+	}
+
+	return balance
+}
@@ -0,0 +1,96 @@
+package wallet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func newTestLedger() *Ledger {
+	ledger := &Ledger{Labels: make(map[string]string), TxLabels: make(map[string]string)}
+	ledger.Reset()
+
+	return ledger
+}
+
+func TestSetTxLabel(t *testing.T) {
+	txID := bytes.Repeat([]byte{0xab, 0x01}, 16)
+	record := &TxRecord{TxID: txID, Address: "addr"}
+
+	ledger := newTestLedger()
+	ledger.SetLabel("addr", "address label")
+
+	//十六进制不区分大小写
+	if err := ledger.SetTxLabel(strings.ToUpper(strings.Repeat("ab01", 16)), "rent"); err != nil {
+		t.Fatal(err)
+	}
+	if label := ledger.Label(record); label != "rent" {
+		t.Errorf("label = %q, want %q", label, "rent")
+	}
+
+	//删除交易标签后显示地址标签
+	if err := ledger.SetTxLabel(strings.Repeat("ab01", 16), ""); err != nil {
+		t.Fatal(err)
+	}
+	if label := ledger.Label(record); label != "address label" {
+		t.Errorf("label = %q, want %q", label, "address label")
+	}
+
+	invalid := []string{
+		"",
+		"xyz",
+		strings.Repeat("ab01", 15),
+		strings.Repeat("ab01", 16) + "0",
+		strings.Repeat("ab01", 17),
+	}
+	for _, txID := range invalid {
+		if err := ledger.SetTxLabel(txID, "bad"); err == nil {
+			t.Errorf("%q: expected an error", txID)
+		}
+	}
+	if len(ledger.TxLabels) != 0 {
+		t.Errorf("invalid ids stored: %v", ledger.TxLabels)
+	}
+}
+
+func TestLedgerRewind(t *testing.T) {
+	ledger := newTestLedger()
+	tx := func(height int) []byte { return []byte{byte(height)} }
+
+	//高度1、2各收到一笔，高度3花费了高度1的输出并收到找零
+	ledger.AddOutput(tx(1), 0, "a", 50, 1)
+	ledger.AddRecord(&TxRecord{TxID: tx(1), Address: "a", Category: CategoryReceive, Amount: 50, Height: 1})
+	ledger.AddOutput(tx(2), 0, "a", 20, 2)
+	ledger.AddRecord(&TxRecord{TxID: tx(2), Address: "a", Category: CategoryReceive, Amount: 20, Height: 2})
+	if _, ok := ledger.SpendOutput(tx(1), 0, 3); !ok {
+		t.Fatal("output at height 1 not spendable")
+	}
+	ledger.AddOutput(tx(3), 1, "a", 45, 3)
+	ledger.AddRecord(&TxRecord{TxID: tx(3), Address: "a", Category: CategorySend, Amount: 5, Height: 3})
+	ledger.TipHeight = 3
+
+	if balance := ledger.Balance("a"); balance != 65 {
+		t.Fatalf("balance = %d, want 65", balance)
+	}
+
+	ledger.Rewind(3)
+
+	if ledger.TipHeight != 2 {
+		t.Errorf("tip height = %d, want 2", ledger.TipHeight)
+	}
+	if records := ledger.GetRecords(""); len(records) != 2 || records[1].Height != 2 {
+		t.Errorf("records after rewind = %v", records)
+	}
+	if balance := ledger.Balance("a"); balance != 70 {
+		t.Errorf("balance = %d, want 70", balance)
+	}
+	if _, ok := ledger.SpendOutput(tx(1), 0, 3); !ok {
+		t.Error("spend at the rewound height not undone")
+	}
+
+	//回退到更高的高度不影响已同步的内容
+	ledger.Rewind(5)
+	if ledger.TipHeight != 2 || len(ledger.Records) != 2 {
+		t.Errorf("rewind above the tip changed the ledger: tip %d, %d records", ledger.TipHeight, len(ledger.Records))
+	}
+}
@@ -0,0 +1,115 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+//签名消息前附加的域分隔串，使消息签名不可能被当作交易签名或其他用途的签名
+const messageMagic = "MimbleWimble Signed Message:\n"
+
+//签名长度：r、s各32字节
+const messageSigLength = 64
+
+//方法列表
+//1.func MessageHash(message string) []byte
+//2.func (w Wallet) SignMessage(message string) (string, error)
+//3.func VerifyMessage(address, signature, message string) error
+
+/*计算待签名消息的哈希：对 域分隔串和消息（均带长度前缀）进行双sha256*/
+func MessageHash(message string) []byte {
+	var buf bytes.Buffer
+	writeVarString(&buf, messageMagic)
+	writeVarString(&buf, message)
+
+	firstHash := sha256.Sum256(buf.Bytes())
+	secondHash := sha256.Sum256(firstHash[:])
+
+	return secondHash[:]
+}
+
+func writeVarString(buf *bytes.Buffer, s string) {
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(s)))
+	buf.Write(length[:n])
+	buf.WriteString(s)
+}
+
+/*用钱包私钥对消息签名，返回base64编码的签名*/
+//签名内容为 公钥长度(1字节) + 公钥 + r(32字节) + s(32字节)，验证时据此检查公钥哈希是否与地址一致
+func (w Wallet) SignMessage(message string) (string, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, &w.WPrivateKey, MessageHash(message))
+	if err != nil {
+		return "", err
+	}
+
+	blob := make([]byte, 1+len(w.WPublicKey)+messageSigLength)
+	blob[0] = byte(len(w.WPublicKey))
+	copy(blob[1:], w.WPublicKey)
+	sig := blob[1+len(w.WPublicKey):]
+	rBytes, sBytes := r.Bytes(), s.Bytes()
+	copy(sig[32-len(rBytes):32], rBytes)
+	copy(sig[messageSigLength-len(sBytes):], sBytes)
+
+	return base64.StdEncoding.EncodeToString(blob), nil
+}
+
+/*验证签名是否由地址对应的私钥对消息所签，不通过时返回原因*/
+func VerifyMessage(address, signature, message string) error {
+	pubKeyHash, err := DecodeAddress(address)
+	if err != nil {
+		return err
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.New("signature is not valid base64")
+	}
+	if len(blob) < 1 || len(blob) != 1+int(blob[0])+messageSigLength {
+		return errors.New("signature has wrong length")
+	}
+
+	pubKey := blob[1 : 1+int(blob[0])]
+	sig := blob[1+int(blob[0]):]
+
+	//签名中携带的公钥必须属于该地址
+	if bytes.Compare(PublicKeyHash(pubKey), pubKeyHash) != 0 {
+		return errors.New("signature was not made by the key of this address")
+	}
+
+	rawPubKey, err := parsePublicKey(pubKey)
+	if err != nil {
+		return err
+	}
+
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(rawPubKey, MessageHash(message), r, s) {
+		return errors.New("signature does not match message")
+	}
+
+	return nil
+}
+
+/*由X、Y坐标直接拼接成的公钥恢复出椭圆曲线公钥*/
+//旧钱包的公钥省略了坐标的前导零字节，长度为奇数时两种切分都要尝试
+func parsePublicKey(pubKey []byte) (*ecdsa.PublicKey, error) {
+	curve := elliptic.P256()
+
+	for _, split := range []int{len(pubKey) / 2, (len(pubKey) + 1) / 2} {
+		x := new(big.Int).SetBytes(pubKey[:split])
+		y := new(big.Int).SetBytes(pubKey[split:])
+		if curve.IsOnCurve(x, y) {
+			return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+		}
+	}
+
+	return nil, errors.New("public key is not a valid curve point")
+}
@@ -0,0 +1,77 @@
+package wallet
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+func TestSignVerifyMessage(t *testing.T) {
+	w := MakeWallet()
+	address := fmt.Sprintf("%s", w.Address())
+	message := "I own this address"
+
+	signature, err := w.SignMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMessage(address, signature, message); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+
+	//旧版地址也可以验证
+	legacy := string(PubKeyHashToLegacyAddress(PublicKeyHash(w.WPublicKey)))
+	if err := VerifyMessage(legacy, signature, message); err != nil {
+		t.Errorf("legacy address: %v", err)
+	}
+
+	other := fmt.Sprintf("%s", MakeWallet().Address())
+	if err := VerifyMessage(other, signature, message); err == nil {
+		t.Error("signature accepted for another address")
+	}
+	if err := VerifyMessage(address, signature, message+"!"); err == nil {
+		t.Error("signature accepted for a tampered message")
+	}
+	if err := VerifyMessage("not an address", signature, message); err == nil {
+		t.Error("signature accepted for an invalid address")
+	}
+}
+
+func TestVerifyMessageMalformed(t *testing.T) {
+	w := MakeWallet()
+	address := fmt.Sprintf("%s", w.Address())
+	message := "message"
+
+	signature, err := w.SignMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, _ := base64.StdEncoding.DecodeString(signature)
+
+	tampered := append([]byte(nil), blob...)
+	tampered[len(tampered)-1] ^= 1
+
+	//公钥哈希与地址一致，但公钥不是曲线上的点
+	offCurve := make([]byte, 64)
+	offCurve[0] = 1
+	offCurveBlob := append([]byte{64}, offCurve...)
+	offCurveBlob = append(offCurveBlob, blob[len(blob)-messageSigLength:]...)
+	offCurveAddress := string(PubKeyHashToAddress(PublicKeyHash(offCurve)))
+
+	cases := []struct {
+		name, address, signature string
+	}{
+		{"not base64", address, "%%%"},
+		{"empty", address, ""},
+		{"truncated", address, base64.StdEncoding.EncodeToString(blob[:len(blob)-1])},
+		{"extended", address, base64.StdEncoding.EncodeToString(append(blob, 0))},
+		{"wrong key length", address, base64.StdEncoding.EncodeToString(append([]byte{blob[0] - 1}, blob[1:]...))},
+		{"tampered signature", address, base64.StdEncoding.EncodeToString(tampered)},
+		{"off curve key", offCurveAddress, base64.StdEncoding.EncodeToString(offCurveBlob)},
+	}
+	for _, c := range cases {
+		if err := VerifyMessage(c.address, c.signature, message); err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+)
+
+const nodeKeyFile = "./tmp/wallets/nodekey_%s.data" //节点身份密钥与钱包文件放在一起，同样按nodeId区分
+
+//方法列表
+//1.func LoadNodeKey(nodeId string) (*ecdsa.PrivateKey, error)
+
+/*读取节点的身份私钥，文件不存在时生成新的私钥并保存*/
+//身份密钥用于节点之间加密连接时证明自己的身份，与钱包中的密钥无关，文件中只保存私钥的32字节标量
+func LoadNodeKey(nodeId string) (*ecdsa.PrivateKey, error) {
+	file := fmt.Sprintf(nodeKeyFile, nodeId)
+	curve := elliptic.P256()
+
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		privateKey, _ := NewKeyPair()
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return nil, err
+		}
+		d := make([]byte, 32)
+		b := privateKey.D.Bytes()
+		copy(d[32-len(b):], b)
+		if err := ioutil.WriteFile(file, d, 0600); err != nil {
+			return nil, err
+		}
+		return &privateKey, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d := new(big.Int).SetBytes(content)
+	if len(content) != 32 || d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("invalid node key file")
+	}
+
+	privateKey := &ecdsa.PrivateKey{D: d}
+	privateKey.PublicKey.Curve = curve
+	privateKey.PublicKey.X, privateKey.PublicKey.Y = curve.ScalarBaseMult(content)
+
+	return privateKey, nil
+}
@@ -7,7 +7,9 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/azd1997/golang-MimbleWimble-try/utils"
 	//ripemd160 "github.com/azd1997/golang-blockchain/mycrypto/myripemd160"
@@ -15,8 +17,9 @@ import (
 )
 
 const (
-	checksumLength = 4
-	version        = byte(0x00)
+	checksumLength   = 4
+	version          = byte(0x00) //旧版Base58Check地址的版本号
+	pubKeyHashLength = 20
 )
 
 type Wallet struct {
@@ -30,7 +33,11 @@ type Wallet struct {
 //3.func PublicKeyHash(pubKey []byte)
 //4.func Checksum(payload []byte) []byte
 //5.func (w Wallet) Address() []byte
-//6.func ValidateAddress(address string) bool
+//6.func ValidateAddress(address string) error
+//7.func PubKeyHashToAddress(pubHash []byte) []byte
+//8.func AddressToPubKeyHash(address string) []byte
+//9.func PubKeyHashToLegacyAddress(pubHash []byte) []byte
+//10.func DecodeAddress(address string) ([]byte, error)
 
 
 /*生成ECDSA公私钥对*/
@@ -42,9 +49,18 @@ func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
 	utils.Handle(err)
 
-	publicKey := append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...)
+	return *privateKey, pubKeyBytes(&privateKey.PublicKey)
+}
+
+/*公钥的字节表示：X、Y坐标各补足32字节后拼接*/
+//验证签名时从中间切分公钥，坐标有前导零字节时也必须定长
+func pubKeyBytes(pub *ecdsa.PublicKey) []byte {
+	pubKey := make([]byte, 64)
+	x, y := pub.X.Bytes(), pub.Y.Bytes()
+	copy(pubKey[32-len(x):32], x)
+	copy(pubKey[64-len(y):], y)
 
-	return *privateKey, publicKey
+	return pubKey
 }
 
 /*生成钱包对象*/
@@ -80,11 +96,34 @@ func Checksum(payload []byte) []byte {
 	return secondHash[:checksumLength]
 }
 
-/*将公钥哈希、校验码、版本号合一进行base58编码得到账户地址*/
-//publicKeyHash(ripemd160) + checksum + version -> base58 -> address
+/*由公钥哈希得到当前网络的Bech32账户地址*/
+//publicKeyHash(ripemd160) -> 5bit groups -> version + data + checksum -> bech32 -> address
 func (w Wallet) Address() []byte {
 	//取公钥哈希
 	pubHash := PublicKeyHash(w.WPublicKey)
+	//将之转换成地址
+	address := PubKeyHashToAddress(pubHash)
+
+	fmt.Printf("Pub Key: %x\n", w.WPublicKey)
+	fmt.Printf("Pub Hash: %x\n", pubHash)
+	fmt.Printf("Address: %x\n", address)
+
+	return address
+}
+
+/*将公钥哈希编码为带网络前缀的Bech32地址*/
+func PubKeyHashToAddress(pubHash []byte) []byte {
+	data, err := convertBits(pubHash, 8, 5, true)
+	utils.Handle(err)
+
+	address, err := Bech32Encode(addressHRP, append([]byte{addressVersion}, data...))
+	utils.Handle(err)
+
+	return []byte(address)
+}
+
+/*将公钥哈希、校验码、版本号合一进行base58编码得到旧版地址*/
+func PubKeyHashToLegacyAddress(pubHash []byte) []byte {
 	//将公钥哈希和版本号拼接成新slice切片
 	versionedHash := append([]byte{version}, pubHash...) //PubHash...表示将字节切片中的内容打散再做操作
 	//对包含了version和公钥哈希信息的slice取校验码
@@ -92,14 +131,82 @@ func (w Wallet) Address() []byte {
 
 	//再把校验码也给打散拼接上
 	fullHash := append(versionedHash, checksum...)
-	//将之转换成地址
-	address := utils.Base58Encode(fullHash)
 
-	fmt.Printf("Pub Key: %x\n", w.WPublicKey)
-	fmt.Printf("Pub Hash: %x\n", pubHash)
-	fmt.Printf("Address: %x\n", address)
+	return utils.Base58Encode(fullHash)
+}
 
-	return address
+/*从地址中取出公钥哈希，调用前应先验证地址*/
+func AddressToPubKeyHash(address string) []byte {
+	pubKeyHash, err := DecodeAddress(address)
+	utils.Handle(err)
+
+	return pubKeyHash
+}
+
+/*解码地址得到公钥哈希，同时支持Bech32地址和旧版Base58Check地址*/
+//Bech32地址的网络前缀必须与当前网络一致
+func DecodeAddress(address string) ([]byte, error) {
+	if address == "" {
+		return nil, errors.New("address is empty")
+	}
+
+	//Bech32地址总是以 前缀+分隔符 开头，而旧版地址的版本号为0，总是以'1'开头，二者不会混淆
+	lower := strings.ToLower(address)
+	if strings.HasPrefix(lower, addressHRP+string(bech32Separator)) {
+		return decodeBech32Address(address)
+	}
+	for _, hrp := range []string{MainNetHRP, TestNetHRP} {
+		if strings.HasPrefix(lower, hrp+string(bech32Separator)) {
+			return nil, fmt.Errorf("address is for network %q, expected %q", hrp, addressHRP)
+		}
+	}
+
+	return decodeLegacyAddress(address)
+}
+
+func decodeBech32Address(address string) ([]byte, error) {
+	_, data, err := Bech32Decode(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 || data[0] != addressVersion {
+		return nil, errors.New("unsupported address version")
+	}
+
+	pubKeyHash, err := convertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return nil, fmt.Errorf("address data is malformed: %v", err)
+	}
+	if len(pubKeyHash) != pubKeyHashLength {
+		return nil, fmt.Errorf("address holds %d bytes, expected %d", len(pubKeyHash), pubKeyHashLength)
+	}
+
+	return pubKeyHash, nil
+}
+
+func decodeLegacyAddress(address string) ([]byte, error) {
+	//由字符串钱包地址解码得到所谓的公钥哈希（加入了校验码和版本号的）
+	fullHash, err := utils.TryBase58Decode([]byte(address))
+	if err != nil {
+		return nil, errors.New("address is neither bech32 nor base58")
+	}
+	if len(fullHash) != 1+pubKeyHashLength+checksumLength {
+		return nil, fmt.Errorf("address holds %d bytes, expected %d", len(fullHash), 1+pubKeyHashLength+checksumLength)
+	}
+	if fullHash[0] != version {
+		return nil, errors.New("unsupported address version")
+	}
+
+	//取出检验码
+	actualChecksum := fullHash[len(fullHash)-checksumLength:]
+	//将得到的实际公钥哈希在本地进行一次计算校验码
+	targetChecksum := Checksum(fullHash[:len(fullHash)-checksumLength])
+	if bytes.Compare(actualChecksum, targetChecksum) != 0 {
+		return nil, errors.New("address checksum mismatch, check for typos")
+	}
+
+	return fullHash[1 : len(fullHash)-checksumLength], nil
 }
 
 /*账户（钱包创建流程）*/
@@ -116,21 +223,11 @@ func (w Wallet) Address() []byte {
 //[Pub Key Hash]
 //[CheckSum]
 
-/*验证钱包地址是否是合法的钱包地址*/
-func ValidateAddress(address string) bool {
-	//由字符串钱包地址解码得到所谓的公钥哈希（加入了校验码和版本号的）
-	pubKeyHash := utils.Base58Decode([]byte(address))
-	//取出检验码
-	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
-	//取出版本号
-	version := pubKeyHash[0]
-	//取出实际上的公钥哈希（sha256+ripemd160）
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
-	//将得到的实际公钥哈希在本地进行一次计算校验码
-	targetChecksum := Checksum(append([]byte{version}, pubKeyHash...))
+/*验证钱包地址是否是合法的钱包地址，不合法时返回具体原因*/
+func ValidateAddress(address string) error {
+	_, err := DecodeAddress(address)
 
-	//返回两个校验码是否相等，相等说明钱包地址有效
-	return bytes.Compare(actualChecksum, targetChecksum) == 0
+	return err
 }
 
 //神秘的比特币地址详解
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"crypto/elliptic"
 	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/azd1997/golang-MimbleWimble-try/utils"
 	"io/ioutil"
@@ -14,16 +16,19 @@ const walletFile = "./tmp/wallets/wallets_%s.data" //根据不同nodeId生成不
 
 //注意wallets是要一直维护的，所以所有调用其的操作需要改变其内容时，一定要用指针
 type Wallets struct {
-	WalletsMap map[string]*Wallet
+	WalletsMap   map[string]*Wallet
+	WatchOnlyMap map[string]*WatchOnly //只读地址，不持有私钥
 }
 
 //方法列表
 //1.func (ws *Wallets) SaveFile()
 //2.func (ws *Wallets) LoadFile() error
 //3.func CreateWallets() (*Wallets, error)
-//4.func (ws *Wallets) GetWallet(address string) Wallet
+//4.func (ws *Wallets) GetWallet(address string) (Wallet, error)
 //5.func (ws *Wallets) GetAllAddress() []string
 //6.func (ws *Wallets) AddWallet() string
+//7.func (ws *Wallets) GetPubKeyHashes() map[string]string
+//8.func (ws *Wallets) IsWatchOnly(address string) bool
 
 
 
@@ -63,6 +68,11 @@ func (ws *Wallets) LoadFile(nodeId string) error {
 	utils.Handle(err)
 
 	ws.WalletsMap = wallets.WalletsMap
+	//旧的钱包文件中没有只读地址
+	ws.WatchOnlyMap = wallets.WatchOnlyMap
+	if ws.WatchOnlyMap == nil {
+		ws.WatchOnlyMap = make(map[string]*WatchOnly)
+	}
 
 	return nil
 }
@@ -71,15 +81,32 @@ func (ws *Wallets) LoadFile(nodeId string) error {
 func CreateWallets(nodeId string) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.WalletsMap = make(map[string]*Wallet)
+	wallets.WatchOnlyMap = make(map[string]*WatchOnly)
 
 	err := wallets.LoadFile(nodeId)
 
 	return &wallets, err
 }
 
-/*将钱包地址作为键，从钱包字典中查找对应钱包*/
-func (ws *Wallets) GetWallet(address string) Wallet {
-	return *ws.WalletsMap[address]
+/*根据地址查找钱包，地址可以是Bech32或旧版Base58形式，找不到时返回错误*/
+//钱包字典以创建时的地址形式为键，同一公钥哈希的另一种地址形式需按公钥哈希匹配
+func (ws *Wallets) GetWallet(address string) (Wallet, error) {
+	if wallet, ok := ws.WalletsMap[address]; ok {
+		return *wallet, nil
+	}
+
+	pubKeyHash, err := DecodeAddress(address)
+	if err != nil {
+		return Wallet{}, err
+	}
+
+	for _, wallet := range ws.WalletsMap {
+		if bytes.Equal(PublicKeyHash(wallet.WPublicKey), pubKeyHash) {
+			return *wallet, nil
+		}
+	}
+
+	return Wallet{}, errors.New("private key for address is not known")
 }
 
 /*从钱包字典获取所有钱包地址，并存入钱包地址的切片数组中*/
@@ -102,3 +129,24 @@ func (ws *Wallets) AddWallet() string {
 
 	return address
 }
+
+/*返回钱包字典和只读地址中所有公钥哈希(hex)到地址的映射，用以在区块中识别属于本钱包的输入输出*/
+func (ws *Wallets) GetPubKeyHashes() map[string]string {
+	pubKeyHashes := make(map[string]string)
+
+	for address, wallet := range ws.WalletsMap {
+		pubKeyHashes[hex.EncodeToString(PublicKeyHash(wallet.WPublicKey))] = address
+	}
+
+	for address, watchOnly := range ws.WatchOnlyMap {
+		pubKeyHashes[hex.EncodeToString(watchOnly.PubKeyHash)] = address
+	}
+
+	return pubKeyHashes
+}
+
+/*检查地址是否是只读地址*/
+func (ws *Wallets) IsWatchOnly(address string) bool {
+	_, err := ws.GetWatchOnly(address)
+	return err == nil
+}
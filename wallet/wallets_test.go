@@ -0,0 +1,52 @@
+package wallet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGetWalletAddressForms(t *testing.T) {
+	ws := newTestWallets()
+
+	//旧版钱包文件中的钱包以Base58地址为键
+	w := MakeWallet()
+	pubKeyHash := PublicKeyHash(w.WPublicKey)
+	legacy := string(PubKeyHashToLegacyAddress(pubKeyHash))
+	ws.WalletsMap[legacy] = w
+
+	for _, address := range []string{legacy, string(PubKeyHashToAddress(pubKeyHash))} {
+		found, err := ws.GetWallet(address)
+		if err != nil {
+			t.Fatalf("%s: %v", address, err)
+		}
+		if !bytes.Equal(found.WPublicKey, w.WPublicKey) {
+			t.Errorf("%s: found another wallet", address)
+		}
+	}
+
+	other := string(PubKeyHashToAddress(PublicKeyHash([]byte("other key"))))
+	for _, address := range []string{other, "not an address", ""} {
+		if _, err := ws.GetWallet(address); err == nil {
+			t.Errorf("%q: expected an error", address)
+		}
+	}
+
+	//以另一种形式重复导入只读地址不会产生两条记录
+	watched := PublicKeyHash([]byte("watched key"))
+	if _, err := ws.ImportAddress(string(PubKeyHashToLegacyAddress(watched))); err != nil {
+		t.Fatal(err)
+	}
+	address, err := ws.ImportAddress(string(PubKeyHashToAddress(watched)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != string(PubKeyHashToLegacyAddress(watched)) || len(ws.WatchOnlyMap) != 1 {
+		t.Errorf("reimport returned %s, %d watch-only addresses", address, len(ws.WatchOnlyMap))
+	}
+	if !ws.IsWatchOnly(string(PubKeyHashToAddress(watched))) {
+		t.Error("bech32 form of a watched address not found")
+	}
+	if _, err := ws.ImportAddress(string(PubKeyHashToAddress(pubKeyHash))); err == nil {
+		t.Error("address with a private key imported as watch-only")
+	}
+}
@@ -0,0 +1,111 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+//只读地址，用于监控冷钱包或客户充值地址，本机不持有其私钥
+//只导入地址时PublicKey为空，此时构造的交易输入也不含公钥，需由签名方补上
+type WatchOnly struct {
+	Address    string
+	PublicKey  []byte
+	PubKeyHash []byte
+}
+
+//方法列表
+//1.func (ws *Wallets) ImportAddress(address string) (string, error)
+//2.func (ws *Wallets) ImportPubKey(pubKey []byte) (string, error)
+//3.func (ws *Wallets) GetWatchOnly(address string) (WatchOnly, error)
+//4.func (ws *Wallets) GetWatchOnlyAddresses() []string
+
+/*导入只读地址*/
+func (ws *Wallets) ImportAddress(address string) (string, error) {
+	if err := ValidateAddress(address); err != nil {
+		return "", err
+	}
+
+	if _, err := ws.GetWallet(address); err == nil {
+		return "", errors.New("address already in wallet with private key")
+	}
+
+	//若已通过公钥或以另一种地址形式导入过，保留已有的只读地址
+	if watchOnly, err := ws.GetWatchOnly(address); err == nil {
+		return watchOnly.Address, nil
+	}
+
+	ws.WatchOnlyMap[address] = &WatchOnly{
+		Address:    address,
+		PubKeyHash: AddressToPubKeyHash(address),
+	}
+
+	return address, nil
+}
+
+/*导入公钥作为只读地址，返回对应地址*/
+func (ws *Wallets) ImportPubKey(pubKey []byte) (string, error) {
+	//公钥为各32字节的X、Y坐标拼接而成，且必须在曲线上
+	if len(pubKey) != 64 {
+		return "", fmt.Errorf("public key must be 64 bytes, got %d", len(pubKey))
+	}
+	x := new(big.Int).SetBytes(pubKey[:32])
+	y := new(big.Int).SetBytes(pubKey[32:])
+	if !elliptic.P256().IsOnCurve(x, y) {
+		return "", errors.New("public key is not a valid curve point")
+	}
+
+	pubKey = append([]byte(nil), pubKey...)
+	pubKeyHash := PublicKeyHash(pubKey)
+	address := fmt.Sprintf("%s", PubKeyHashToAddress(pubKeyHash))
+
+	if _, err := ws.GetWallet(address); err == nil {
+		return "", errors.New("address already in wallet with private key")
+	}
+
+	//以地址导入过的只读地址换用公钥导入后的记录
+	if watchOnly, err := ws.GetWatchOnly(address); err == nil {
+		delete(ws.WatchOnlyMap, watchOnly.Address)
+	}
+
+	ws.WatchOnlyMap[address] = &WatchOnly{
+		Address:    address,
+		PublicKey:  pubKey,
+		PubKeyHash: pubKeyHash,
+	}
+
+	return address, nil
+}
+
+/*根据地址查找只读地址，与GetWallet一样按公钥哈希匹配两种地址形式，找不到时返回错误*/
+func (ws *Wallets) GetWatchOnly(address string) (WatchOnly, error) {
+	if watchOnly, ok := ws.WatchOnlyMap[address]; ok {
+		return *watchOnly, nil
+	}
+
+	pubKeyHash, err := DecodeAddress(address)
+	if err != nil {
+		return WatchOnly{}, err
+	}
+
+	for _, watchOnly := range ws.WatchOnlyMap {
+		if bytes.Equal(watchOnly.PubKeyHash, pubKeyHash) {
+			return *watchOnly, nil
+		}
+	}
+
+	return WatchOnly{}, errors.New("address is not watched")
+}
+
+/*获取所有只读地址*/
+func (ws *Wallets) GetWatchOnlyAddresses() []string {
+	var addresses []string
+
+	for address := range ws.WatchOnlyMap {
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
@@ -0,0 +1,57 @@
+package wallet
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func newTestWallets() *Wallets {
+	return &Wallets{WalletsMap: make(map[string]*Wallet), WatchOnlyMap: make(map[string]*WatchOnly)}
+}
+
+func TestImportPubKey(t *testing.T) {
+	ws := newTestWallets()
+
+	//坐标有前导零字节的钱包公钥仍为64字节，导入后地址也与钱包地址一致
+	var w *Wallet
+	for i := 0; i < 10000; i++ {
+		w = MakeWallet()
+		if len(w.WPrivateKey.X.Bytes()) < 32 {
+			break
+		}
+	}
+	if len(w.WPublicKey) != 64 {
+		t.Fatalf("wallet public key of %d bytes", len(w.WPublicKey))
+	}
+
+	address, err := ws.ImportPubKey(w.WPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%s", w.Address()); address != want {
+		t.Errorf("address = %s, want %s", address, want)
+	}
+	if watchOnly, err := ws.GetWatchOnly(address); err != nil || !bytes.Equal(watchOnly.PublicKey, w.WPublicKey) {
+		t.Error("stored public key differs from the wallet's")
+	}
+
+	valid := MakeWallet().WPublicKey
+	offCurve := append([]byte(nil), valid...)
+	offCurve[63] ^= 1
+
+	invalid := map[string][]byte{
+		"empty":     nil,
+		"too short": valid[:63],
+		"too long":  append(append([]byte(nil), valid...), 0),
+		"off curve": offCurve,
+	}
+	for name, pubKey := range invalid {
+		if _, err := ws.ImportPubKey(pubKey); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if len(ws.WatchOnlyMap) != 1 {
+		t.Errorf("%d watch-only addresses, want 1", len(ws.WatchOnlyMap))
+	}
+}
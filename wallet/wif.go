@@ -0,0 +1,81 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"github.com/azd1997/golang-MimbleWimble-try/utils"
+	"math/big"
+)
+
+//私钥导出格式（WIF风格）：version + 32字节私钥 + 4字节校验码，再进行base58编码
+const (
+	wifVersion    = byte(0x80)
+	privKeyLength = 32
+)
+
+//方法列表
+//1.func (w Wallet) ExportPrivateKey() string
+//2.func ImportPrivateKey(wif string) (*Wallet, error)
+//3.func (ws *Wallets) ImportWallet(w *Wallet) string
+
+/*将私钥导出为带校验码的base58字符串*/
+func (w Wallet) ExportPrivateKey() string {
+	//私钥D补齐为32字节
+	payload := make([]byte, 1+privKeyLength)
+	payload[0] = wifVersion
+	d := w.WPrivateKey.D.Bytes()
+	copy(payload[1+privKeyLength-len(d):], d)
+
+	checksum := Checksum(payload)
+
+	return string(utils.Base58Encode(append(payload, checksum...)))
+}
+
+/*从导出的私钥字符串恢复钱包，公钥由私钥重新计算*/
+func ImportPrivateKey(wif string) (*Wallet, error) {
+	decoded, err := utils.TryBase58Decode([]byte(wif))
+	if err != nil {
+		return nil, fmt.Errorf("private key is not valid base58: %s", err)
+	}
+
+	if len(decoded) != 1+privKeyLength+checksumLength {
+		return nil, errors.New("private key has wrong length")
+	}
+
+	payload := decoded[:1+privKeyLength]
+	if bytes.Compare(Checksum(payload), decoded[1+privKeyLength:]) != 0 {
+		return nil, errors.New("private key checksum mismatch")
+	}
+
+	if payload[0] != wifVersion {
+		return nil, fmt.Errorf("unknown private key version 0x%02x", payload[0])
+	}
+
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(payload[1:])
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("private key is out of range")
+	}
+
+	privateKey := ecdsa.PrivateKey{D: d}
+	privateKey.PublicKey.Curve = curve
+	privateKey.PublicKey.X, privateKey.PublicKey.Y = curve.ScalarBaseMult(payload[1:])
+
+	return &Wallet{privateKey, pubKeyBytes(&privateKey.PublicKey)}, nil
+}
+
+/*将导入的钱包加入钱包字典，返回其地址。若该地址原为只读地址，则升级为完整钱包*/
+func (ws *Wallets) ImportWallet(w *Wallet) string {
+	address := fmt.Sprintf("%s", PubKeyHashToAddress(PublicKeyHash(w.WPublicKey)))
+
+	//只读地址可能以另一种地址形式保存，按公钥哈希查找后删除
+	if watchOnly, err := ws.GetWatchOnly(address); err == nil {
+		delete(ws.WatchOnlyMap, watchOnly.Address)
+	}
+	ws.WalletsMap[address] = w
+
+	return address
+}
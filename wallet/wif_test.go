@@ -0,0 +1,75 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"fmt"
+	"testing"
+
+	"github.com/azd1997/golang-MimbleWimble-try/utils"
+)
+
+/*按导出格式编码任意版本号和私钥，校验码正确*/
+func encodeWIF(version byte, d []byte) string {
+	payload := append([]byte{version}, d...)
+
+	return string(utils.Base58Encode(append(payload, Checksum(payload)...)))
+}
+
+func TestWIFRoundTrip(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		w := MakeWallet()
+		imported, err := ImportPrivateKey(w.ExportPrivateKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if imported.WPrivateKey.D.Cmp(w.WPrivateKey.D) != 0 || !bytes.Equal(imported.WPublicKey, w.WPublicKey) {
+			t.Fatalf("imported key differs from exported wallet %s", w.Address())
+		}
+	}
+}
+
+func TestImportPrivateKeyInvalid(t *testing.T) {
+	w := MakeWallet()
+	d := make([]byte, privKeyLength)
+	copy(d[privKeyLength-len(w.WPrivateKey.D.Bytes()):], w.WPrivateKey.D.Bytes())
+
+	decoded := utils.Base58Decode([]byte(w.ExportPrivateKey()))
+	decoded[len(decoded)-1] ^= 1
+	badChecksum := string(utils.Base58Encode(decoded))
+
+	n := elliptic.P256().Params().N.Bytes()
+
+	invalid := map[string]string{
+		"bad checksum":  badChecksum,
+		"wrong version": encodeWIF(wifVersion+1, d),
+		"zero scalar":   encodeWIF(wifVersion, make([]byte, privKeyLength)),
+		"curve order":   encodeWIF(wifVersion, n),
+		"too short":     encodeWIF(wifVersion, d[1:]),
+		"not base58":    "0OIl",
+	}
+	for name, wif := range invalid {
+		if _, err := ImportPrivateKey(wif); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+//以旧地址形式导入的只读地址，在导入私钥后被完整钱包取代
+func TestImportWalletReplacesWatchOnly(t *testing.T) {
+	ws := newTestWallets()
+	w := MakeWallet()
+
+	legacy := fmt.Sprintf("%s", PubKeyHashToLegacyAddress(PublicKeyHash(w.WPublicKey)))
+	if _, err := ws.ImportAddress(legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	address := ws.ImportWallet(w)
+	if len(ws.WatchOnlyMap) != 0 {
+		t.Errorf("%d watch-only addresses left after importing the private key", len(ws.WatchOnlyMap))
+	}
+	if _, err := ws.GetWallet(address); err != nil {
+		t.Error(err)
+	}
+}